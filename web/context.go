@@ -1,8 +1,11 @@
 package web
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -19,14 +22,79 @@ type Context struct {
 
 	RespData       []byte
 	RespStatusCode int
+	// Err 处理请求过程中出现的错误，例如被 recover 转换的 panic
+	// 错误处理的 middleware 可以根据它来渲染响应
+	Err error
 
 	MatchedRoute string
+
+	// RequestID 用于串联同一个请求的日志、trace 和响应
+	// 一般由 requestid middleware 设置
+	RequestID string
+	// CSPNonce 当前请求的 Content-Security-Policy nonce，一般由 secure middleware 设置
+	// 页面里面内联的 script 和 style 要带上它，Render 的时候模板引擎可以通过 CSPNonceFromContext 拿到
+	CSPNonce string
+	// UserValues 在 middleware 和业务代码之间传递数据，例如 CSRF token、登录用户
+	// 使用 SetUserValue 和 UserValue 读写
+	UserValues map[string]any
 	//cookieSamSite http.SameSite
 
 	// 缓存的数据
 	cacheQueryValues url.Values
 
 	tblEngine TemplateEngine
+
+	// 可信代理，用于解析 ClientIP、Scheme 和 Host
+	trustedProxies []*net.IPNet
+	proxyHeader    ProxyHeader
+
+	// 命中的路由的处理函数
+	handleFunc HandleFunc
+	// 命中的路由的元数据
+	routeInfo *RouteInfo
+
+	// logger 是 server 的日志，reqLogger 在它的基础上加上了请求相关的属性
+	logger    *slog.Logger
+	reqLogger *slog.Logger
+	// 生成 reqLogger 的时候的 RequestID，RequestID 变了就要重新生成
+	loggerReqID string
+}
+
+// Logger 返回带有 route、method 和 request_id 属性的日志
+// 推荐 middleware 和业务代码都使用这个来打日志，方便串联同一个请求的日志
+func (c *Context) Logger() *slog.Logger {
+	if c.reqLogger != nil && c.loggerReqID == c.RequestID {
+		return c.reqLogger
+	}
+	logger := c.logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	attrs := make([]any, 0, 3)
+	if c.MatchedRoute != "" {
+		attrs = append(attrs, slog.String("route", c.MatchedRoute))
+	}
+	if c.Req != nil {
+		attrs = append(attrs, slog.String("method", c.Req.Method))
+	}
+	if c.RequestID != "" {
+		attrs = append(attrs, slog.String("request_id", c.RequestID))
+	}
+	c.reqLogger = logger.With(attrs...)
+	c.loggerReqID = c.RequestID
+	return c.reqLogger
+}
+
+func (c *Context) SetUserValue(key string, val any) {
+	if c.UserValues == nil {
+		c.UserValues = make(map[string]any, 4)
+	}
+	c.UserValues[key] = val
+}
+
+func (c *Context) UserValue(key string) (any, bool) {
+	val, ok := c.UserValues[key]
+	return val, ok
 }
 
 func (c *Context) SetCookie(cookie *http.Cookie) {
@@ -41,7 +109,7 @@ func (c *Context) RespJSONOK(val any) error {
 
 func (c *Context) RespJSON(status int, val any) error {
 	data, err := json.Marshal(val)
-	if err == nil {
+	if err != nil {
 		return err
 	}
 
@@ -58,12 +126,16 @@ func (c *Context) RespJSON(status int, val any) error {
 	return err
 }
 
+// BindJSON 把请求体解析到 val
+// 解析失败的时候返回 *BindError，并且记录到 c.Err，业务直接返回就可以得到 400
+// 请求体超过了 MaxBodySize 的时候是 413
 func (c *Context) BindJSON(val any) error {
 	if val == nil {
 		return errors.New("web: 输入不能为nil")
 	}
 	if c.Req.Body == nil {
-		return errors.New("web: body 不能为nil")
+		c.Err = &BindError{Err: errors.New("web: body 不能为nil")}
+		return c.Err
 	}
 	// 不要用这种写法
 	//bs, _ := io.ReadAll(c.Req.Body)
@@ -80,7 +152,13 @@ func (c *Context) BindJSON(val any) error {
 	// JSON里面多了一个Age字段，就会报错
 	//decoder.DisallowUnknownFields()
 
-	return decoder.Decode(val)
+	if err := decoder.Decode(val); err != nil {
+		c.Err = &BindError{Err: err}
+		// 请求体超过了限制，让后面的 middleware 也能看到 413
+		respTooLargeIfNeeded(c)
+		return c.Err
+	}
+	return nil
 }
 
 // Form 包含query这些其他的参数，所有的表单数据都能拿到
@@ -165,8 +243,16 @@ func (s StringValue) AsInt64() (int64, error) {
 }
 
 func (c *Context) Render(tblName string, data any) error {
+	if c.tblEngine == nil {
+		c.RespStatusCode = http.StatusInternalServerError
+		return errors.New("web: 没有设置模板引擎")
+	}
+	reqCtx := c.Req.Context()
+	if c.CSPNonce != "" {
+		reqCtx = context.WithValue(reqCtx, cspNonceKey{}, c.CSPNonce)
+	}
 	var err error
-	c.RespData, err = c.tblEngine.Render(c.Req.Context(), tblName, data)
+	c.RespData, err = c.tblEngine.Render(reqCtx, tblName, data)
 
 	if err != nil {
 		c.RespStatusCode = http.StatusInternalServerError
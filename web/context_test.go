@@ -0,0 +1,84 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestContext_Logger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := slog.New(slog.NewJSONHandler(buf, nil))
+	server := NewHttpServer(ServerWithLogger(logger), ServerWithMiddleware(
+		func(next HandleFunc) HandleFunc {
+			return func(ctx *Context) {
+				// 执行 middleware 的时候就已经知道命中的路由了
+				assert.Equal(t, "/user/:id", ctx.MatchedRoute)
+				ctx.Logger().Info("before")
+				ctx.RequestID = "abc-123"
+				next(ctx)
+			}
+		}))
+	server.Get("/user/:id", func(ctx *Context) {
+		ctx.Logger().Info("handle", slog.String("id", ctx.PathParams["id"]))
+	})
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/user/123", nil))
+
+	dec := json.NewDecoder(buf)
+	var before, handle map[string]any
+	require.NoError(t, dec.Decode(&before))
+	require.NoError(t, dec.Decode(&handle))
+
+	assert.Equal(t, "before", before["msg"])
+	assert.Equal(t, "/user/:id", before["route"])
+	assert.Equal(t, http.MethodGet, before["method"])
+	assert.NotContains(t, before, "request_id")
+
+	// RequestID 变了之后，日志里面也要跟着变
+	assert.Equal(t, "handle", handle["msg"])
+	assert.Equal(t, "/user/:id", handle["route"])
+	assert.Equal(t, "abc-123", handle["request_id"])
+	assert.Equal(t, "123", handle["id"])
+}
+
+func TestContext_UserValue(t *testing.T) {
+	ctx := &Context{}
+	_, ok := ctx.UserValue("user")
+	assert.False(t, ok)
+
+	ctx.SetUserValue("user", 123)
+	val, ok := ctx.UserValue("user")
+	assert.True(t, ok)
+	assert.Equal(t, 123, val)
+}
+
+type nonceEngine struct{}
+
+func (nonceEngine) Render(ctx context.Context, tplName string, data any) ([]byte, error) {
+	return []byte(`<script nonce="` + CSPNonceFromContext(ctx) + `"></script>`), nil
+}
+
+func TestContext_RenderCSPNonce(t *testing.T) {
+	server := NewHttpServer(ServerWithTemplateEngine(nonceEngine{}))
+	server.Get("/page", func(ctx *Context) {
+		ctx.CSPNonce = "abc"
+		_ = ctx.Render("page", nil)
+	})
+	server.Get("/none", func(ctx *Context) {
+		_ = ctx.Render("page", nil)
+	})
+
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/page", nil))
+	assert.Equal(t, `<script nonce="abc"></script>`, resp.Body.String())
+
+	resp = httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/none", nil))
+	assert.Equal(t, `<script nonce=""></script>`, resp.Body.String())
+}
@@ -0,0 +1,118 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+// defaultReadHeaderTimeout 默认的读取请求头的超时时间，防止 slowloris 攻击
+const defaultReadHeaderTimeout = 10 * time.Second
+
+// httpConfig 对应 http.Server 里面的同名字段，零值代表不限制
+type httpConfig struct {
+	readHeaderTimeout time.Duration
+	readTimeout       time.Duration
+	writeTimeout      time.Duration
+	idleTimeout       time.Duration
+	maxHeaderBytes    int
+}
+
+func (h *HttpServer) newHTTPServer(handler http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: h.httpCfg.readHeaderTimeout,
+		ReadTimeout:       h.httpCfg.readTimeout,
+		WriteTimeout:      h.httpCfg.writeTimeout,
+		IdleTimeout:       h.httpCfg.idleTimeout,
+		MaxHeaderBytes:    h.httpCfg.maxHeaderBytes,
+	}
+}
+
+// ServerWithReadHeaderTimeout 读取请求头的超时时间，默认是 10 秒，0 代表不限制
+func ServerWithReadHeaderTimeout(timeout time.Duration) HTTPServerOption {
+	return func(server *HttpServer) {
+		server.httpCfg.readHeaderTimeout = timeout
+	}
+}
+
+// ServerWithReadTimeout 读取整个请求的超时时间，包括请求体
+func ServerWithReadTimeout(timeout time.Duration) HTTPServerOption {
+	return func(server *HttpServer) {
+		server.httpCfg.readTimeout = timeout
+	}
+}
+
+// ServerWithWriteTimeout 从读完请求头到写完响应的超时时间
+// 有 SSE 之类的长连接的时候要注意
+func ServerWithWriteTimeout(timeout time.Duration) HTTPServerOption {
+	return func(server *HttpServer) {
+		server.httpCfg.writeTimeout = timeout
+	}
+}
+
+// ServerWithIdleTimeout keep-alive 的连接空闲多久之后关闭
+func ServerWithIdleTimeout(timeout time.Duration) HTTPServerOption {
+	return func(server *HttpServer) {
+		server.httpCfg.idleTimeout = timeout
+	}
+}
+
+// ServerWithMaxHeaderBytes 请求头的最大字节数，0 的时候使用 http.DefaultMaxHeaderBytes
+func ServerWithMaxHeaderBytes(n int) HTTPServerOption {
+	return func(server *HttpServer) {
+		server.httpCfg.maxHeaderBytes = n
+	}
+}
+
+// ServerWithMaxBodySize 请求体的最大字节数，超过的时候返回 413
+// 路由可以通过 WithMaxBodySize 单独设置
+func ServerWithMaxBodySize(n int64) HTTPServerOption {
+	return func(server *HttpServer) {
+		server.maxBodySize = n
+	}
+}
+
+// WithMaxBodySize 单独设置路由的请求体的最大字节数，例如上传文件的接口
+// 小于 0 的时候不限制
+func WithMaxBodySize(n int64) RouteOption {
+	return func(info *RouteInfo) {
+		info.MaxBodySize = n
+	}
+}
+
+// limitBody 限制请求体的大小
+// Content-Length 已经超过限制的直接返回 413，不会执行业务代码
+// 否则在读取的时候限制，超过了 BindJSON 之类的方法会返回 *http.MaxBytesError
+// 没有命中路由的不处理，要返回 404 或者 405
+func (h *HttpServer) limitBody(ctx *Context) {
+	if ctx.handleFunc == nil {
+		return
+	}
+	limit := h.maxBodySize
+	if ctx.routeInfo != nil && ctx.routeInfo.MaxBodySize != 0 {
+		limit = ctx.routeInfo.MaxBodySize
+	}
+	req := ctx.Req
+	if limit <= 0 || req.Body == nil || req.Body == http.NoBody {
+		return
+	}
+	if req.ContentLength > limit {
+		ctx.handleFunc = respTooLarge
+		return
+	}
+	req.Body = http.MaxBytesReader(ctx.Resp, req.Body, limit)
+}
+
+// respTooLargeIfNeeded 没有 Content-Length 的请求，读取的时候才发现超过了限制
+// 不管有没有开启 ServerWithProblemDetails 都要返回 413
+func respTooLargeIfNeeded(ctx *Context) {
+	var maxBytesErr *http.MaxBytesError
+	if ctx.Err != nil && errors.As(ctx.Err, &maxBytesErr) {
+		ctx.RespStatusCode = http.StatusRequestEntityTooLarge
+	}
+}
+
+func respTooLarge(ctx *Context) {
+	ctx.RespStatusCode = http.StatusRequestEntityTooLarge
+}
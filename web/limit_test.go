@@ -0,0 +1,138 @@
+package web
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHttpServer_MaxBodySize(t *testing.T) {
+	h := NewHttpServer(ServerWithMaxBodySize(10), ServerWithProblemDetails(), ServerWithMethodNotAllowed())
+	var called bool
+	bind := func(ctx *Context) {
+		called = true
+		var val map[string]any
+		if err := ctx.BindJSON(&val); err != nil {
+			return
+		}
+		ctx.RespData = []byte("ok")
+	}
+	h.Post("/user", bind)
+	h.Post("/upload", bind, WithMaxBodySize(100))
+	h.Post("/unlimited", bind, WithMaxBodySize(-1))
+
+	large := `{"name":"` + strings.Repeat("a", 20) + `"}`
+	testCases := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		chunked    bool
+		wantCode   int
+		wantCalled bool
+	}{
+		{name: "small", path: "/user", body: `{"a":1}`, wantCode: http.StatusOK, wantCalled: true},
+		// 根据 Content-Length 直接拒绝
+		{name: "content length", path: "/user", body: large, wantCode: http.StatusRequestEntityTooLarge},
+		// 没有 Content-Length，读取的时候才发现
+		{name: "chunked", path: "/user", body: large, chunked: true,
+			wantCode: http.StatusRequestEntityTooLarge, wantCalled: true},
+		{name: "route", path: "/upload", body: large, wantCode: http.StatusOK, wantCalled: true},
+		{name: "unlimited", path: "/unlimited", body: large, chunked: true, wantCode: http.StatusOK, wantCalled: true},
+		// 没有命中路由的不能返回 413
+		{name: "not found", path: "/unknown", body: large, wantCode: http.StatusNotFound},
+		{name: "method not allowed", method: http.MethodPut, path: "/user", body: large, wantCode: http.StatusMethodNotAllowed},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			called = false
+			var body io.Reader = strings.NewReader(tc.body)
+			if tc.chunked {
+				// 隐藏 Len 方法，httptest 就不会设置 Content-Length
+				body = io.MultiReader(body)
+			}
+			method := tc.method
+			if method == "" {
+				method = http.MethodPost
+			}
+			req := httptest.NewRequest(method, tc.path, body)
+			resp := httptest.NewRecorder()
+			h.ServeHTTP(resp, req)
+			assert.Equal(t, tc.wantCode, resp.Code)
+			assert.Equal(t, tc.wantCalled, called)
+			if tc.wantCode == http.StatusRequestEntityTooLarge {
+				assert.Equal(t, ProblemContentType, resp.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestHttpServer_MaxBodySizeWithoutProblem(t *testing.T) {
+	// 没有开启 ProblemDetails 的时候，读取的时候才发现超过限制也是 413
+	h := NewHttpServer(ServerWithMaxBodySize(10))
+	var status int
+	h.Use(func(next HandleFunc) HandleFunc {
+		return func(ctx *Context) {
+			next(ctx)
+			status = ctx.RespStatusCode
+		}
+	})
+	h.Post("/bind", func(ctx *Context) {
+		var val map[string]any
+		_ = ctx.BindJSON(&val)
+	})
+	h.Post("/read", func(ctx *Context) {
+		if _, err := io.ReadAll(ctx.Req.Body); err != nil {
+			ctx.Err = err
+		}
+	})
+	large := `{"name":"` + strings.Repeat("a", 20) + `"}`
+	for _, path := range []string{"/bind", "/read"} {
+		t.Run(path, func(t *testing.T) {
+			status = 0
+			req := httptest.NewRequest(http.MethodPost, path, io.MultiReader(strings.NewReader(large)))
+			resp := httptest.NewRecorder()
+			h.ServeHTTP(resp, req)
+			assert.Equal(t, http.StatusRequestEntityTooLarge, resp.Code)
+			assert.Empty(t, resp.Body.String())
+			if path == "/bind" {
+				// BindJSON 已经设置好了，middleware 也能看到
+				assert.Equal(t, http.StatusRequestEntityTooLarge, status)
+			}
+		})
+	}
+}
+
+func TestProblemFromError_MaxBytes(t *testing.T) {
+	err := &BindError{Err: &http.MaxBytesError{Limit: 10}}
+	p := ProblemFromError(err, http.StatusBadRequest)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, p.Status)
+	assert.Empty(t, p.Detail)
+	assert.True(t, errors.As(err, new(*http.MaxBytesError)))
+}
+
+func TestHttpServer_newHTTPServer(t *testing.T) {
+	h := NewHttpServer()
+	srv := h.newHTTPServer(h)
+	assert.Equal(t, defaultReadHeaderTimeout, srv.ReadHeaderTimeout)
+	assert.Zero(t, srv.ReadTimeout)
+
+	h = NewHttpServer(
+		ServerWithReadHeaderTimeout(time.Second),
+		ServerWithReadTimeout(2*time.Second),
+		ServerWithWriteTimeout(3*time.Second),
+		ServerWithIdleTimeout(4*time.Second),
+		ServerWithMaxHeaderBytes(1024))
+	srv = h.newHTTPServer(h)
+	assert.Equal(t, time.Second, srv.ReadHeaderTimeout)
+	assert.Equal(t, 2*time.Second, srv.ReadTimeout)
+	assert.Equal(t, 3*time.Second, srv.WriteTimeout)
+	assert.Equal(t, 4*time.Second, srv.IdleTimeout)
+	assert.Equal(t, 1024, srv.MaxHeaderBytes)
+	assert.Equal(t, http.Handler(h), srv.Handler)
+}
@@ -0,0 +1,108 @@
+package web
+
+import (
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net"
+	"net/http"
+)
+
+type metricsConfig struct {
+	registerer prometheus.Registerer
+	gatherer   prometheus.Gatherer
+	adminAddr  string
+}
+
+type MetricsOption func(cfg *metricsConfig)
+
+// MetricsWithRegistry 使用自定义的 Registry，默认是 prometheus 全局的
+func MetricsWithRegistry(reg *prometheus.Registry) MetricsOption {
+	return func(cfg *metricsConfig) {
+		cfg.registerer = reg
+		cfg.gatherer = reg
+	}
+}
+
+// MetricsWithAdminAddr 在单独的地址上暴露指标，例如 :9090
+// 这样指标就不会暴露在业务端口上，Start 的时候启动，Shutdown 的时候关闭
+func MetricsWithAdminAddr(addr string) MetricsOption {
+	return func(cfg *metricsConfig) {
+		cfg.adminAddr = addr
+	}
+}
+
+// MetricsEndpoint 暴露 Prometheus 指标，支持 text 和 OpenMetrics 两种格式
+// 同时会注册框架内部的指标：路由数量、被恢复的 panic 数量和活跃连接数
+func (h *HttpServer) MetricsEndpoint(path string, opts ...MetricsOption) {
+	cfg := &metricsConfig{
+		registerer: prometheus.DefaultRegisterer,
+		gatherer:   prometheus.DefaultGatherer,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	h.registerFrameworkMetrics(cfg.registerer)
+
+	handler := promhttp.HandlerFor(cfg.gatherer, promhttp.HandlerOpts{
+		EnableOpenMetrics: true,
+	})
+	if cfg.adminAddr == "" {
+		h.Get(path, func(ctx *Context) {
+			handler.ServeHTTP(ctx.Resp, ctx.Req)
+		})
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(path, handler)
+	h.mutex.Lock()
+	h.admin = h.newHTTPServer(mux)
+	h.admin.Addr = cfg.adminAddr
+	h.mutex.Unlock()
+}
+
+func (h *HttpServer) registerFrameworkMetrics(reg prometheus.Registerer) {
+	collectors := []prometheus.Collector{
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: "web",
+			Name:      "routes",
+			Help:      "注册的路由数量",
+		}, func() float64 {
+			return float64(h.routeCount())
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: "web",
+			Name:      "panics_recovered_total",
+			Help:      "被 recover middleware 恢复的 panic 数量",
+		}, func() float64 {
+			return float64(PanicsRecovered())
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: "web",
+			Name:      "active_connections",
+			Help:      "当前活跃的连接数",
+		}, func() float64 {
+			return float64(h.activeConns.Load())
+		}),
+	}
+	for _, c := range collectors {
+		if err := reg.Register(c); err != nil {
+			var are prometheus.AlreadyRegisteredError
+			// 同一个 Registry 上面重复调用，忽略就可以
+			if !errors.As(err, &are) {
+				panic(err)
+			}
+		}
+	}
+}
+
+// trackConn 统计活跃连接数，设置到 http.Server.ConnState 上
+func (h *HttpServer) trackConn(_ net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		h.activeConns.Add(1)
+	case http.StateClosed, http.StateHijacked:
+		h.activeConns.Add(-1)
+	}
+}
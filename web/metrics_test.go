@@ -0,0 +1,97 @@
+package web
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHttpServer_MetricsEndpoint(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	server := NewHttpServer()
+	server.Get("/user", func(ctx *Context) {})
+	server.Post("/user/:id", func(ctx *Context) {})
+	server.MetricsEndpoint("/metrics", MetricsWithRegistry(reg))
+	// 重复调用不会 panic
+	server.MetricsEndpoint("/metrics2", MetricsWithRegistry(reg))
+
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	body := recorder.Body.String()
+	assert.Contains(t, body, "web_routes 4\n")
+	assert.Contains(t, body, "web_panics_recovered_total ")
+	assert.Contains(t, body, "web_active_connections 0\n")
+
+	// OpenMetrics
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+	assert.Contains(t, recorder.Header().Get("Content-Type"), "application/openmetrics-text")
+	assert.Contains(t, recorder.Body.String(), "# EOF\n")
+}
+
+func TestHttpServer_MetricsEndpointAdmin(t *testing.T) {
+	addr, adminAddr := freeAddr(t), freeAddr(t)
+	reg := prometheus.NewRegistry()
+	server := NewHttpServer()
+	server.Get("/user", func(ctx *Context) {
+		ctx.RespData = []byte("user")
+	})
+	server.MetricsEndpoint("/metrics", MetricsWithRegistry(reg), MetricsWithAdminAddr(adminAddr))
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.Start(addr)
+	}()
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", adminAddr)
+		if err != nil {
+			return false
+		}
+		_ = conn.Close()
+		return true
+	}, time.Second, 10*time.Millisecond)
+
+	// 业务端口上没有指标
+	resp, err := http.Get("http://" + addr + "/metrics")
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// 保持一个连接
+	client := &http.Client{}
+	resp, err = client.Get("http://" + addr + "/user")
+	require.NoError(t, err)
+	_, _ = io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+
+	resp, err = http.Get("http://" + adminAddr + "/metrics")
+	require.NoError(t, err)
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Contains(t, string(data), "web_routes 1\n")
+	assert.Regexp(t, `web_active_connections [1-9]`, string(data))
+
+	client.CloseIdleConnections()
+	require.NoError(t, server.Shutdown(context.Background()))
+	assert.Equal(t, http.ErrServerClosed, <-errCh)
+	_, err = http.Get("http://" + adminAddr + "/metrics")
+	assert.Error(t, err)
+}
+
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	return l.Addr().String()
+}
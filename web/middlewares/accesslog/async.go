@@ -0,0 +1,176 @@
+package accesslog
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// AsyncWriter 异步批量写日志，避免在请求的关键路径上做 IO
+// 用法：
+//
+//	w := NewAsyncWriter(file)
+//	builder.LogFunc(w.Log)
+//	server.RegisterOnShutdown(w.Shutdown)
+type AsyncWriter struct {
+	w     io.Writer
+	queue chan string
+
+	batchSize     int
+	flushInterval time.Duration
+
+	// dropped 队列满了或者已经关闭之后被丢弃的日志条数
+	dropped atomic.Uint64
+	// failed 写入失败的日志条数
+	failed atomic.Uint64
+
+	// mutex 保护 closed，Shutdown 之后 Log 不会再往队列里面放日志
+	// 否则 loop 已经退出了，放进去的日志就悄无声息地丢了
+	mutex   sync.RWMutex
+	closed  bool
+	closing chan struct{}
+	done    chan struct{}
+}
+
+type AsyncWriterOption func(w *AsyncWriter)
+
+// AsyncWithQueueSize 队列的容量，满了之后新的日志会被丢弃，必须大于 0
+func AsyncWithQueueSize(size int) AsyncWriterOption {
+	return func(w *AsyncWriter) {
+		w.queue = make(chan string, size)
+	}
+}
+
+// AsyncWithBatchSize 攒够多少条日志写一次
+func AsyncWithBatchSize(size int) AsyncWriterOption {
+	return func(w *AsyncWriter) {
+		w.batchSize = size
+	}
+}
+
+// AsyncWithFlushInterval 没有攒够一批的时候，最多等待多久就写一次，必须大于 0
+func AsyncWithFlushInterval(interval time.Duration) AsyncWriterOption {
+	return func(w *AsyncWriter) {
+		w.flushInterval = interval
+	}
+}
+
+func NewAsyncWriter(w io.Writer, opts ...AsyncWriterOption) *AsyncWriter {
+	res := &AsyncWriter{
+		w:             w,
+		queue:         make(chan string, 4096),
+		batchSize:     128,
+		flushInterval: time.Second,
+		closing:       make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	// 在后台的 goroutine 里面 panic 的话没有办法 recover，整个进程都会退出
+	if res.flushInterval <= 0 {
+		panic(fmt.Sprintf("accesslog: flushInterval 必须大于 0，实际是 %s", res.flushInterval))
+	}
+	// 没有缓冲的队列，loop 正在写入的时候日志全部都会被丢弃
+	if cap(res.queue) <= 0 {
+		panic("accesslog: 队列的容量必须大于 0")
+	}
+	go res.loop()
+	return res
+}
+
+// Log 可以直接作为 MiddlewareBuilder.LogFunc 使用，永远不会阻塞
+func (a *AsyncWriter) Log(line string) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	if a.closed {
+		a.dropped.Add(1)
+		return
+	}
+	select {
+	case a.queue <- line:
+	default:
+		a.dropped.Add(1)
+	}
+}
+
+// Dropped 返回被丢弃的日志条数
+func (a *AsyncWriter) Dropped() uint64 {
+	return a.dropped.Load()
+}
+
+// Failed 返回写入失败的日志条数
+func (a *AsyncWriter) Failed() uint64 {
+	return a.failed.Load()
+}
+
+// Shutdown 把队列里面的日志全部写完再返回
+// 可以直接注册到 HttpServer.RegisterOnShutdown
+func (a *AsyncWriter) Shutdown(ctx context.Context) error {
+	a.mutex.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.closing)
+	}
+	a.mutex.Unlock()
+	select {
+	case <-a.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (a *AsyncWriter) loop() {
+	defer close(a.done)
+	ticker := time.NewTicker(a.flushInterval)
+	defer ticker.Stop()
+
+	var buf bytes.Buffer
+	cnt := 0
+	add := func(line string) {
+		buf.WriteString(line)
+		if len(line) == 0 || line[len(line)-1] != '\n' {
+			buf.WriteByte('\n')
+		}
+		cnt++
+		if cnt >= a.batchSize {
+			a.flush(&buf, &cnt)
+		}
+	}
+
+	for {
+		select {
+		case line := <-a.queue:
+			add(line)
+		case <-ticker.C:
+			a.flush(&buf, &cnt)
+		case <-a.closing:
+			// 把剩下的都写进去
+			for {
+				select {
+				case line := <-a.queue:
+					add(line)
+				default:
+					a.flush(&buf, &cnt)
+					return
+				}
+			}
+		}
+	}
+}
+
+func (a *AsyncWriter) flush(buf *bytes.Buffer, cnt *int) {
+	if *cnt == 0 {
+		return
+	}
+	if _, err := a.w.Write(buf.Bytes()); err != nil {
+		a.failed.Add(uint64(*cnt))
+	}
+	buf.Reset()
+	*cnt = 0
+}
@@ -0,0 +1,144 @@
+package accesslog
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"web"
+)
+
+// syncBuffer 测试用的并发安全的 buffer
+type syncBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+	// block 不为 nil 的时候，写入会被阻塞
+	block  chan struct{}
+	writes int
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	if b.block != nil {
+		<-b.block
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.writes++
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.String()
+}
+
+func TestAsyncWriter_Batch(t *testing.T) {
+	buf := &syncBuffer{}
+	w := NewAsyncWriter(buf, AsyncWithBatchSize(2), AsyncWithFlushInterval(time.Hour))
+	w.Log("a")
+	w.Log("b\n")
+	w.Log("c")
+
+	// 攒够了两条就会写一次
+	assert.Eventually(t, func() bool {
+		return buf.String() == "a\nb\n"
+	}, time.Second, time.Millisecond)
+
+	// 退出的时候剩下的也要写进去
+	require.NoError(t, w.Shutdown(context.Background()))
+	assert.Equal(t, "a\nb\nc\n", buf.String())
+	assert.Equal(t, 2, buf.writes)
+
+	// 关闭之后的日志会被丢弃
+	w.Log("d")
+	assert.Equal(t, uint64(1), w.Dropped())
+}
+
+func TestAsyncWriter_Interval(t *testing.T) {
+	buf := &syncBuffer{}
+	w := NewAsyncWriter(buf, AsyncWithFlushInterval(10*time.Millisecond))
+	w.Log("a")
+	assert.Eventually(t, func() bool {
+		return buf.String() == "a\n"
+	}, time.Second, time.Millisecond)
+	require.NoError(t, w.Shutdown(context.Background()))
+}
+
+func TestAsyncWriter_Drop(t *testing.T) {
+	buf := &syncBuffer{block: make(chan struct{})}
+	w := NewAsyncWriter(buf, AsyncWithQueueSize(1), AsyncWithBatchSize(1))
+	// 第一条被取出来之后卡在写入，第二条进入队列，后面的都会被丢弃
+	w.Log("a")
+	assert.Eventually(t, func() bool {
+		return len(w.queue) == 0
+	}, time.Second, time.Millisecond)
+	w.Log("b")
+	w.Log("c")
+	w.Log("d")
+	assert.Equal(t, uint64(2), w.Dropped())
+
+	// 写入一直卡住，超时返回
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, w.Shutdown(ctx))
+
+	close(buf.block)
+	require.NoError(t, w.Shutdown(context.Background()))
+	assert.Equal(t, "a\nb\n", buf.String())
+}
+
+func TestAsyncWriter_ConcurrentShutdown(t *testing.T) {
+	buf := &syncBuffer{}
+	w := NewAsyncWriter(buf, AsyncWithQueueSize(10000))
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				w.Log("a")
+			}
+		}()
+	}
+	time.Sleep(time.Millisecond)
+	require.NoError(t, w.Shutdown(context.Background()))
+	wg.Wait()
+	// 和 Shutdown 同时进行的日志，要么写进去了，要么算作丢弃，不能悄无声息地丢了
+	written := strings.Count(buf.String(), "a\n")
+	assert.Equal(t, 1000, written+int(w.Dropped()))
+}
+
+func TestNewAsyncWriter_Invalid(t *testing.T) {
+	assert.Panics(t, func() {
+		NewAsyncWriter(&syncBuffer{}, AsyncWithFlushInterval(0))
+	})
+	assert.Panics(t, func() {
+		NewAsyncWriter(&syncBuffer{}, AsyncWithFlushInterval(-time.Second))
+	})
+	assert.Panics(t, func() {
+		NewAsyncWriter(&syncBuffer{}, AsyncWithQueueSize(0))
+	})
+}
+
+func TestAsyncWriter_ServerShutdown(t *testing.T) {
+	buf := &syncBuffer{}
+	w := NewAsyncWriter(buf, AsyncWithFlushInterval(time.Hour))
+	builder := &MiddlewareBuilder{}
+	builder.LogFunc(w.Log).Formatter(TemplateFormatter(`{{.HTTPMethod}} {{.Route}}`))
+	server := web.NewHttpServer(web.ServerWithMiddleware(builder.Build()))
+	server.RegisterOnShutdown(w.Shutdown)
+	server.Get("/user", func(ctx *web.Context) {})
+
+	for i := 0; i < 3; i++ {
+		server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/user", nil))
+	}
+	require.NoError(t, server.Shutdown(context.Background()))
+	assert.Equal(t, strings.Repeat("GET /user\n", 3), buf.String())
+}
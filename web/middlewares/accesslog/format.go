@@ -0,0 +1,255 @@
+package accesslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Field 代表可以选择记录的字段
+type Field uint32
+
+const (
+	FieldHost Field = 1 << iota
+	FieldRoute
+	FieldMethod
+	FieldPath
+	FieldQuery
+	FieldRequestID
+	FieldStatus
+	FieldDuration
+	FieldReqBytes
+	FieldRespBytes
+	FieldUserAgent
+	FieldReferer
+	FieldClientIP
+	FieldTime
+	FieldProto
+
+	FieldAll = FieldProto<<1 - 1
+)
+
+type AccessLog struct {
+	Time time.Time `json:"time,omitempty"`
+	Host string    `json:"host,omitempty"`
+	// 代表命中的路由
+	Route      string        `json:"route,omitempty"`
+	HTTPMethod string        `json:"http_method,omitempty"`
+	Path       string        `json:"path,omitempty"`
+	Query      string        `json:"query,omitempty"`
+	Proto      string        `json:"proto,omitempty"`
+	RequestID  string        `json:"request_id,omitempty"`
+	Status     int           `json:"status,omitempty"`
+	Duration   time.Duration `json:"duration,omitempty"`
+	ReqBytes   int64         `json:"req_bytes,omitempty"`
+	RespBytes  int64         `json:"resp_bytes,omitempty"`
+	UserAgent  string        `json:"user_agent,omitempty"`
+	Referer    string        `json:"referer,omitempty"`
+	ClientIP   string        `json:"client_ip,omitempty"`
+
+	// 下面这些只有开启了 LogBody 才会记录
+	ReqHeader  map[string]string `json:"req_header,omitempty"`
+	ReqBody    string            `json:"req_body,omitempty"`
+	RespHeader map[string]string `json:"resp_header,omitempty"`
+	RespBody   string            `json:"resp_body,omitempty"`
+}
+
+// filter 把没有选中的字段清空
+func (l *AccessLog) filter(fields Field) {
+	if fields&FieldHost == 0 {
+		l.Host = ""
+	}
+	if fields&FieldRoute == 0 {
+		l.Route = ""
+	}
+	if fields&FieldMethod == 0 {
+		l.HTTPMethod = ""
+	}
+	if fields&FieldPath == 0 {
+		l.Path = ""
+	}
+	if fields&FieldQuery == 0 {
+		l.Query = ""
+	}
+	if fields&FieldRequestID == 0 {
+		l.RequestID = ""
+	}
+	if fields&FieldStatus == 0 {
+		l.Status = 0
+	}
+	if fields&FieldDuration == 0 {
+		l.Duration = 0
+	}
+	if fields&FieldReqBytes == 0 {
+		l.ReqBytes = 0
+	}
+	if fields&FieldRespBytes == 0 {
+		l.RespBytes = 0
+	}
+	if fields&FieldUserAgent == 0 {
+		l.UserAgent = ""
+	}
+	if fields&FieldReferer == 0 {
+		l.Referer = ""
+	}
+	if fields&FieldClientIP == 0 {
+		l.ClientIP = ""
+	}
+	if fields&FieldTime == 0 {
+		l.Time = time.Time{}
+	}
+	if fields&FieldProto == 0 {
+		l.Proto = ""
+	}
+}
+
+// MarshalJSON time 是结构体，omitempty 不起作用，所以要单独处理
+func (l AccessLog) MarshalJSON() ([]byte, error) {
+	type alias AccessLog
+	var t *time.Time
+	if !l.Time.IsZero() {
+		t = &l.Time
+	}
+	return json.Marshal(struct {
+		Time *time.Time `json:"time,omitempty"`
+		alias
+	}{Time: t, alias: alias(l)})
+}
+
+// attrs 转换成 slog 的属性
+// route、method 和 request_id 已经在 ctx.Logger() 里面了，这里不再重复
+func (l *AccessLog) attrs() []slog.Attr {
+	res := make([]slog.Attr, 0, 16)
+	addStr := func(key, val string) {
+		if val != "" {
+			res = append(res, slog.String(key, val))
+		}
+	}
+	if !l.Time.IsZero() {
+		res = append(res, slog.Time("start_time", l.Time))
+	}
+	addStr("host", l.Host)
+	addStr("path", l.Path)
+	addStr("query", l.Query)
+	addStr("proto", l.Proto)
+	if l.Status != 0 {
+		res = append(res, slog.Int("status", l.Status))
+	}
+	if l.Duration != 0 {
+		res = append(res, slog.Duration("duration", l.Duration))
+	}
+	if l.ReqBytes != 0 {
+		res = append(res, slog.Int64("req_bytes", l.ReqBytes))
+	}
+	if l.RespBytes != 0 {
+		res = append(res, slog.Int64("resp_bytes", l.RespBytes))
+	}
+	addStr("user_agent", l.UserAgent)
+	addStr("referer", l.Referer)
+	addStr("client_ip", l.ClientIP)
+	if l.ReqHeader != nil {
+		res = append(res, slog.Any("req_header", l.ReqHeader))
+	}
+	addStr("req_body", l.ReqBody)
+	if l.RespHeader != nil {
+		res = append(res, slog.Any("resp_header", l.RespHeader))
+	}
+	addStr("resp_body", l.RespBody)
+	return res
+}
+
+// Formatter 把一条访问日志格式化成字符串
+type Formatter func(l *AccessLog) string
+
+// JSON 默认的格式
+func JSON(l *AccessLog) string {
+	data, _ := json.Marshal(l)
+	return string(data)
+}
+
+// Common Apache Common Log Format
+// 127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326
+func Common(l *AccessLog) string {
+	return fmt.Sprintf(`%s - - [%s] "%s %s %s" %s %s`,
+		orDash(l.ClientIP), l.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		orDash(l.HTTPMethod), orDash(l.requestURI()), orDash(l.Proto),
+		orDash(strconv.Itoa(l.Status)), bytesOrDash(l.RespBytes))
+}
+
+// Combined Apache Combined Log Format，在 Common 的基础上加上了 Referer 和 User-Agent
+func Combined(l *AccessLog) string {
+	return fmt.Sprintf(`%s "%s" "%s"`, Common(l), orDash(l.Referer), orDash(l.UserAgent))
+}
+
+// TemplateFormatter 使用 text/template 自定义格式，模板的数据是 *AccessLog
+// 例如 {{.HTTPMethod}} {{.Route}} {{.Status}} {{.Duration}}
+func TemplateFormatter(text string) Formatter {
+	tpl := template.Must(template.New("accesslog").Parse(text))
+	return func(l *AccessLog) string {
+		var sb strings.Builder
+		if err := tpl.Execute(&sb, l); err != nil {
+			return fmt.Sprintf("accesslog: 模板渲染失败 %v", err)
+		}
+		return sb.String()
+	}
+}
+
+func (l *AccessLog) requestURI() string {
+	if l.Query == "" {
+		return l.Path
+	}
+	return l.Path + "?" + l.Query
+}
+
+func orDash(val string) string {
+	if val == "" || val == "0" {
+		return "-"
+	}
+	return val
+}
+
+func bytesOrDash(n int64) string {
+	if n <= 0 {
+		return "-"
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+const redacted = "***"
+
+var defaultRedactHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// redactor 对 body 里面的某个字段脱敏
+// body 可能被截断了，不一定是合法的 JSON，所以这里直接用正则替换
+type redactor struct {
+	json *regexp.Regexp
+	form *regexp.Regexp
+}
+
+func newRedactor(field string) redactor {
+	return redactor{
+		json: regexp.MustCompile(`("` + regexp.QuoteMeta(field) + `"\s*:\s*)("(?:[^"\\]|\\.)*"?|[^,}\]\s]+)`),
+		form: regexp.MustCompile(`(^|&)(` + regexp.QuoteMeta(url.QueryEscape(field)) + `)=[^&]*`),
+	}
+}
+
+func (m MiddlewareBuilder) redactBody(contentType string, body string) string {
+	if body == "" {
+		return body
+	}
+	isForm := strings.HasPrefix(contentType, "application/x-www-form-urlencoded")
+	for _, r := range m.redactors {
+		if isForm {
+			body = r.form.ReplaceAllString(body, "${1}${2}="+redacted)
+		} else {
+			body = r.json.ReplaceAllString(body, `${1}"`+redacted+`"`)
+		}
+	}
+	return body
+}
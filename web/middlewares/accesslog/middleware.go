@@ -1,43 +1,255 @@
 package accesslog
 
 import (
-	"encoding/json"
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
 	"web"
 )
 
 type MiddlewareBuilder struct {
 	logFunc func(log string)
+
+	// fields 为 0 说明记录全部字段
+	fields    Field
+	formatter Formatter
+
+	// maxBodySize 大于 0 才会记录请求和响应的 body 以及头部
+	maxBodySize   int
+	redactHeaders map[string]struct{}
+	redactors     []redactor
+
+	filter sampler
 }
 
+// LogFunc 设置输出日志的方法，不设置的话会通过 ctx.Logger() 输出结构化的日志，这时候 Formatter 不起作用
 func (m *MiddlewareBuilder) LogFunc(fn func(log string)) *MiddlewareBuilder {
 	m.logFunc = fn
 	return m
 }
 
+// Fields 选择需要记录的字段，例如 FieldRoute | FieldStatus
+func (m *MiddlewareBuilder) Fields(fields ...Field) *MiddlewareBuilder {
+	m.fields = 0
+	for _, f := range fields {
+		m.fields |= f
+	}
+	return m
+}
+
+// Formatter 设置输出格式，默认是 JSON
+// 内置了 JSON、Common、Combined，也可以用 TemplateFormatter 自定义
+func (m *MiddlewareBuilder) Formatter(f Formatter) *MiddlewareBuilder {
+	m.formatter = f
+	return m
+}
+
+// LogBody 记录请求和响应的头部以及 body，body 最多记录 maxSize 个字节
+// 默认会脱敏 Authorization、Cookie 之类的头部
+func (m *MiddlewareBuilder) LogBody(maxSize int) *MiddlewareBuilder {
+	m.maxBodySize = maxSize
+	if m.redactHeaders == nil {
+		m.RedactHeaders(defaultRedactHeaders...)
+	}
+	return m
+}
+
+// RedactHeaders 设置需要脱敏的头部，会覆盖默认值
+func (m *MiddlewareBuilder) RedactHeaders(headers ...string) *MiddlewareBuilder {
+	m.redactHeaders = make(map[string]struct{}, len(headers))
+	for _, h := range headers {
+		m.redactHeaders[http.CanonicalHeaderKey(h)] = struct{}{}
+	}
+	return m
+}
+
+// RedactFields 设置 body 里面需要脱敏的字段，支持 JSON 和表单
+func (m *MiddlewareBuilder) RedactFields(fields ...string) *MiddlewareBuilder {
+	m.redactors = make([]redactor, 0, len(fields))
+	for _, f := range fields {
+		m.redactors = append(m.redactors, newRedactor(f))
+	}
+	return m
+}
+
+// IncludeRoutes 只记录命中了这些路由的请求，和 ctx.MatchedRoute 比较
+// 支持 path.Match 的语法，例如 /api/*
+func (m *MiddlewareBuilder) IncludeRoutes(patterns ...string) *MiddlewareBuilder {
+	m.filter.includes = append(m.filter.includes, patterns...)
+	return m
+}
+
+// ExcludeRoutes 不记录命中了这些路由的请求，例如健康检查、静态资源
+func (m *MiddlewareBuilder) ExcludeRoutes(patterns ...string) *MiddlewareBuilder {
+	m.filter.excludes = append(m.filter.excludes, patterns...)
+	return m
+}
+
+// SampleRate 按照路由设置采样率，取值 [0, 1]
+// 按照注册顺序匹配，第一个匹配上的生效
+func (m *MiddlewareBuilder) SampleRate(pattern string, rate float64) *MiddlewareBuilder {
+	m.filter.rates = append(m.filter.rates, routeRate{pattern: pattern, rate: rate})
+	return m
+}
+
+// DefaultSampleRate 没有匹配上任何 SampleRate 的时候使用的采样率，默认是 1
+func (m *MiddlewareBuilder) DefaultSampleRate(rate float64) *MiddlewareBuilder {
+	m.filter.defaultRate = &rate
+	return m
+}
+
+// AlwaysLogStatus 响应码大于等于 status 的请求一定会记录，不受过滤和采样影响
+// 例如 AlwaysLogStatus(500) 就是所有的服务端错误都记录下来
+func (m *MiddlewareBuilder) AlwaysLogStatus(status int) *MiddlewareBuilder {
+	m.filter.minStatus = status
+	return m
+}
+
+// AlwaysLogSlow 处理时间超过 threshold 的请求一定会记录，不受过滤和采样影响
+func (m *MiddlewareBuilder) AlwaysLogSlow(threshold time.Duration) *MiddlewareBuilder {
+	m.filter.slowThreshold = threshold
+	return m
+}
+
 func (m MiddlewareBuilder) Build() web.Middleware {
+	if m.formatter == nil {
+		m.formatter = JSON
+	}
+	if m.fields == 0 {
+		m.fields = FieldAll
+	}
 	return func(next web.HandleFunc) web.HandleFunc {
 		return func(ctx *web.Context) {
+			start := time.Now()
+			resp := &responseWriter{ResponseWriter: web.NewResponseWriter(ctx.Resp), limit: m.maxBodySize}
+			ctx.Resp = resp
+			var reqBody *countReader
+			if ctx.Req.Body != nil {
+				reqBody = &countReader{ReadCloser: ctx.Req.Body}
+				if m.maxBodySize > 0 {
+					// 先读出来一部分，再拼回去，这样即便业务没有读 body 也能记录下来
+					reqBody.peek, _ = io.ReadAll(io.LimitReader(ctx.Req.Body, int64(m.maxBodySize)))
+					reqBody.r = io.MultiReader(bytes.NewReader(reqBody.peek), ctx.Req.Body)
+				}
+				ctx.Req.Body = reqBody
+			}
 			// 要记录请求
 			// defer的原因，因为下面可能会panic
 			defer func() {
-				l := accessLog{
-					Host:       ctx.Req.Host,
-					Route:      ctx.MatchedRoute,
-					HTTPMethod: ctx.Req.Method,
-					Path:       ctx.Req.URL.Path,
+				status, duration := resp.statusCode(ctx), time.Since(start)
+				if !m.filter.shouldLog(ctx.MatchedRoute, status, duration) {
+					return
 				}
-				data, _ := json.Marshal(l)
-				m.logFunc(string(data))
+				l := m.newAccessLog(ctx, start, resp, reqBody)
+				if m.logFunc == nil {
+					ctx.Logger().LogAttrs(ctx.Req.Context(), slog.LevelInfo, "access", l.attrs()...)
+					return
+				}
+				m.logFunc(m.formatter(l))
 			}()
 			next(ctx)
 		}
 	}
 }
 
-type accessLog struct {
-	Host string `json:"host,omitempty"`
-	// 代表命中的路由
-	Route      string `json:"route,omitempty"`
-	HTTPMethod string `json:"http_method,omitempty"`
-	Path       string `json:"path,omitempty"`
+func (m MiddlewareBuilder) newAccessLog(ctx *web.Context, start time.Time,
+	resp *responseWriter, reqBody *countReader) *AccessLog {
+	l := &AccessLog{
+		Time:       start,
+		Host:       ctx.Req.Host,
+		Route:      ctx.MatchedRoute,
+		HTTPMethod: ctx.Req.Method,
+		Path:       ctx.Req.URL.Path,
+		Query:      ctx.Req.URL.RawQuery,
+		Proto:      ctx.Req.Proto,
+		RequestID:  ctx.RequestID,
+		Status:     resp.statusCode(ctx),
+		Duration:   time.Since(start),
+		ReqBytes:   ctx.Req.ContentLength,
+		RespBytes:  int64(resp.Size() + len(ctx.RespData)),
+		UserAgent:  ctx.Req.UserAgent(),
+		Referer:    ctx.Req.Referer(),
+		ClientIP:   ctx.ClientIP(),
+	}
+	// 没有 Content-Length 的时候，就只能看读了多少
+	if l.ReqBytes < 0 && reqBody != nil {
+		l.ReqBytes = reqBody.n
+	}
+
+	if m.maxBodySize > 0 {
+		l.ReqHeader = m.redactHeader(ctx.Req.Header)
+		l.RespHeader = m.redactHeader(resp.Header())
+		contentType := ctx.Req.Header.Get("Content-Type")
+		if reqBody != nil {
+			l.ReqBody = m.redactBody(contentType, string(reqBody.peek))
+		}
+		respBody := resp.buf.String() + string(ctx.RespData)
+		if len(respBody) > m.maxBodySize {
+			respBody = respBody[:m.maxBodySize]
+		}
+		l.RespBody = m.redactBody(resp.Header().Get("Content-Type"), respBody)
+	}
+	l.filter(m.fields)
+	return l
+}
+
+func (m MiddlewareBuilder) redactHeader(header http.Header) map[string]string {
+	res := make(map[string]string, len(header))
+	for key, vals := range header {
+		if _, ok := m.redactHeaders[key]; ok {
+			res[key] = redacted
+			continue
+		}
+		res[key] = strings.Join(vals, ", ")
+	}
+	return res
+}
+
+// responseWriter 在 web.ResponseWriter 的基础上，记录直接写入的响应体的开头部分
+type responseWriter struct {
+	*web.ResponseWriter
+	buf   strings.Builder
+	limit int
+}
+
+func (w *responseWriter) Write(data []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(data)
+	if remain := w.limit - w.buf.Len(); remain > 0 && n > 0 {
+		w.buf.Write(data[:min(n, remain)])
+	}
+	return n, err
+}
+
+func (w *responseWriter) statusCode(ctx *web.Context) int {
+	if ctx.RespStatusCode != 0 {
+		return ctx.RespStatusCode
+	}
+	if status := w.Status(); status != 0 {
+		return status
+	}
+	return http.StatusOK
+}
+
+// countReader 统计读取的请求 body 大小
+type countReader struct {
+	io.ReadCloser
+	// r 不为 nil 的时候从 r 里面读，peek 是预先读出来的部分
+	r    io.Reader
+	peek []byte
+	n    int64
+}
+
+func (r *countReader) Read(p []byte) (int, error) {
+	var n int
+	var err error
+	if r.r != nil {
+		n, err = r.r.Read(p)
+	} else {
+		n, err = r.ReadCloser.Read(p)
+	}
+	r.n += int64(n)
+	return n, err
 }
@@ -1,10 +1,18 @@
 package accesslog
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"log/slog"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"web"
+	"web/middlewares/requestid"
 )
 
 func TestMiddlewareBuilder(t *testing.T) {
@@ -20,5 +28,156 @@ func TestMiddlewareBuilder(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	server.ServeHTTP(nil, req)
+	server.ServeHTTP(httptest.NewRecorder(), req)
+}
+
+func TestMiddlewareBuilder_Fields(t *testing.T) {
+	var log string
+	builder := &MiddlewareBuilder{}
+	builder.LogFunc(func(l string) {
+		log = l
+	})
+	server := web.NewHttpServer(web.ServerWithMiddleware(builder.Build()))
+	server.Post("/user/:id", func(ctx *web.Context) {
+		ctx.RespStatusCode = http.StatusCreated
+		ctx.RespData = []byte("hello")
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/user/123?a=b", strings.NewReader(`{"name":"tom"}`))
+	req.Header.Set("User-Agent", "test-agent")
+	req.Header.Set("Referer", "http://example.com")
+	server.ServeHTTP(httptest.NewRecorder(), req)
+
+	var l AccessLog
+	require.NoError(t, json.Unmarshal([]byte(log), &l))
+	assert.Equal(t, "/user/:id", l.Route)
+	assert.Equal(t, "/user/123", l.Path)
+	assert.Equal(t, "a=b", l.Query)
+	assert.Equal(t, http.StatusCreated, l.Status)
+	assert.Equal(t, int64(14), l.ReqBytes)
+	assert.Equal(t, int64(5), l.RespBytes)
+	assert.Equal(t, "test-agent", l.UserAgent)
+	assert.Equal(t, "http://example.com", l.Referer)
+	assert.Equal(t, "192.0.2.1", l.ClientIP)
+	assert.True(t, l.Duration > 0)
+	assert.Empty(t, l.ReqBody)
+
+	// 只选择部分字段
+	builder.Fields(FieldRoute, FieldStatus)
+	server = web.NewHttpServer(web.ServerWithMiddleware(builder.Build()))
+	server.Post("/user/:id", func(ctx *web.Context) {
+		ctx.RespStatusCode = http.StatusCreated
+	})
+	req = httptest.NewRequest(http.MethodPost, "/user/123", nil)
+	server.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, `{"route":"/user/:id","status":201}`, log)
+}
+
+func TestMiddlewareBuilder_Formatter(t *testing.T) {
+	testCases := []struct {
+		name      string
+		formatter Formatter
+		wantLog   string
+	}{
+		{
+			name:      "common",
+			formatter: Common,
+			wantLog:   `192.0.2.1 - - [TIME] "GET /user?id=1 HTTP/1.1" 200 5`,
+		},
+		{
+			name:      "combined",
+			formatter: Combined,
+			wantLog:   `192.0.2.1 - - [TIME] "GET /user?id=1 HTTP/1.1" 200 5 "-" "curl"`,
+		},
+		{
+			name:      "template",
+			formatter: TemplateFormatter(`{{.HTTPMethod}} {{.Route}} {{.Status}} {{.RespBytes}}`),
+			wantLog:   `GET /user 200 5`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var log string
+			builder := &MiddlewareBuilder{}
+			builder.LogFunc(func(l string) {
+				log = l
+			}).Formatter(tc.formatter)
+			server := web.NewHttpServer(web.ServerWithMiddleware(builder.Build()))
+			server.Get("/user", func(ctx *web.Context) {
+				ctx.RespData = []byte("hello")
+			})
+			req := httptest.NewRequest(http.MethodGet, "/user?id=1", nil)
+			req.Header.Set("User-Agent", "curl")
+			server.ServeHTTP(httptest.NewRecorder(), req)
+
+			// 时间没法断言，替换掉
+			if start := strings.Index(log, "["); start >= 0 {
+				end := strings.Index(log, "]")
+				log = log[:start+1] + "TIME" + log[end:]
+			}
+			assert.Equal(t, tc.wantLog, log)
+		})
+	}
+}
+
+func TestMiddlewareBuilder_LogBody(t *testing.T) {
+	var log string
+	builder := &MiddlewareBuilder{}
+	builder.LogFunc(func(l string) {
+		log = l
+	}).Fields(FieldRoute).LogBody(40).RedactFields("password")
+	server := web.NewHttpServer(web.ServerWithMiddleware(builder.Build()))
+	server.Post("/login", func(ctx *web.Context) {
+		// 业务没有读 body 也能记录下来
+		ctx.Resp.Header().Set("Content-Type", "application/json")
+		ctx.RespData = []byte(`{"token":"abc","password":"123456","msg":"very long message"}`)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/login",
+		strings.NewReader(`{"name":"tom","password":"123456"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer xxx")
+	server.ServeHTTP(httptest.NewRecorder(), req)
+
+	var l AccessLog
+	require.NoError(t, json.Unmarshal([]byte(log), &l))
+	assert.Equal(t, `{"name":"tom","password":"***"}`, l.ReqBody)
+	assert.Equal(t, `{"token":"abc","password":"***","msg"`, l.RespBody)
+	assert.Equal(t, "***", l.ReqHeader["Authorization"])
+	assert.Equal(t, "application/json", l.ReqHeader["Content-Type"])
+
+	// 表单
+	req = httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(`name=tom&password=123456`))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	server.ServeHTTP(httptest.NewRecorder(), req)
+	require.NoError(t, json.Unmarshal([]byte(log), &l))
+	assert.Equal(t, `name=tom&password=***`, l.ReqBody)
+}
+
+func TestMiddlewareBuilder_Slog(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := slog.New(slog.NewJSONHandler(buf, nil))
+	builder := &MiddlewareBuilder{}
+	builder.Fields(FieldRoute, FieldMethod, FieldStatus, FieldPath)
+	server := web.NewHttpServer(web.ServerWithLogger(logger), web.ServerWithMiddleware(
+		requestid.NewMiddlewareBuilder().Build(), builder.Build()))
+	server.Get("/user/:id", func(ctx *web.Context) {})
+
+	req := httptest.NewRequest(http.MethodGet, "/user/123", nil)
+	req.Header.Set(requestid.DefaultHeader, "abc-123")
+	server.ServeHTTP(httptest.NewRecorder(), req)
+
+	var l map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &l))
+	delete(l, "time")
+	assert.Equal(t, map[string]any{
+		"level":      "INFO",
+		"msg":        "access",
+		"route":      "/user/:id",
+		"method":     http.MethodGet,
+		"request_id": "abc-123",
+		"path":       "/user/123",
+		"status":     float64(200),
+	}, l)
 }
@@ -0,0 +1,226 @@
+package accesslog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat 备份文件的时间后缀，可以直接按照字典序排序
+const backupTimeFormat = "20060102-150405.000"
+
+// RotateWriter 按照大小或者时间切割的日志文件
+// 切割出来的文件名是 access.log.20240102-150405.000 这种形式
+// 同一毫秒内切割了多次的时候，后面的会加上序号，例如 access.log.20240102-150405.000-001
+type RotateWriter struct {
+	filename string
+
+	// maxSize 单个文件最大的字节数，0 代表不限制
+	maxSize int64
+	// interval 按时间切割的周期，例如 24 * time.Hour，0 代表不按时间切割
+	interval time.Duration
+	// maxBackups 最多保留多少个备份，0 代表不限制
+	maxBackups int
+	// maxAge 备份最多保留多久，0 代表不限制
+	maxAge time.Duration
+
+	mutex    sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+
+	now func() time.Time
+}
+
+type RotateWriterOption func(w *RotateWriter)
+
+func RotateWithMaxSize(size int64) RotateWriterOption {
+	return func(w *RotateWriter) {
+		w.maxSize = size
+	}
+}
+
+func RotateWithInterval(interval time.Duration) RotateWriterOption {
+	return func(w *RotateWriter) {
+		w.interval = interval
+	}
+}
+
+func RotateWithMaxBackups(n int) RotateWriterOption {
+	return func(w *RotateWriter) {
+		w.maxBackups = n
+	}
+}
+
+func RotateWithMaxAge(age time.Duration) RotateWriterOption {
+	return func(w *RotateWriter) {
+		w.maxAge = age
+	}
+}
+
+func NewRotateWriter(filename string, opts ...RotateWriterOption) (*RotateWriter, error) {
+	res := &RotateWriter{
+		filename: filename,
+		now:      time.Now,
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	if err := res.open(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (w *RotateWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.file == nil {
+		return 0, os.ErrClosed
+	}
+	if w.shouldRotate(len(p)) {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Close 关闭文件，之后再写入会返回 os.ErrClosed
+func (w *RotateWriter) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+func (w *RotateWriter) shouldRotate(n int) bool {
+	if w.maxSize > 0 && w.size > 0 && w.size+int64(n) > w.maxSize {
+		return true
+	}
+	if w.interval > 0 && !w.now().Truncate(w.interval).Equal(w.openedAt.Truncate(w.interval)) {
+		return true
+	}
+	return false
+}
+
+func (w *RotateWriter) open() error {
+	if err := os.MkdirAll(filepath.Dir(w.filename), 0o755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(w.filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	w.file = file
+	w.size = info.Size()
+	w.openedAt = w.now()
+	if w.size > 0 {
+		// 已经存在的文件，按照最后修改时间来判断是否需要按时间切割
+		w.openedAt = info.ModTime()
+	}
+	return nil
+}
+
+func (w *RotateWriter) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	w.file = nil
+	backup, err := w.backupName()
+	if err != nil {
+		return err
+	}
+	if err = os.Rename(w.filename, backup); err != nil {
+		return err
+	}
+	if err := w.open(); err != nil {
+		return err
+	}
+	return w.cleanup()
+}
+
+// backupName 不能覆盖已经存在的备份
+// 序号是定长的，这样加上序号之后依旧可以按照字典序排序
+func (w *RotateWriter) backupName() (string, error) {
+	base := fmt.Sprintf("%s.%s", w.filename, w.now().Format(backupTimeFormat))
+	name := base
+	for seq := 1; ; seq++ {
+		_, err := os.Lstat(name)
+		if os.IsNotExist(err) {
+			return name, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		name = fmt.Sprintf("%s-%03d", base, seq)
+	}
+}
+
+// parseBackup 解析备份文件的时间，不是备份文件的返回 false
+func parseBackup(suffix string, loc *time.Location) (time.Time, bool) {
+	if len(suffix) > len(backupTimeFormat) {
+		seq, ok := strings.CutPrefix(suffix[len(backupTimeFormat):], "-")
+		if !ok || strings.Trim(seq, "0123456789") != "" {
+			return time.Time{}, false
+		}
+		suffix = suffix[:len(backupTimeFormat)]
+	}
+	t, err := time.ParseInLocation(backupTimeFormat, suffix, loc)
+	return t, err == nil
+}
+
+// cleanup 删除超出数量或者过期的备份
+func (w *RotateWriter) cleanup() error {
+	if w.maxBackups <= 0 && w.maxAge <= 0 {
+		return nil
+	}
+	matches, err := filepath.Glob(w.filename + ".*")
+	if err != nil {
+		return err
+	}
+	now := w.now()
+	backups := make([]string, 0, len(matches))
+	times := make(map[string]time.Time, len(matches))
+	for _, m := range matches {
+		if t, ok := parseBackup(strings.TrimPrefix(m, w.filename+"."), now.Location()); ok {
+			backups = append(backups, m)
+			times[m] = t
+		}
+	}
+	// 新的在前面
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+
+	for i, b := range backups {
+		expired := false
+		if w.maxBackups > 0 && i >= w.maxBackups {
+			expired = true
+		}
+		if w.maxAge > 0 {
+			if now.Sub(times[b]) > w.maxAge {
+				expired = true
+			}
+		}
+		if expired {
+			if err = os.Remove(b); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
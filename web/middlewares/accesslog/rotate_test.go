@@ -0,0 +1,120 @@
+package accesslog
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestRotateWriter_Size(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "access.log")
+	now := time.Date(2024, 1, 2, 15, 4, 5, 0, time.Local)
+	w, err := NewRotateWriter(filename, RotateWithMaxSize(10), RotateWithMaxBackups(2))
+	require.NoError(t, err)
+	w.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+
+	for _, line := range []string{"aaaaaa\n", "bbbbbb\n", "cccccc\n", "dddddd\n"} {
+		_, err = w.Write([]byte(line))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+
+	data, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	assert.Equal(t, "dddddd\n", string(data))
+
+	backups, err := filepath.Glob(filename + ".*")
+	require.NoError(t, err)
+	sort.Strings(backups)
+	// 只保留最新的两个
+	require.Len(t, backups, 2)
+	data, err = os.ReadFile(backups[0])
+	require.NoError(t, err)
+	assert.Equal(t, "bbbbbb\n", string(data))
+	data, err = os.ReadFile(backups[1])
+	require.NoError(t, err)
+	assert.Equal(t, "cccccc\n", string(data))
+
+	_, err = w.Write([]byte("closed"))
+	assert.Equal(t, os.ErrClosed, err)
+}
+
+func TestRotateWriter_SameMillisecond(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "access.log")
+	now := time.Date(2024, 1, 2, 15, 4, 5, 0, time.Local)
+	w, err := NewRotateWriter(filename, RotateWithMaxSize(5), RotateWithMaxBackups(3))
+	require.NoError(t, err)
+	// 时间不动，模拟同一毫秒内切割多次
+	w.now = func() time.Time {
+		return now
+	}
+	for _, line := range []string{"aaaa\n", "bbbb\n", "cccc\n", "dddd\n", "eeee\n"} {
+		_, err = w.Write([]byte(line))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+
+	backups, err := filepath.Glob(filename + ".*")
+	require.NoError(t, err)
+	sort.Strings(backups)
+	// 备份不会互相覆盖，并且字典序就是切割的顺序
+	wantBackups := []string{
+		filename + ".20240102-150405.000-001",
+		filename + ".20240102-150405.000-002",
+		filename + ".20240102-150405.000-003",
+	}
+	assert.Equal(t, wantBackups, backups)
+	for i, want := range []string{"bbbb\n", "cccc\n", "dddd\n"} {
+		data, err := os.ReadFile(backups[i])
+		require.NoError(t, err)
+		assert.Equal(t, want, string(data))
+	}
+}
+
+func TestRotateWriter_Interval(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "access.log")
+	now := time.Date(2024, 1, 2, 23, 59, 0, 0, time.UTC)
+	w, err := NewRotateWriter(filename, RotateWithInterval(time.Hour), RotateWithMaxAge(90*time.Minute))
+	require.NoError(t, err)
+	w.now = func() time.Time {
+		return now
+	}
+	w.openedAt = now
+
+	_, err = w.Write([]byte("day1\n"))
+	require.NoError(t, err)
+
+	// 跨过了整点，要切割
+	now = now.Add(2 * time.Minute)
+	_, err = w.Write([]byte("day2\n"))
+	require.NoError(t, err)
+
+	backups, err := filepath.Glob(filename + ".*")
+	require.NoError(t, err)
+	require.Len(t, backups, 1)
+	data, err := os.ReadFile(backups[0])
+	require.NoError(t, err)
+	assert.Equal(t, "day1\n", string(data))
+
+	// 再过两个小时，之前的备份过期了
+	now = now.Add(2 * time.Hour)
+	_, err = w.Write([]byte("day3\n"))
+	require.NoError(t, err)
+	backups, err = filepath.Glob(filename + ".*")
+	require.NoError(t, err)
+	require.Len(t, backups, 1)
+	data, err = os.ReadFile(backups[0])
+	require.NoError(t, err)
+	assert.Equal(t, "day2\n", string(data))
+	require.NoError(t, w.Close())
+}
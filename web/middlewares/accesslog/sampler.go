@@ -0,0 +1,84 @@
+package accesslog
+
+import (
+	"math/rand"
+	"path"
+	"time"
+)
+
+// sampler 决定一条访问日志要不要记录
+type sampler struct {
+	includes []string
+	excludes []string
+
+	rates       []routeRate
+	defaultRate *float64
+
+	minStatus     int
+	slowThreshold time.Duration
+
+	// random 返回 [0, 1) 的随机数，测试的时候可以替换
+	random func() float64
+}
+
+type routeRate struct {
+	pattern string
+	rate    float64
+}
+
+func (s sampler) shouldLog(route string, status int, duration time.Duration) bool {
+	// 错误和慢请求是排查问题最需要的，优先级最高
+	if s.minStatus > 0 && status >= s.minStatus {
+		return true
+	}
+	if s.slowThreshold > 0 && duration >= s.slowThreshold {
+		return true
+	}
+
+	if matchAny(s.excludes, route) {
+		return false
+	}
+	if len(s.includes) > 0 && !matchAny(s.includes, route) {
+		return false
+	}
+
+	rate := 1.0
+	if s.defaultRate != nil {
+		rate = *s.defaultRate
+	}
+	for _, r := range s.rates {
+		if matchRoute(r.pattern, route) {
+			rate = r.rate
+			break
+		}
+	}
+	if rate >= 1 {
+		return true
+	}
+	if rate <= 0 {
+		return false
+	}
+	random := s.random
+	if random == nil {
+		random = rand.Float64
+	}
+	return random() < rate
+}
+
+func matchAny(patterns []string, route string) bool {
+	for _, p := range patterns {
+		if matchRoute(p, route) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchRoute 先看是否完全相等，因为路由里面本身就可能有 *
+func matchRoute(pattern string, route string) bool {
+	if pattern == route {
+		return true
+	}
+	ok, _ := path.Match(pattern, route)
+	return ok
+}
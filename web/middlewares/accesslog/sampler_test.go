@@ -0,0 +1,134 @@
+package accesslog
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"web"
+)
+
+func TestSampler_ShouldLog(t *testing.T) {
+	half := 0.5
+	zero := 0.0
+	testCases := []struct {
+		name     string
+		sampler  sampler
+		route    string
+		status   int
+		duration time.Duration
+		want     bool
+	}{
+		{
+			name:  "default",
+			route: "/user",
+			want:  true,
+		},
+		{
+			name:    "excluded",
+			sampler: sampler{excludes: []string{"/health"}},
+			route:   "/health",
+		},
+		{
+			name:    "excluded by glob",
+			sampler: sampler{excludes: []string{"/static/*"}},
+			route:   "/static/:file",
+		},
+		{
+			name:    "not included",
+			sampler: sampler{includes: []string{"/api/*"}},
+			route:   "/user",
+		},
+		{
+			name:    "included",
+			sampler: sampler{includes: []string{"/api/*"}},
+			route:   "/api/user",
+			want:    true,
+		},
+		{
+			// 路由本身带 *，完全相等也算匹配
+			name:    "star route",
+			sampler: sampler{includes: []string{"/order/*"}},
+			route:   "/order/*",
+			want:    true,
+		},
+		{
+			name:    "excluded but error",
+			sampler: sampler{excludes: []string{"/health"}, minStatus: 500},
+			route:   "/health",
+			status:  http.StatusServiceUnavailable,
+			want:    true,
+		},
+		{
+			name:     "excluded but slow",
+			sampler:  sampler{excludes: []string{"/health"}, slowThreshold: time.Second},
+			route:    "/health",
+			duration: 2 * time.Second,
+			want:     true,
+		},
+		{
+			name: "sampled in",
+			sampler: sampler{rates: []routeRate{{pattern: "/user", rate: 0.5}},
+				random: func() float64 { return 0.4 }},
+			route: "/user",
+			want:  true,
+		},
+		{
+			name: "sampled out",
+			sampler: sampler{rates: []routeRate{{pattern: "/user", rate: 0.5}},
+				random: func() float64 { return 0.6 }},
+			route: "/user",
+		},
+		{
+			name: "default rate",
+			sampler: sampler{defaultRate: &half,
+				random: func() float64 { return 0.6 }},
+			route: "/user",
+		},
+		{
+			name: "route rate overrides default rate",
+			sampler: sampler{defaultRate: &zero, rates: []routeRate{{pattern: "/user", rate: 1}},
+				random: func() float64 { return 0.6 }},
+			route: "/user",
+			want:  true,
+		},
+		{
+			name:    "sampled out but error",
+			sampler: sampler{defaultRate: &zero, minStatus: 500},
+			route:   "/user",
+			status:  http.StatusInternalServerError,
+			want:    true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, tc.sampler.shouldLog(tc.route, tc.status, tc.duration))
+		})
+	}
+}
+
+func TestMiddlewareBuilder_ExcludeRoutes(t *testing.T) {
+	var logs []string
+	builder := &MiddlewareBuilder{}
+	builder.LogFunc(func(l string) {
+		logs = append(logs, l)
+	}).Formatter(TemplateFormatter(`{{.Route}} {{.Status}}`)).
+		ExcludeRoutes("/health").
+		AlwaysLogStatus(http.StatusInternalServerError)
+	server := web.NewHttpServer(web.ServerWithMiddleware(builder.Build()))
+	healthy := true
+	server.Get("/health", func(ctx *web.Context) {
+		if !healthy {
+			ctx.RespStatusCode = http.StatusInternalServerError
+		}
+	})
+	server.Get("/user", func(ctx *web.Context) {})
+
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health", nil))
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/user", nil))
+	healthy = false
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health", nil))
+	assert.Equal(t, []string{"/user 200", "/health 500"}, logs)
+}
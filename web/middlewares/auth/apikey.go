@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"web"
+)
+
+// APIKeyLookup 根据 API key 查找对应的主体，不存在的时候返回 nil
+type APIKeyLookup func(ctx *web.Context, key string) (*Principal, error)
+
+// StaticAPIKeys 固定的 API key，会和所有的 key 进行常量时间的比较
+func StaticAPIKeys(keys map[string]Principal) APIKeyLookup {
+	return func(ctx *web.Context, key string) (*Principal, error) {
+		var res *Principal
+		for k, p := range keys {
+			if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+				p := p
+				res = &p
+			}
+		}
+		return res, nil
+	}
+}
+
+type APIKeyBuilder struct {
+	lookup   APIKeyLookup
+	header   string
+	query    string
+	optional bool
+}
+
+// NewAPIKeyBuilder 默认从 X-API-Key 头部读取
+func NewAPIKeyBuilder(lookup APIKeyLookup) *APIKeyBuilder {
+	return &APIKeyBuilder{
+		lookup: lookup,
+		header: "X-API-Key",
+	}
+}
+
+// Header 读取 API key 的头部，为空的时候不从头部读取
+func (b *APIKeyBuilder) Header(header string) *APIKeyBuilder {
+	b.header = header
+	return b
+}
+
+// Query 从查询参数读取 API key，优先级低于头部
+// 查询参数容易出现在访问日志里面，不推荐使用
+func (b *APIKeyBuilder) Query(param string) *APIKeyBuilder {
+	b.query = param
+	return b
+}
+
+// Optional 没有携带 API key 的请求也放行，交给后面按照路由鉴权
+func (b *APIKeyBuilder) Optional() *APIKeyBuilder {
+	b.optional = true
+	return b
+}
+
+func (b *APIKeyBuilder) Build() web.Middleware {
+	const challenge = "APIKey"
+	return func(next web.HandleFunc) web.HandleFunc {
+		return func(ctx *web.Context) {
+			var key string
+			if b.header != "" {
+				key = ctx.Req.Header.Get(b.header)
+			}
+			if key == "" && b.query != "" {
+				key = ctx.Req.URL.Query().Get(b.query)
+			}
+			if key == "" {
+				if b.optional {
+					next(ctx)
+					return
+				}
+				unauthorized(ctx, challenge)
+				return
+			}
+			p, err := b.lookup(ctx, key)
+			if err != nil {
+				lookupFailed(ctx, err)
+				return
+			}
+			if p == nil {
+				unauthorized(ctx, challenge)
+				return
+			}
+			res := *p
+			res.Method = "api_key"
+			setPrincipal(ctx, &res)
+			next(ctx)
+		}
+	}
+}
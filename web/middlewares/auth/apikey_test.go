@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"web"
+)
+
+func TestAPIKeyBuilder_Build(t *testing.T) {
+	lookup := StaticAPIKeys(map[string]Principal{
+		"key-1": {Subject: "order-service", Scopes: []string{"order:read"}},
+	})
+	testCases := []struct {
+		name     string
+		builder  *APIKeyBuilder
+		req      func() *http.Request
+		wantCode int
+		wantSub  string
+	}{
+		{
+			name:    "header",
+			builder: NewAPIKeyBuilder(lookup),
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodGet, "/order", nil)
+				req.Header.Set("X-API-Key", "key-1")
+				return req
+			},
+			wantCode: http.StatusOK,
+			wantSub:  "order-service",
+		},
+		{
+			name:    "wrong key",
+			builder: NewAPIKeyBuilder(lookup),
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodGet, "/order", nil)
+				req.Header.Set("X-API-Key", "key-2")
+				return req
+			},
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:    "query disabled",
+			builder: NewAPIKeyBuilder(lookup),
+			req: func() *http.Request {
+				return httptest.NewRequest(http.MethodGet, "/order?api_key=key-1", nil)
+			},
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:    "query",
+			builder: NewAPIKeyBuilder(lookup).Header("").Query("api_key"),
+			req: func() *http.Request {
+				return httptest.NewRequest(http.MethodGet, "/order?api_key=key-1", nil)
+			},
+			wantCode: http.StatusOK,
+			wantSub:  "order-service",
+		},
+		{
+			name:    "custom header",
+			builder: NewAPIKeyBuilder(lookup).Header("Api-Token"),
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodGet, "/order", nil)
+				req.Header.Set("Api-Token", "key-1")
+				return req
+			},
+			wantCode: http.StatusOK,
+			wantSub:  "order-service",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := web.NewHttpServer(web.ServerWithMiddleware(tc.builder.Build()))
+			var principal *Principal
+			server.Get("/order", func(ctx *web.Context) {
+				principal, _ = PrincipalFromContext(ctx)
+			})
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, tc.req())
+			assert.Equal(t, tc.wantCode, resp.Code)
+			if tc.wantCode != http.StatusOK {
+				assert.Equal(t, "APIKey", resp.Header().Get("WWW-Authenticate"))
+				return
+			}
+			assert.Equal(t, tc.wantSub, principal.Subject)
+			assert.Equal(t, "api_key", principal.Method)
+			assert.Equal(t, []string{"order:read"}, principal.Scopes)
+		})
+	}
+}
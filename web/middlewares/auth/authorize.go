@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"log/slog"
+	"net/http"
+	"web"
+)
+
+// Authorizer 判断主体能不能访问路由，route 里面是注册路由的时候声明的角色和权限
+// 可以基于 OPA、Casbin 之类的实现更复杂的策略
+type Authorizer interface {
+	Authorize(ctx *web.Context, p *Principal, route web.RouteInfo) (bool, error)
+}
+
+type AuthorizerFunc func(ctx *web.Context, p *Principal, route web.RouteInfo) (bool, error)
+
+func (f AuthorizerFunc) Authorize(ctx *web.Context, p *Principal, route web.RouteInfo) (bool, error) {
+	return f(ctx, p, route)
+}
+
+// RoleScopeAuthorizer 默认的实现，Roles 满足其中一个就可以，Scopes 需要全部满足
+type RoleScopeAuthorizer struct{}
+
+func (RoleScopeAuthorizer) Authorize(_ *web.Context, p *Principal, route web.RouteInfo) (bool, error) {
+	if len(route.Roles) > 0 && !containsAny(p.Roles, route.Roles) {
+		return false, nil
+	}
+	for _, scope := range route.Scopes {
+		if !contains(p.Scopes, scope) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func containsAny(list []string, targets []string) bool {
+	for _, target := range targets {
+		if contains(list, target) {
+			return true
+		}
+	}
+	return false
+}
+
+type AuthorizeBuilder struct {
+	authorizer Authorizer
+}
+
+// NewAuthorizeBuilder 按照路由声明的角色和权限鉴权，需要放在认证的 middleware 后面
+// 没有声明角色和权限的路由不做检查
+func NewAuthorizeBuilder() *AuthorizeBuilder {
+	return &AuthorizeBuilder{authorizer: RoleScopeAuthorizer{}}
+}
+
+// Authorizer 替换默认的 RoleScopeAuthorizer
+func (b *AuthorizeBuilder) Authorizer(authorizer Authorizer) *AuthorizeBuilder {
+	b.authorizer = authorizer
+	return b
+}
+
+func (b *AuthorizeBuilder) Build() web.Middleware {
+	return func(next web.HandleFunc) web.HandleFunc {
+		return func(ctx *web.Context) {
+			route := ctx.RouteInfo()
+			if len(route.Roles) == 0 && len(route.Scopes) == 0 {
+				next(ctx)
+				return
+			}
+			p, ok := PrincipalFromContext(ctx)
+			if !ok {
+				// 认证的 middleware 是 Optional 的，匿名访问了需要权限的路由
+				ctx.RespStatusCode = http.StatusUnauthorized
+				ctx.Err = web.NewProblem(http.StatusUnauthorized)
+				return
+			}
+			allowed, err := b.authorizer.Authorize(ctx, p, route)
+			if err != nil {
+				ctx.Logger().Error("auth: 鉴权失败", slog.Any("err", err))
+				ctx.RespStatusCode = http.StatusInternalServerError
+				ctx.Err = err
+				return
+			}
+			if !allowed {
+				ctx.RespStatusCode = http.StatusForbidden
+				ctx.Err = web.NewProblem(http.StatusForbidden)
+				return
+			}
+			next(ctx)
+		}
+	}
+}
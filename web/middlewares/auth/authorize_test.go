@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"web"
+)
+
+func TestAuthorizeBuilder_Build(t *testing.T) {
+	lookup := StaticAPIKeys(map[string]Principal{
+		"admin":  {Subject: "admin", Roles: []string{"admin"}, Scopes: []string{"user:read", "user:write"}},
+		"reader": {Subject: "reader", Roles: []string{"user"}, Scopes: []string{"user:read"}},
+	})
+	server := web.NewHttpServer(web.ServerWithMiddleware(
+		NewAPIKeyBuilder(lookup).Optional().Build(),
+		NewAuthorizeBuilder().Build()))
+	handler := func(ctx *web.Context) {}
+	server.Get("/public", handler)
+	users := server.Group("/user", web.WithRoles("user", "admin"))
+	users.Get("/:id", handler, web.WithScopes("user:read"))
+	users.Post("/:id", handler, web.WithScopes("user:read", "user:write"))
+	server.Delete("/user/:id", handler, web.WithRoles("admin"))
+
+	testCases := []struct {
+		name     string
+		method   string
+		path     string
+		key      string
+		wantCode int
+	}{
+		{name: "public", method: http.MethodGet, path: "/public", wantCode: http.StatusOK},
+		{name: "anonymous", method: http.MethodGet, path: "/user/1", wantCode: http.StatusUnauthorized},
+		{name: "wrong key", method: http.MethodGet, path: "/public", key: "abc", wantCode: http.StatusUnauthorized},
+		{name: "reader read", method: http.MethodGet, path: "/user/1", key: "reader", wantCode: http.StatusOK},
+		{name: "reader write", method: http.MethodPost, path: "/user/1", key: "reader", wantCode: http.StatusForbidden},
+		{name: "admin write", method: http.MethodPost, path: "/user/1", key: "admin", wantCode: http.StatusOK},
+		{name: "reader delete", method: http.MethodDelete, path: "/user/1", key: "reader", wantCode: http.StatusForbidden},
+		{name: "admin delete", method: http.MethodDelete, path: "/user/1", key: "admin", wantCode: http.StatusOK},
+		{name: "not found", method: http.MethodGet, path: "/order", wantCode: http.StatusNotFound},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			if tc.key != "" {
+				req.Header.Set("X-API-Key", tc.key)
+			}
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, req)
+			assert.Equal(t, tc.wantCode, resp.Code)
+		})
+	}
+}
+
+func TestAuthorizeBuilder_Authorizer(t *testing.T) {
+	authorizer := AuthorizerFunc(func(ctx *web.Context, p *Principal, route web.RouteInfo) (bool, error) {
+		if p.Subject == "error" {
+			return false, errors.New("policy error")
+		}
+		// 只能访问自己的数据
+		return ctx.PathParams["id"] == p.Subject, nil
+	})
+	lookup := func(ctx *web.Context, key string) (*Principal, error) {
+		return &Principal{Subject: key}, nil
+	}
+	server := web.NewHttpServer(web.ServerWithMiddleware(
+		NewAPIKeyBuilder(lookup).Build(),
+		NewAuthorizeBuilder().Authorizer(authorizer).Build()))
+	server.Get("/user/:id", func(ctx *web.Context) {}, web.WithRoles("user"))
+
+	testCases := []struct {
+		key      string
+		wantCode int
+	}{
+		{key: "123", wantCode: http.StatusOK},
+		{key: "456", wantCode: http.StatusForbidden},
+		{key: "error", wantCode: http.StatusInternalServerError},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.key, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/user/123", nil)
+			req.Header.Set("X-API-Key", tc.key)
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, req)
+			assert.Equal(t, tc.wantCode, resp.Code)
+		})
+	}
+}
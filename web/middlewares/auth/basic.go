@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"strconv"
+	"web"
+)
+
+// BasicLookup 根据用户名查找密码，用户不存在的时候返回的 principal 为 nil
+// principal 的 Subject 为空的时候会使用用户名
+type BasicLookup func(ctx *web.Context, username string) (password string, principal *Principal, err error)
+
+// StaticUsers 固定的用户名和密码，适合内部的管理接口
+func StaticUsers(users map[string]string) BasicLookup {
+	return func(ctx *web.Context, username string) (string, *Principal, error) {
+		password, ok := users[username]
+		if !ok {
+			return "", nil, nil
+		}
+		return password, &Principal{}, nil
+	}
+}
+
+type BasicBuilder struct {
+	lookup   BasicLookup
+	realm    string
+	optional bool
+}
+
+// NewBasicBuilder HTTP Basic 认证
+func NewBasicBuilder(lookup BasicLookup) *BasicBuilder {
+	return &BasicBuilder{
+		lookup: lookup,
+		realm:  "Restricted",
+	}
+}
+
+// Realm 浏览器弹出登录框的时候显示的提示
+func (b *BasicBuilder) Realm(realm string) *BasicBuilder {
+	b.realm = realm
+	return b
+}
+
+// Optional 没有携带凭证的请求也放行，交给后面按照路由鉴权
+// 携带了错误的凭证依旧会返回 401
+func (b *BasicBuilder) Optional() *BasicBuilder {
+	b.optional = true
+	return b
+}
+
+func (b *BasicBuilder) Build() web.Middleware {
+	challenge := "Basic realm=" + strconv.Quote(b.realm) + `, charset="UTF-8"`
+	return func(next web.HandleFunc) web.HandleFunc {
+		return func(ctx *web.Context) {
+			if b.optional && ctx.Req.Header.Get("Authorization") == "" {
+				next(ctx)
+				return
+			}
+			username, password, ok := ctx.Req.BasicAuth()
+			if !ok {
+				unauthorized(ctx, challenge)
+				return
+			}
+			want, p, err := b.lookup(ctx, username)
+			if err != nil {
+				lookupFailed(ctx, err)
+				return
+			}
+			if p == nil {
+				// 用户不存在的时候也比较一次，避免通过响应时间判断用户是否存在
+				subtle.ConstantTimeCompare([]byte(password), []byte(password))
+				unauthorized(ctx, challenge)
+				return
+			}
+			if subtle.ConstantTimeCompare([]byte(password), []byte(want)) != 1 {
+				unauthorized(ctx, challenge)
+				return
+			}
+			res := *p
+			if res.Subject == "" {
+				res.Subject = username
+			}
+			res.Method = "basic"
+			setPrincipal(ctx, &res)
+			next(ctx)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"web"
+)
+
+func TestBasicBuilder_Build(t *testing.T) {
+	lookup := func(ctx *web.Context, username string) (string, *Principal, error) {
+		switch username {
+		case "tom":
+			return "123456", &Principal{Roles: []string{"admin"}}, nil
+		case "error":
+			return "", nil, errors.New("db error")
+		}
+		return "", nil, nil
+	}
+	testCases := []struct {
+		name      string
+		username  string
+		password  string
+		noAuth    bool
+		wantCode  int
+		wantRoles []string
+	}{
+		{name: "ok", username: "tom", password: "123456", wantCode: http.StatusOK, wantRoles: []string{"admin"}},
+		{name: "wrong password", username: "tom", password: "12345", wantCode: http.StatusUnauthorized},
+		{name: "unknown user", username: "jerry", password: "123456", wantCode: http.StatusUnauthorized},
+		{name: "no auth", noAuth: true, wantCode: http.StatusUnauthorized},
+		{name: "lookup error", username: "error", password: "123456", wantCode: http.StatusInternalServerError},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := web.NewHttpServer(web.ServerWithMiddleware(NewBasicBuilder(lookup).Realm("admin").Build()))
+			var principal *Principal
+			server.Get("/admin", func(ctx *web.Context) {
+				principal, _ = PrincipalFromContext(ctx)
+			})
+			req := httptest.NewRequest(http.MethodGet, "/admin", nil)
+			if !tc.noAuth {
+				req.SetBasicAuth(tc.username, tc.password)
+			}
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, req)
+			assert.Equal(t, tc.wantCode, resp.Code)
+			if tc.wantCode == http.StatusUnauthorized {
+				assert.Equal(t, `Basic realm="admin", charset="UTF-8"`, resp.Header().Get("WWW-Authenticate"))
+			}
+			if tc.wantCode != http.StatusOK {
+				assert.Nil(t, principal)
+				return
+			}
+			require.NotNil(t, principal)
+			assert.Equal(t, &Principal{Subject: tc.username, Method: "basic", Roles: tc.wantRoles}, principal)
+		})
+	}
+}
+
+func TestStaticUsers(t *testing.T) {
+	lookup := StaticUsers(map[string]string{"tom": "123"})
+	password, p, err := lookup(nil, "tom")
+	require.NoError(t, err)
+	assert.Equal(t, "123", password)
+	assert.NotNil(t, p)
+
+	_, p, err = lookup(nil, "jerry")
+	require.NoError(t, err)
+	assert.Nil(t, p)
+}
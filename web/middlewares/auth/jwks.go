@@ -0,0 +1,255 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// minRefreshInterval 遇到不认识的 kid 的时候会重新加载，但是至少间隔这么久
+// 防止攻击者用随机的 kid 打爆 JWKS 的服务
+const minRefreshInterval = 10 * time.Second
+
+// fetchTimeout 加载 JWKS 的超时时间
+// 加载是所有请求共享的，不能使用某个请求的 context，否则这个请求取消了大家都会失败
+const fetchTimeout = 10 * time.Second
+
+// JWKS 从本地文件或者 URL 加载 JSON Web Key Set，并且缓存起来
+type JWKS struct {
+	source string
+	client *http.Client
+	ttl    time.Duration
+
+	mutex     sync.Mutex
+	keys      map[string]any
+	fetchedAt time.Time
+	// refreshing 不为 nil 代表正在加载，加载完之后会被关闭，同一时间只有一个加载
+	refreshing chan struct{}
+	// lastErr 最近一次加载的错误
+	lastErr error
+	now     func() time.Time
+}
+
+// NewJWKS source 是 http:// 或者 https:// 开头的 URL，或者本地文件的路径
+// 缓存 ttl 之后在后台重新加载，加载的过程中以及加载失败的时候继续使用之前的密钥
+func NewJWKS(source string, ttl time.Duration) *JWKS {
+	return &JWKS{
+		source: source,
+		client: &http.Client{Timeout: fetchTimeout},
+		ttl:    ttl,
+		now:    time.Now,
+	}
+}
+
+func (j *JWKS) Key(ctx context.Context, kid string, alg string) (any, error) {
+	now := j.now()
+	j.mutex.Lock()
+	keys, fetchedAt, lastErr, refreshing := j.keys, j.fetchedAt, j.lastErr, j.refreshing
+	j.mutex.Unlock()
+
+	if keys == nil {
+		// 之前加载失败了，间隔太短的时候直接返回上次的错误
+		// 否则 JWKS 的服务出问题的时候，每个请求都会去加载并且等到超时
+		if refreshing == nil && !fetchedAt.IsZero() && now.Sub(fetchedAt) < minRefreshInterval {
+			if lastErr == nil {
+				lastErr = fmt.Errorf("%w: kid %s", ErrKeyNotFound, kid)
+			}
+			return nil, lastErr
+		}
+		// 第一次加载，没有可以用的密钥，只能等
+		var err error
+		if keys, err = j.waitRefresh(ctx, now); keys == nil {
+			return nil, err
+		}
+	} else if now.Sub(fetchedAt) >= j.ttl {
+		// 过期了在后台加载，先用旧的
+		j.refresh(now)
+	}
+	key, ok := find(keys, kid)
+	if !ok && now.Sub(fetchedAt) >= minRefreshInterval {
+		// 可能是密钥轮换了
+		var err error
+		if keys, err = j.waitRefresh(ctx, now); err != nil {
+			return nil, err
+		}
+		key, ok = find(keys, kid)
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: kid %s", ErrKeyNotFound, kid)
+	}
+	return key, nil
+}
+
+// waitRefresh 等待加载完成，返回最新的密钥和这次加载的错误
+// ctx 取消的时候只是不再等待，加载还会继续
+func (j *JWKS) waitRefresh(ctx context.Context, now time.Time) (map[string]any, error) {
+	done := j.refresh(now)
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.keys, j.lastErr
+}
+
+// refresh 在后台加载，已经在加载的时候复用正在进行的那个
+func (j *JWKS) refresh(now time.Time) <-chan struct{} {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if j.refreshing != nil {
+		return j.refreshing
+	}
+	done := make(chan struct{})
+	j.refreshing = done
+	// 失败了也要记录时间，避免每个请求都去加载
+	j.fetchedAt = now
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+		defer cancel()
+		keys, err := j.fetch(ctx)
+		j.mutex.Lock()
+		if err == nil {
+			j.keys = keys
+		}
+		j.lastErr = err
+		j.refreshing = nil
+		j.mutex.Unlock()
+		close(done)
+	}()
+	return done
+}
+
+// find 没有 kid 的时候只有一个密钥才能确定用哪个
+func find(keys map[string]any, kid string) (any, bool) {
+	if kid != "" {
+		key, ok := keys[kid]
+		return key, ok
+	}
+	if len(keys) == 1 {
+		for _, key := range keys {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+func (j *JWKS) fetch(ctx context.Context) (map[string]any, error) {
+	data, err := j.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return parseJWKS(data)
+}
+
+func (j *JWKS) load(ctx context.Context) ([]byte, error) {
+	if !strings.HasPrefix(j.source, "http://") && !strings.HasPrefix(j.source, "https://") {
+		return os.ReadFile(j.source)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.source, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := j.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("auth: 加载 JWKS 失败 %s", resp.Status)
+	}
+	// JWKS 不会太大，限制一下防止意外
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	// oct
+	K string `json:"k"`
+}
+
+// parseJWKS 不认识的密钥直接忽略，不影响其它密钥的使用
+func parseJWKS(data []byte) (map[string]any, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("auth: JWKS 格式不正确 %w", err)
+	}
+	keys := make(map[string]any, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (any, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("auth: 不支持的曲线 %s", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		curve := elliptic.P256()
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("auth: 点不在曲线上")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "oct":
+		return base64.RawURLEncoding.DecodeString(k.K)
+	}
+	return nil, fmt.Errorf("auth: 不支持的密钥类型 %s", k.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(data), nil
+}
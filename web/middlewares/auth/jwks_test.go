@@ -0,0 +1,210 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func encodeInt(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+func rsaJWK(kid string, key *rsa.PublicKey) map[string]any {
+	return map[string]any{
+		"kty": "RSA", "kid": kid, "use": "sig",
+		"n": encodeInt(key.N), "e": encodeInt(big.NewInt(int64(key.E))),
+	}
+}
+
+func ecJWK(kid string, key *ecdsa.PublicKey) map[string]any {
+	return map[string]any{
+		"kty": "EC", "kid": kid, "crv": "P-256",
+		"x": encodeInt(key.X), "y": encodeInt(key.Y),
+	}
+}
+
+func jwksJSON(t *testing.T, keys ...map[string]any) []byte {
+	data, err := json.Marshal(map[string]any{"keys": keys})
+	require.NoError(t, err)
+	return data
+}
+
+func TestJWKS_File(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	filename := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(filename, jwksJSON(t,
+		rsaJWK("rsa-1", &rsaKey.PublicKey),
+		ecJWK("ec-1", &ecKey.PublicKey),
+		// 用于加密的密钥会被忽略
+		map[string]any{"kty": "RSA", "kid": "enc-1", "use": "enc", "n": "AQAB", "e": "AQAB"},
+		// 不认识的密钥也会被忽略
+		map[string]any{"kty": "OKP", "kid": "ed-1"},
+	), 0o644))
+
+	builder := NewJWTBuilder(NewJWKS(filename, time.Hour))
+	claims := map[string]any{"sub": "tom"}
+	_, err = builder.Verify(context.Background(), signJWT(t, "RS256", "rsa-1", rsaKey, claims))
+	assert.NoError(t, err)
+	_, err = builder.Verify(context.Background(), signJWT(t, "ES256", "ec-1", ecKey, claims))
+	assert.NoError(t, err)
+	// kid 对不上
+	_, err = builder.Verify(context.Background(), signJWT(t, "ES256", "rsa-1", ecKey, claims))
+	assert.ErrorIs(t, err, ErrAlgorithm)
+	_, err = builder.Verify(context.Background(), signJWT(t, "RS256", "enc-1", rsaKey, claims))
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	// 有多个密钥的时候必须要有 kid
+	_, err = builder.Verify(context.Background(), signJWT(t, "RS256", "", rsaKey, claims))
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+}
+
+func TestJWKS_URL(t *testing.T) {
+	key1, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	key2, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	var fetches atomic.Int32
+	var rotated, failing atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		if failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		keys := []map[string]any{ecJWK("key-1", &key1.PublicKey)}
+		if rotated.Load() {
+			keys = append(keys, ecJWK("key-2", &key2.PublicKey))
+		}
+		_, _ = w.Write(jwksJSON(t, keys...))
+	}))
+	defer srv.Close()
+
+	now := time.Now()
+	jwks := NewJWKS(srv.URL, time.Hour)
+	jwks.now = func() time.Time {
+		return now
+	}
+	builder := NewJWTBuilder(jwks)
+	claims := map[string]any{"sub": "tom"}
+	verify := func(kid string, key *ecdsa.PrivateKey) error {
+		_, err := builder.Verify(context.Background(), signJWT(t, "ES256", kid, key, claims))
+		return err
+	}
+
+	// 后续的请求使用缓存
+	for i := 0; i < 3; i++ {
+		require.NoError(t, verify("key-1", key1))
+	}
+	assert.Equal(t, int32(1), fetches.Load())
+
+	// 密钥轮换了，但是离上次加载的时间太近，不会重新加载
+	rotated.Store(true)
+	assert.ErrorIs(t, verify("key-2", key2), ErrKeyNotFound)
+	assert.Equal(t, int32(1), fetches.Load())
+
+	now = now.Add(minRefreshInterval)
+	assert.NoError(t, verify("key-2", key2))
+	assert.Equal(t, int32(2), fetches.Load())
+
+	// 缓存过期了在后台重新加载，失败的时候继续用之前的密钥
+	failing.Store(true)
+	now = now.Add(time.Hour)
+	assert.NoError(t, verify("key-1", key1))
+	assert.Eventually(t, func() bool {
+		return fetches.Load() == 3
+	}, time.Second, 10*time.Millisecond)
+	assert.NoError(t, verify("key-1", key1))
+}
+
+func TestJWKS_FirstLoadFailed(t *testing.T) {
+	var fetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	now := time.Now()
+	jwks := NewJWKS(srv.URL, time.Hour)
+	jwks.now = func() time.Time {
+		return now
+	}
+	// JWKS 的服务挂了，不能每个请求都去加载
+	for i := 0; i < 20; i++ {
+		_, err := jwks.Key(context.Background(), "key-1", "ES256")
+		assert.Error(t, err)
+	}
+	assert.Equal(t, int32(1), fetches.Load())
+
+	now = now.Add(minRefreshInterval)
+	_, err := jwks.Key(context.Background(), "key-1", "ES256")
+	assert.Error(t, err)
+	assert.Equal(t, int32(2), fetches.Load())
+}
+
+func TestJWKS_SlowRefresh(t *testing.T) {
+	key1, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	var fetches atomic.Int32
+	var slow atomic.Bool
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		if slow.Load() {
+			<-release
+		}
+		_, _ = w.Write(jwksJSON(t, ecJWK("key-1", &key1.PublicKey)))
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	var now atomic.Int64
+	now.Store(time.Now().UnixNano())
+	jwks := NewJWKS(srv.URL, time.Hour)
+	jwks.now = func() time.Time {
+		return time.Unix(0, now.Load())
+	}
+	builder := NewJWTBuilder(jwks)
+	token := signJWT(t, "ES256", "key-1", key1, map[string]any{"sub": "tom"})
+	_, err = builder.Verify(context.Background(), token)
+	require.NoError(t, err)
+
+	// JWKS 的服务很慢，过期之后还是用旧的密钥，不会阻塞请求
+	slow.Store(true)
+	now.Add(int64(time.Hour))
+	for i := 0; i < 3; i++ {
+		_, err = builder.Verify(context.Background(), token)
+		assert.NoError(t, err)
+	}
+	assert.Eventually(t, func() bool {
+		return fetches.Load() == 2
+	}, time.Second, 10*time.Millisecond)
+
+	// 等待轮换的密钥的请求取消了，不影响正在进行的加载
+	now.Add(int64(minRefreshInterval))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = builder.Verify(ctx, signJWT(t, "ES256", "key-2", key1, map[string]any{"sub": "tom"}))
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, int32(2), fetches.Load())
+}
@@ -0,0 +1,302 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+	"web"
+)
+
+var (
+	ErrTokenMalformed = errors.New("auth: token 格式不正确")
+	ErrAlgorithm      = errors.New("auth: 不支持的签名算法")
+	ErrSignature      = errors.New("auth: 签名不正确")
+	ErrTokenExpired   = errors.New("auth: token 已过期")
+	ErrTokenNotValid  = errors.New("auth: token 尚未生效")
+	ErrAudience       = errors.New("auth: aud 不匹配")
+	ErrIssuer         = errors.New("auth: iss 不匹配")
+	ErrKeyNotFound    = errors.New("auth: 找不到密钥")
+)
+
+// KeySet 提供校验 JWT 签名的密钥
+// HS256 使用 []byte，RS256 使用 *rsa.PublicKey，ES256 使用 *ecdsa.PublicKey
+type KeySet interface {
+	// Key 根据 JWT 头部的 kid 和 alg 查找密钥
+	Key(ctx context.Context, kid string, alg string) (any, error)
+}
+
+type staticKey struct {
+	key any
+}
+
+func (s staticKey) Key(ctx context.Context, kid string, alg string) (any, error) {
+	return s.key, nil
+}
+
+// HMACKey HS256 使用的共享密钥
+func HMACKey(secret []byte) KeySet {
+	return staticKey{key: secret}
+}
+
+// PublicKey RS256 或者 ES256 使用的公钥
+func PublicKey(key crypto.PublicKey) KeySet {
+	return staticKey{key: key}
+}
+
+type JWTBuilder struct {
+	keys     KeySet
+	audience string
+	issuer   string
+	leeway   time.Duration
+	optional bool
+	now      func() time.Time
+}
+
+// NewJWTBuilder 校验 Authorization: Bearer 里面的 JWT
+// 支持 HS256、RS256 和 ES256，算法必须和密钥的类型匹配，防止算法混淆攻击
+func NewJWTBuilder(keys KeySet) *JWTBuilder {
+	return &JWTBuilder{
+		keys: keys,
+		now:  time.Now,
+	}
+}
+
+// Audience 要求 aud 包含 audience
+func (b *JWTBuilder) Audience(audience string) *JWTBuilder {
+	b.audience = audience
+	return b
+}
+
+// Issuer 要求 iss 等于 issuer
+func (b *JWTBuilder) Issuer(issuer string) *JWTBuilder {
+	b.issuer = issuer
+	return b
+}
+
+// Leeway 校验 exp 和 nbf 的时候允许的时钟偏差
+func (b *JWTBuilder) Leeway(leeway time.Duration) *JWTBuilder {
+	b.leeway = leeway
+	return b
+}
+
+// Optional 没有 Authorization 头部的请求也放行，交给后面按照路由鉴权
+func (b *JWTBuilder) Optional() *JWTBuilder {
+	b.optional = true
+	return b
+}
+
+func (b *JWTBuilder) Build() web.Middleware {
+	return func(next web.HandleFunc) web.HandleFunc {
+		return func(ctx *web.Context) {
+			authorization := ctx.Req.Header.Get("Authorization")
+			if b.optional && authorization == "" {
+				next(ctx)
+				return
+			}
+			scheme, token, _ := strings.Cut(authorization, " ")
+			if !strings.EqualFold(scheme, "Bearer") || token == "" {
+				unauthorized(ctx, "Bearer")
+				return
+			}
+			claims, err := b.Verify(ctx.Req.Context(), token)
+			if err != nil {
+				// RFC 6750 的错误格式，具体的原因只打日志
+				ctx.Logger().Info("auth: JWT 校验失败", "err", err)
+				unauthorized(ctx, `Bearer error="invalid_token"`)
+				return
+			}
+			setPrincipal(ctx, principalFromClaims(claims))
+			next(ctx)
+		}
+	}
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Verify 校验 JWT 的签名和 exp、nbf、aud、iss，返回 claims
+func (b *JWTBuilder) Verify(ctx context.Context, token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrTokenMalformed
+	}
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrTokenMalformed
+	}
+	key, err := b.keys.Key(ctx, header.Kid, header.Alg)
+	if err != nil {
+		return nil, err
+	}
+	if err = verifySignature(header.Alg, key, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]any
+	if err = decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if err = b.validate(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (b *JWTBuilder) validate(claims map[string]any) error {
+	now := b.now()
+	exp, ok, err := numericDate(claims, "exp")
+	if err != nil {
+		return err
+	}
+	if ok && !now.Before(exp.Add(b.leeway)) {
+		return ErrTokenExpired
+	}
+	nbf, ok, err := numericDate(claims, "nbf")
+	if err != nil {
+		return err
+	}
+	if ok && now.Add(b.leeway).Before(nbf) {
+		return ErrTokenNotValid
+	}
+	if b.issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != b.issuer {
+			return ErrIssuer
+		}
+	}
+	if b.audience != "" && !contains(stringList(claims["aud"]), b.audience) {
+		return ErrAudience
+	}
+	return nil
+}
+
+func verifySignature(alg string, key any, signingInput string, sig []byte) error {
+	hash := sha256.Sum256([]byte(signingInput))
+	switch alg {
+	case "HS256":
+		secret, ok := key.([]byte)
+		if !ok {
+			return ErrAlgorithm
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(signingInput))
+		if !hmac.Equal(mac.Sum(nil), sig) {
+			return ErrSignature
+		}
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return ErrAlgorithm
+		}
+		if rsa.VerifyPKCS1v15(pub, crypto.SHA256, hash[:], sig) != nil {
+			return ErrSignature
+		}
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || pub.Curve != elliptic.P256() {
+			return ErrAlgorithm
+		}
+		// JWS 里面的签名是定长的 r 和 s 拼接起来的，不是 ASN.1 格式
+		if len(sig) != 64 {
+			return ErrSignature
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(pub, hash[:], r, s) {
+			return ErrSignature
+		}
+	default:
+		// none 之类的算法一律拒绝
+		return fmt.Errorf("%w: %s", ErrAlgorithm, alg)
+	}
+	return nil
+}
+
+func decodeSegment(seg string, val any) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return ErrTokenMalformed
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	// 避免大的数字丢失精度
+	decoder.UseNumber()
+	if err = decoder.Decode(val); err != nil {
+		return ErrTokenMalformed
+	}
+	return nil
+}
+
+// numericDate 没有这个 claim 的时候第二个返回值是 false
+// 有但是不是数字的时候要返回错误，否则 "exp":"1" 这种 token 就永远不会过期
+func numericDate(claims map[string]any, key string) (time.Time, bool, error) {
+	val, ok := claims[key]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	num, ok := val.(json.Number)
+	if !ok {
+		return time.Time{}, false, fmt.Errorf("%w: %s 不是数字", ErrTokenMalformed, key)
+	}
+	f, err := num.Float64()
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("%w: %s 不是数字", ErrTokenMalformed, key)
+	}
+	sec := int64(f)
+	return time.Unix(sec, int64((f-float64(sec))*float64(time.Second))), true, nil
+}
+
+// stringList aud、roles 之类的 claim 可能是字符串，也可能是字符串数组
+func stringList(val any) []string {
+	switch v := val.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		res := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				res = append(res, s)
+			}
+		}
+		return res
+	}
+	return nil
+}
+
+func contains(list []string, target string) bool {
+	for _, s := range list {
+		if s == target {
+			return true
+		}
+	}
+	return false
+}
+
+// principalFromClaims scope 是 RFC 8693 定义的空格分隔的字符串，scp 是一些厂商使用的数组
+func principalFromClaims(claims map[string]any) *Principal {
+	p := &Principal{Method: "jwt", Claims: claims}
+	p.Subject, _ = claims["sub"].(string)
+	p.Roles = stringList(claims["roles"])
+	if scope, ok := claims["scope"].(string); ok {
+		p.Scopes = strings.Fields(scope)
+	} else {
+		p.Scopes = stringList(claims["scp"])
+	}
+	return p
+}
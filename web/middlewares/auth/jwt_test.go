@@ -0,0 +1,245 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"web"
+)
+
+// signJWT 测试用的签名
+func signJWT(t *testing.T, alg string, kid string, key any, claims map[string]any) string {
+	t.Helper()
+	header := map[string]any{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	h, err := json.Marshal(header)
+	require.NoError(t, err)
+	c, err := json.Marshal(claims)
+	require.NoError(t, err)
+	input := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	hash := sha256.Sum256([]byte(input))
+
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(input))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, hash[:])
+		require.NoError(t, err)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, hash[:])
+		require.NoError(t, err)
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestJWTBuilder_Verify(t *testing.T) {
+	secret := []byte("secret")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	now := time.Unix(1700000000, 0)
+	valid := map[string]any{
+		"sub": "tom",
+		"iss": "https://auth.example.com",
+		"aud": []string{"api", "web"},
+		"exp": now.Add(time.Minute).Unix(),
+		"nbf": now.Add(-time.Minute).Unix(),
+	}
+	with := func(key string, val any) map[string]any {
+		res := make(map[string]any, len(valid))
+		for k, v := range valid {
+			res[k] = v
+		}
+		res[key] = val
+		return res
+	}
+
+	testCases := []struct {
+		name    string
+		keys    KeySet
+		token   string
+		leeway  time.Duration
+		wantErr error
+	}{
+		{name: "HS256", keys: HMACKey(secret), token: signJWT(t, "HS256", "", secret, valid)},
+		{name: "RS256", keys: PublicKey(&rsaKey.PublicKey), token: signJWT(t, "RS256", "", rsaKey, valid)},
+		{name: "ES256", keys: PublicKey(&ecKey.PublicKey), token: signJWT(t, "ES256", "", ecKey, valid)},
+		{
+			name:    "wrong secret",
+			keys:    HMACKey([]byte("other")),
+			token:   signJWT(t, "HS256", "", secret, valid),
+			wantErr: ErrSignature,
+		},
+		{
+			// 用公钥当作 HMAC 的密钥伪造签名
+			name:    "algorithm confusion",
+			keys:    PublicKey(&rsaKey.PublicKey),
+			token:   signJWT(t, "HS256", "", secret, valid),
+			wantErr: ErrAlgorithm,
+		},
+		{
+			name:    "none",
+			keys:    HMACKey(secret),
+			token:   signJWT(t, "none", "", nil, valid),
+			wantErr: ErrAlgorithm,
+		},
+		{
+			name:    "expired",
+			keys:    HMACKey(secret),
+			token:   signJWT(t, "HS256", "", secret, with("exp", now.Add(-time.Second).Unix())),
+			wantErr: ErrTokenExpired,
+		},
+		{
+			name:   "expired within leeway",
+			keys:   HMACKey(secret),
+			token:  signJWT(t, "HS256", "", secret, with("exp", now.Add(-time.Second).Unix())),
+			leeway: time.Minute,
+		},
+		{
+			name:    "not before",
+			keys:    HMACKey(secret),
+			token:   signJWT(t, "HS256", "", secret, with("nbf", now.Add(time.Minute).Unix())),
+			wantErr: ErrTokenNotValid,
+		},
+		{
+			// 不是数字的 exp 不能被忽略，否则永远不会过期
+			name:    "string exp",
+			keys:    HMACKey(secret),
+			token:   signJWT(t, "HS256", "", secret, with("exp", "1")),
+			wantErr: ErrTokenMalformed,
+		},
+		{
+			name:    "null nbf",
+			keys:    HMACKey(secret),
+			token:   signJWT(t, "HS256", "", secret, with("nbf", nil)),
+			wantErr: ErrTokenMalformed,
+		},
+		{
+			name:  "single audience",
+			keys:  HMACKey(secret),
+			token: signJWT(t, "HS256", "", secret, with("aud", "api")),
+		},
+		{
+			name:    "wrong audience",
+			keys:    HMACKey(secret),
+			token:   signJWT(t, "HS256", "", secret, with("aud", "admin")),
+			wantErr: ErrAudience,
+		},
+		{
+			name:    "wrong issuer",
+			keys:    HMACKey(secret),
+			token:   signJWT(t, "HS256", "", secret, with("iss", "https://evil.com")),
+			wantErr: ErrIssuer,
+		},
+		{
+			name:    "malformed",
+			keys:    HMACKey(secret),
+			token:   "abc.def",
+			wantErr: ErrTokenMalformed,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			builder := NewJWTBuilder(tc.keys).Issuer("https://auth.example.com").Audience("api").Leeway(tc.leeway)
+			builder.now = func() time.Time {
+				return now
+			}
+			claims, err := builder.Verify(context.Background(), tc.token)
+			assert.ErrorIs(t, err, tc.wantErr)
+			if err == nil {
+				assert.Equal(t, "tom", claims["sub"])
+			}
+		})
+	}
+}
+
+func TestJWTBuilder_Build(t *testing.T) {
+	secret := []byte("secret")
+	server := web.NewHttpServer(web.ServerWithMiddleware(NewJWTBuilder(HMACKey(secret)).Build()))
+	var principal *Principal
+	server.Get("/user", func(ctx *web.Context) {
+		principal, _ = PrincipalFromContext(ctx)
+	})
+
+	token := signJWT(t, "HS256", "", secret, map[string]any{
+		"sub":   "tom",
+		"scope": "user:read user:write",
+		"roles": []string{"admin"},
+		"exp":   time.Now().Add(time.Minute).Unix(),
+	})
+	req := httptest.NewRequest(http.MethodGet, "/user", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	require.NotNil(t, principal)
+	assert.Equal(t, "tom", principal.Subject)
+	assert.Equal(t, "jwt", principal.Method)
+	assert.Equal(t, []string{"admin"}, principal.Roles)
+	assert.Equal(t, []string{"user:read", "user:write"}, principal.Scopes)
+	assert.Equal(t, "tom", principal.Claims["sub"])
+
+	testCases := []struct {
+		name          string
+		authorization string
+		wantChallenge string
+	}{
+		{name: "no token", wantChallenge: "Bearer"},
+		{name: "basic", authorization: "Basic abc", wantChallenge: "Bearer"},
+		{name: "invalid", authorization: "Bearer " + token + "x", wantChallenge: `Bearer error="invalid_token"`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/user", nil)
+			if tc.authorization != "" {
+				req.Header.Set("Authorization", tc.authorization)
+			}
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, req)
+			assert.Equal(t, http.StatusUnauthorized, resp.Code)
+			assert.Equal(t, tc.wantChallenge, resp.Header().Get("WWW-Authenticate"))
+		})
+	}
+}
+
+func TestJWTBuilder_Optional(t *testing.T) {
+	server := web.NewHttpServer(web.ServerWithMiddleware(NewJWTBuilder(HMACKey([]byte("secret"))).Optional().Build()))
+	var ok bool
+	server.Get("/user", func(ctx *web.Context) {
+		_, ok = PrincipalFromContext(ctx)
+	})
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/user", nil))
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.False(t, ok)
+
+	// 携带了错误的 token 依旧拒绝
+	req := httptest.NewRequest(http.MethodGet, "/user", nil)
+	req.Header.Set("Authorization", "Bearer abc")
+	resp = httptest.NewRecorder()
+	server.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+}
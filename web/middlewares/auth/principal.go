@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"log/slog"
+	"net/http"
+	"web"
+)
+
+// principalKey 通过认证的主体保存在 ctx.UserValues 里面的 key
+const principalKey = "auth_principal"
+
+// Principal 通过认证的主体
+type Principal struct {
+	// Subject 用户名、API key 对应的应用或者 JWT 里面的 sub
+	Subject string
+	// Method 认证的方式，basic、api_key 或者 jwt
+	Method string
+	Roles  []string
+	Scopes []string
+	// Claims JWT 的全部 claims，其它方式为 nil
+	Claims map[string]any
+}
+
+// PrincipalFromContext 返回当前请求通过认证的主体
+func PrincipalFromContext(ctx *web.Context) (*Principal, bool) {
+	val, ok := ctx.UserValue(principalKey)
+	if !ok {
+		return nil, false
+	}
+	p, ok := val.(*Principal)
+	return p, ok
+}
+
+func setPrincipal(ctx *web.Context, p *Principal) {
+	ctx.SetUserValue(principalKey, p)
+}
+
+// unauthorized 返回 401，challenge 是 WWW-Authenticate 头部
+func unauthorized(ctx *web.Context, challenge string) {
+	ctx.Resp.Header().Set("WWW-Authenticate", challenge)
+	ctx.RespStatusCode = http.StatusUnauthorized
+	ctx.Err = web.NewProblem(http.StatusUnauthorized)
+}
+
+// lookupFailed 查询凭证的时候出错了，例如数据库不可用，这个时候不能告诉客户端认证失败
+func lookupFailed(ctx *web.Context, err error) {
+	ctx.Logger().Error("auth: 查询凭证失败", slog.Any("err", err))
+	ctx.RespStatusCode = http.StatusInternalServerError
+	ctx.Err = err
+}
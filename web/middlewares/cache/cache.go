@@ -0,0 +1,114 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry 缓存的响应
+type Entry struct {
+	Status int
+	Header http.Header
+	Body   []byte
+	// StoredAt 写入缓存的时间，用来计算 Age 响应头
+	StoredAt time.Time
+}
+
+// Cache 缓存响应的地方，默认提供了基于内存的 LRU 实现
+// 多实例部署的时候可以基于 Redis 之类的实现，key 的格式见 MiddlewareBuilder.Build
+type Cache interface {
+	// Get 没有或者已经过期的时候返回 false
+	Get(ctx context.Context, key string) (Entry, bool, error)
+	Set(ctx context.Context, key string, entry Entry, ttl time.Duration) error
+	// DeletePrefix 删除所有以 prefix 开头的 key，例如某个资源更新之后删除相关的缓存
+	DeletePrefix(ctx context.Context, prefix string) error
+}
+
+// LRUCache 基于内存的 LRU 缓存，超过容量的时候淘汰最久没有使用的
+type LRUCache struct {
+	mutex    sync.Mutex
+	capacity int
+	items    map[string]*list.Element
+	// lru 头部是最近使用的
+	lru *list.List
+	now func() time.Time
+}
+
+type lruItem struct {
+	key       string
+	entry     Entry
+	expiresAt time.Time
+}
+
+// NewLRUCache capacity 是最多缓存的响应数量
+func NewLRUCache(capacity int) *LRUCache {
+	return &LRUCache{
+		capacity: capacity,
+		items:    make(map[string]*list.Element, capacity),
+		lru:      list.New(),
+		now:      time.Now,
+	}
+}
+
+func (c *LRUCache) Get(_ context.Context, key string) (Entry, bool, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	elem, ok := c.items[key]
+	if !ok {
+		return Entry{}, false, nil
+	}
+	item := elem.Value.(*lruItem)
+	// 过期的懒删除
+	if !c.now().Before(item.expiresAt) {
+		c.remove(elem)
+		return Entry{}, false, nil
+	}
+	c.lru.MoveToFront(elem)
+	return item.entry, true, nil
+}
+
+func (c *LRUCache) Set(_ context.Context, key string, entry Entry, ttl time.Duration) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	expiresAt := c.now().Add(ttl)
+	if elem, ok := c.items[key]; ok {
+		item := elem.Value.(*lruItem)
+		item.entry = entry
+		item.expiresAt = expiresAt
+		c.lru.MoveToFront(elem)
+		return nil
+	}
+	c.items[key] = c.lru.PushFront(&lruItem{key: key, entry: entry, expiresAt: expiresAt})
+	for c.lru.Len() > c.capacity {
+		c.remove(c.lru.Back())
+	}
+	return nil
+}
+
+// DeletePrefix 需要遍历所有的 key，缓存的数量不多的时候问题不大
+func (c *LRUCache) DeletePrefix(_ context.Context, prefix string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for key, elem := range c.items {
+		if strings.HasPrefix(key, prefix) {
+			c.remove(elem)
+		}
+	}
+	return nil
+}
+
+// Len 缓存的数量，包括已经过期但是还没有删除的
+func (c *LRUCache) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.lru.Len()
+}
+
+func (c *LRUCache) remove(elem *list.Element) {
+	item := c.lru.Remove(elem).(*lruItem)
+	delete(c.items, item.key)
+}
@@ -0,0 +1,65 @@
+package cache
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestLRUCache_Evict(t *testing.T) {
+	ctx := context.Background()
+	c := NewLRUCache(2)
+	require.NoError(t, c.Set(ctx, "a", Entry{Body: []byte("a")}, time.Minute))
+	require.NoError(t, c.Set(ctx, "b", Entry{Body: []byte("b")}, time.Minute))
+	// 访问之后 a 变成最近使用的，淘汰的是 b
+	_, ok, err := c.Get(ctx, "a")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	require.NoError(t, c.Set(ctx, "c", Entry{Body: []byte("c")}, time.Minute))
+	assert.Equal(t, 2, c.Len())
+
+	_, ok, _ = c.Get(ctx, "b")
+	assert.False(t, ok)
+	entry, ok, _ := c.Get(ctx, "a")
+	assert.True(t, ok)
+	assert.Equal(t, "a", string(entry.Body))
+
+	// 覆盖已有的 key 不会淘汰
+	require.NoError(t, c.Set(ctx, "c", Entry{Body: []byte("cc")}, time.Minute))
+	assert.Equal(t, 2, c.Len())
+	entry, _, _ = c.Get(ctx, "c")
+	assert.Equal(t, "cc", string(entry.Body))
+}
+
+func TestLRUCache_Expire(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	c := NewLRUCache(10)
+	c.now = func() time.Time {
+		return now
+	}
+	require.NoError(t, c.Set(ctx, "a", Entry{}, time.Second))
+	_, ok, _ := c.Get(ctx, "a")
+	assert.True(t, ok)
+
+	now = now.Add(time.Second)
+	_, ok, _ = c.Get(ctx, "a")
+	assert.False(t, ok)
+	assert.Equal(t, 0, c.Len())
+}
+
+func TestLRUCache_DeletePrefix(t *testing.T) {
+	ctx := context.Background()
+	c := NewLRUCache(10)
+	for _, key := range []string{"GET /users/1", "GET /users/1?page=2", "GET /users/2", "GET /orders"} {
+		require.NoError(t, c.Set(ctx, key, Entry{}, time.Minute))
+	}
+	require.NoError(t, c.DeletePrefix(ctx, "GET /users/1"))
+	assert.Equal(t, 2, c.Len())
+	_, ok, _ := c.Get(ctx, "GET /users/2")
+	assert.True(t, ok)
+	_, ok, _ = c.Get(ctx, "GET /users/1?page=2")
+	assert.False(t, ok)
+}
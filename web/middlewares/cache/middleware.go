@@ -0,0 +1,354 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"web"
+)
+
+type MiddlewareBuilder struct {
+	cache   Cache
+	ttl     time.Duration
+	routes  []routeTTL
+	query   []string
+	headers []string
+	group   *group
+	// credentials 带有 Authorization 或者 Cookie 的请求也使用缓存
+	credentials bool
+}
+
+type routeTTL struct {
+	pattern string
+	ttl     time.Duration
+}
+
+// perRequestHeaders 每个请求都不一样的响应头，不能缓存
+// Content-Security-Policy 里面可能带有 nonce，重放之后等于没有
+var perRequestHeaders = map[string]bool{
+	"Set-Cookie":                          true,
+	"Content-Security-Policy":             true,
+	"Content-Security-Policy-Report-Only": true,
+	"X-Cache":                             true,
+	"Age":                                 true,
+}
+
+// cacheableStatus 默认可以缓存的响应码，参考 RFC 9110 15.1
+var cacheableStatus = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMovedPermanently:     true,
+	http.StatusNotFound:             true,
+	http.StatusGone:                 true,
+}
+
+// NewMiddlewareBuilder 只缓存 GET 请求，默认缓存 1 分钟
+// 要放在 compress 之类会修改 RespData 的 middleware 的里面，这样缓存的是原始的响应
+func NewMiddlewareBuilder(cache Cache) *MiddlewareBuilder {
+	return &MiddlewareBuilder{
+		cache: cache,
+		ttl:   time.Minute,
+		group: &group{},
+	}
+}
+
+// TTL 默认的缓存时间，小于等于 0 的时候只有 RouteTTL 设置了的路由才会缓存
+func (m *MiddlewareBuilder) TTL(ttl time.Duration) *MiddlewareBuilder {
+	m.ttl = ttl
+	return m
+}
+
+// RouteTTL 单独设置某些路由的缓存时间，支持 path.Match 的语法，先添加的优先
+// ttl 小于等于 0 的时候不缓存
+// 业务通过 Cache-Control 的 max-age 或者 s-maxage 指定的时间优先级更高
+func (m *MiddlewareBuilder) RouteTTL(pattern string, ttl time.Duration) *MiddlewareBuilder {
+	m.routes = append(m.routes, routeTTL{pattern: pattern, ttl: ttl})
+	return m
+}
+
+// Query 参与计算 key 的查询参数，其余的查询参数会被忽略
+func (m *MiddlewareBuilder) Query(names ...string) *MiddlewareBuilder {
+	m.query = append(m.query, names...)
+	return m
+}
+
+// Header 参与计算 key 的请求头，例如 Accept、Accept-Language
+// 响应的 Vary 里面有没有加进来的请求头的时候不会缓存，否则所有的客户端都会拿到第一个版本
+func (m *MiddlewareBuilder) Header(names ...string) *MiddlewareBuilder {
+	for _, name := range names {
+		m.headers = append(m.headers, http.CanonicalHeaderKey(name))
+	}
+	return m
+}
+
+// AllowCredentials 默认带有 Authorization 或者 Cookie 的请求既不读缓存也不写缓存，因为响应一般是给特定用户的
+// 确定响应和用户无关，或者用 Header 把它们加入了 key 的时候才能打开
+// 带有 Authorization 的请求依旧要求响应明确允许缓存，也就是 public 或者 s-maxage
+func (m *MiddlewareBuilder) AllowCredentials() *MiddlewareBuilder {
+	m.credentials = true
+	return m
+}
+
+// Invalidate 删除 key 以 prefix 开头的缓存
+// 例如更新了用户之后，用 "GET /users/123" 删除这个用户相关的所有缓存
+// 注意前缀同样会匹配上 "GET /users/1234"，需要的时候可以带上 ? 或者 |
+func (m *MiddlewareBuilder) Invalidate(ctx context.Context, prefix string) error {
+	return m.cache.DeletePrefix(ctx, prefix)
+}
+
+// Build key 的格式是 "GET /users/123?page=1|Accept=application/json"
+// 查询参数按照名字排序，请求头按照 Header 添加的顺序
+func (m *MiddlewareBuilder) Build() web.Middleware {
+	return func(next web.HandleFunc) web.HandleFunc {
+		return func(ctx *web.Context) {
+			ttl := m.ttlOf(ctx.MatchedRoute)
+			if ctx.Req.Method != http.MethodGet || ttl <= 0 {
+				next(ctx)
+				return
+			}
+			reqCC := parseCacheControl(ctx.Req.Header.Values("Cache-Control"))
+			if _, ok := reqCC["no-store"]; ok {
+				next(ctx)
+				return
+			}
+			if !m.credentials && hasCredentials(ctx.Req) {
+				next(ctx)
+				return
+			}
+			key := m.key(ctx)
+			// 客户端要求重新验证的时候不使用缓存，但是新的结果还是可以缓存的
+			_, noCache := reqCC["no-cache"]
+			if noCache || reqCC["max-age"] == "0" {
+				m.fetch(ctx, next, key, ttl)
+				return
+			}
+
+			entry, ok, err := m.cache.Get(ctx.Req.Context(), key)
+			if err != nil {
+				// 缓存出问题的时候直接执行业务，不影响请求
+				ctx.Logger().Error("cache: 读取缓存失败", "key", key, "err", err)
+			}
+			if ok {
+				m.serve(ctx, entry)
+				return
+			}
+
+			c, leader := m.group.join(key)
+			if !leader {
+				select {
+				case <-c.done:
+					if c.entry != nil {
+						m.serve(ctx, *c.entry)
+						return
+					}
+					// 前面的响应不能缓存，只能自己执行
+					m.fetch(ctx, next, key, ttl)
+				case <-ctx.Req.Context().Done():
+					ctx.RespStatusCode = http.StatusServiceUnavailable
+					ctx.Err = ctx.Req.Context().Err()
+				}
+				return
+			}
+			// 业务 panic 的时候也要让等待的请求继续
+			var res *Entry
+			defer func() {
+				m.group.finish(key, c, res)
+			}()
+			res = m.fetch(ctx, next, key, ttl)
+		}
+	}
+}
+
+// fetch 执行业务并且缓存结果，不能缓存的时候返回 nil
+func (m *MiddlewareBuilder) fetch(ctx *web.Context, next web.HandleFunc, key string, ttl time.Duration) *Entry {
+	// 外面的 middleware 设置的响应头，例如 X-Request-ID，是每个请求都不一样的，不能缓存
+	before := ctx.Resp.Header().Clone()
+	resp := ctx.Resp
+	w := web.NewResponseWriter(resp)
+	ctx.Resp = w
+	defer func() {
+		ctx.Resp = resp
+	}()
+	ctx.Resp.Header().Set("X-Cache", "MISS")
+
+	next(ctx)
+
+	// 业务直接写入了响应，没有办法缓存
+	// 使用了 CSP nonce 的页面里面也带着 nonce，同样不能缓存
+	if w.Wrote() || ctx.Err != nil || ctx.CSPNonce != "" {
+		return nil
+	}
+	status := ctx.RespStatusCode
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if !cacheableStatus[status] || !m.keyCovers(before, ctx.Resp.Header()) {
+		return nil
+	}
+	header := http.Header{}
+	for name, values := range ctx.Resp.Header() {
+		if perRequestHeaders[name] || slices.Equal(before[name], values) {
+			continue
+		}
+		header[name] = slices.Clone(values)
+	}
+	ttl, ok := responseTTL(ctx.Req, ctx.Resp.Header(), ttl)
+	if !ok {
+		return nil
+	}
+	entry := &Entry{
+		Status:   status,
+		Header:   header,
+		Body:     bytes.Clone(ctx.RespData),
+		StoredAt: time.Now(),
+	}
+	if err := m.cache.Set(ctx.Req.Context(), key, *entry, ttl); err != nil {
+		ctx.Logger().Error("cache: 写入缓存失败", "key", key, "err", err)
+	}
+	return entry
+}
+
+func (m *MiddlewareBuilder) serve(ctx *web.Context, entry Entry) {
+	header := ctx.Resp.Header()
+	for name, values := range entry.Header {
+		header[name] = slices.Clone(values)
+	}
+	age := int(time.Since(entry.StoredAt) / time.Second)
+	header.Set("Age", strconv.Itoa(max(age, 0)))
+	header.Set("X-Cache", "HIT")
+	ctx.RespStatusCode = entry.Status
+	// 外面的 middleware 可能会直接修改 RespData
+	ctx.RespData = bytes.Clone(entry.Body)
+}
+
+// keyCovers 业务通过 Vary 声明的请求头都参与了计算 key
+// 外面的 middleware 加的 Vary 不用管，例如 compress 的 Accept-Encoding，它在缓存的外面处理
+func (m *MiddlewareBuilder) keyCovers(before, after http.Header) bool {
+	outer := map[string]bool{}
+	for _, name := range varyOf(before) {
+		outer[name] = true
+	}
+	for _, name := range varyOf(after) {
+		if name == "*" {
+			return false
+		}
+		if !outer[name] && !slices.Contains(m.headers, name) {
+			return false
+		}
+	}
+	return true
+}
+
+// varyOf 解析 Vary，名字转成标准的格式
+func varyOf(header http.Header) []string {
+	var res []string
+	for _, val := range header.Values("Vary") {
+		for _, name := range strings.Split(val, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				res = append(res, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	return res
+}
+
+// hasCredentials 请求带有用户的凭证
+func hasCredentials(req *http.Request) bool {
+	return req.Header.Get("Authorization") != "" || req.Header.Get("Cookie") != ""
+}
+
+func (m *MiddlewareBuilder) key(ctx *web.Context) string {
+	var sb strings.Builder
+	sb.WriteString(ctx.Req.Method)
+	sb.WriteByte(' ')
+	sb.WriteString(ctx.Req.URL.Path)
+	if len(m.query) > 0 {
+		query := ctx.Req.URL.Query()
+		selected := url.Values{}
+		for _, name := range m.query {
+			if values, ok := query[name]; ok {
+				selected[name] = values
+			}
+		}
+		if len(selected) > 0 {
+			sb.WriteByte('?')
+			// Encode 会按照名字排序
+			sb.WriteString(selected.Encode())
+		}
+	}
+	for _, name := range m.headers {
+		sb.WriteByte('|')
+		sb.WriteString(name)
+		sb.WriteByte('=')
+		sb.WriteString(strings.Join(ctx.Req.Header.Values(name), ","))
+	}
+	return sb.String()
+}
+
+func (m *MiddlewareBuilder) ttlOf(route string) time.Duration {
+	for _, r := range m.routes {
+		if r.pattern == route {
+			return r.ttl
+		}
+		if ok, _ := path.Match(r.pattern, route); ok {
+			return r.ttl
+		}
+	}
+	return m.ttl
+}
+
+// responseTTL 根据响应的 Cache-Control 决定能不能缓存以及缓存多久
+// 作为共享缓存，s-maxage 的优先级比 max-age 高
+func responseTTL(req *http.Request, header http.Header, ttl time.Duration) (time.Duration, bool) {
+	// 带有 Cookie 的响应是给特定用户的
+	if header.Get("Set-Cookie") != "" {
+		return 0, false
+	}
+	cc := parseCacheControl(header.Values("Cache-Control"))
+	for _, directive := range []string{"no-store", "no-cache", "private"} {
+		if _, ok := cc[directive]; ok {
+			return 0, false
+		}
+	}
+	_, public := cc["public"]
+	sMaxAge, shared := cc["s-maxage"]
+	// RFC 9111 3.5，带有 Authorization 的请求，只有明确允许的时候才能缓存
+	if req.Header.Get("Authorization") != "" && !public && !shared {
+		return 0, false
+	}
+	maxAge, ok := cc["max-age"]
+	if shared {
+		maxAge, ok = sMaxAge, true
+	}
+	if !ok {
+		return ttl, true
+	}
+	seconds, err := strconv.Atoi(maxAge)
+	if err != nil || seconds <= 0 {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// parseCacheControl 指令的名字转成小写，没有值的指令值是空字符串
+func parseCacheControl(values []string) map[string]string {
+	res := map[string]string{}
+	for _, val := range values {
+		for _, directive := range strings.Split(val, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			res[name] = strings.Trim(strings.TrimSpace(arg), `"`)
+		}
+	}
+	return res
+}
@@ -0,0 +1,468 @@
+package cache
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"web"
+)
+
+func serve(server *web.HttpServer, req *http.Request) *httptest.ResponseRecorder {
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, req)
+	return resp
+}
+
+func TestMiddlewareBuilder_Build(t *testing.T) {
+	var cnt atomic.Int64
+	builder := NewMiddlewareBuilder(NewLRUCache(100)).
+		Query("page").
+		Header("Accept-Language")
+	var reqID atomic.Int64
+	server := web.NewHttpServer(web.ServerWithMiddleware(
+		func(next web.HandleFunc) web.HandleFunc {
+			return func(ctx *web.Context) {
+				// 模拟 requestid 之类的 middleware，每个请求都不一样
+				ctx.Resp.Header().Set("X-Request-ID", strconv.FormatInt(reqID.Add(1), 10))
+				next(ctx)
+			}
+		},
+		builder.Build()))
+	server.Get("/users/:id", func(ctx *web.Context) {
+		n := cnt.Add(1)
+		ctx.Resp.Header().Set("Content-Type", "text/plain")
+		ctx.RespStatusCode = http.StatusOK
+		ctx.RespData = []byte(strconv.FormatInt(n, 10))
+	})
+	server.Post("/users/:id", func(ctx *web.Context) {
+		ctx.RespData = []byte(strconv.FormatInt(cnt.Add(1), 10))
+	})
+
+	resp := serve(server, httptest.NewRequest(http.MethodGet, "/users/1", nil))
+	assert.Equal(t, "1", resp.Body.String())
+	assert.Equal(t, "MISS", resp.Header().Get("X-Cache"))
+
+	resp = serve(server, httptest.NewRequest(http.MethodGet, "/users/1", nil))
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "1", resp.Body.String())
+	assert.Equal(t, "HIT", resp.Header().Get("X-Cache"))
+	assert.Equal(t, "0", resp.Header().Get("Age"))
+	assert.Equal(t, "text/plain", resp.Header().Get("Content-Type"))
+	// 外面的 middleware 设置的响应头不会被缓存
+	assert.Equal(t, "2", resp.Header().Get("X-Request-ID"))
+
+	// 没有选中的查询参数不影响 key
+	resp = serve(server, httptest.NewRequest(http.MethodGet, "/users/1?sort=name", nil))
+	assert.Equal(t, "1", resp.Body.String())
+	resp = serve(server, httptest.NewRequest(http.MethodGet, "/users/1?page=2", nil))
+	assert.Equal(t, "2", resp.Body.String())
+
+	req := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+	req.Header.Set("Accept-Language", "zh-CN")
+	resp = serve(server, req)
+	assert.Equal(t, "3", resp.Body.String())
+
+	// POST 不缓存
+	resp = serve(server, httptest.NewRequest(http.MethodPost, "/users/1", nil))
+	assert.Equal(t, "4", resp.Body.String())
+	resp = serve(server, httptest.NewRequest(http.MethodPost, "/users/1", nil))
+	assert.Equal(t, "5", resp.Body.String())
+
+	// 删除 /users/1 相关的所有缓存
+	require.NoError(t, builder.Invalidate(context.Background(), "GET /users/1"))
+	resp = serve(server, httptest.NewRequest(http.MethodGet, "/users/1?page=2", nil))
+	assert.Equal(t, "6", resp.Body.String())
+	assert.Equal(t, "MISS", resp.Header().Get("X-Cache"))
+}
+
+func TestMiddlewareBuilder_CacheControl(t *testing.T) {
+	testCases := []struct {
+		name        string
+		reqHeader   http.Header
+		credentials bool
+		handler     web.HandleFunc
+		// wantSecond 第二个请求的响应，一样说明命中了缓存
+		wantSecond string
+	}{
+		{
+			name:       "cached",
+			wantSecond: "1",
+		},
+		{
+			name:       "request no-cache",
+			reqHeader:  http.Header{"Cache-Control": {"no-cache"}},
+			wantSecond: "2",
+		},
+		{
+			name:       "request max-age=0",
+			reqHeader:  http.Header{"Cache-Control": {"max-age=0"}},
+			wantSecond: "2",
+		},
+		{
+			name:       "request no-store",
+			reqHeader:  http.Header{"Cache-Control": {"no-store"}},
+			wantSecond: "2",
+		},
+		{
+			name: "response no-store",
+			handler: func(ctx *web.Context) {
+				ctx.Resp.Header().Set("Cache-Control", "no-store")
+			},
+			wantSecond: "2",
+		},
+		{
+			name: "response private",
+			handler: func(ctx *web.Context) {
+				ctx.Resp.Header().Set("Cache-Control", "private, max-age=60")
+			},
+			wantSecond: "2",
+		},
+		{
+			name: "response max-age=0",
+			handler: func(ctx *web.Context) {
+				ctx.Resp.Header().Set("Cache-Control", "max-age=0")
+			},
+			wantSecond: "2",
+		},
+		{
+			name: "set cookie",
+			handler: func(ctx *web.Context) {
+				ctx.SetCookie(&http.Cookie{Name: "sess", Value: "abc"})
+			},
+			wantSecond: "2",
+		},
+		{
+			name: "error",
+			handler: func(ctx *web.Context) {
+				ctx.RespStatusCode = http.StatusNotFound
+				ctx.Err = web.NewProblem(http.StatusNotFound)
+			},
+			wantSecond: "2",
+		},
+		{
+			name: "not cacheable status",
+			handler: func(ctx *web.Context) {
+				ctx.RespStatusCode = http.StatusInternalServerError
+			},
+			wantSecond: "2",
+		},
+		{
+			name: "write directly",
+			handler: func(ctx *web.Context) {
+				_, _ = ctx.Resp.Write([]byte("x"))
+			},
+			wantSecond: "x2",
+		},
+		{
+			name:       "authorization",
+			reqHeader:  http.Header{"Authorization": {"Bearer abc"}},
+			wantSecond: "2",
+		},
+		{
+			// 默认不缓存带有凭证的请求，哪怕响应允许
+			name:      "authorization public",
+			reqHeader: http.Header{"Authorization": {"Bearer abc"}},
+			handler: func(ctx *web.Context) {
+				ctx.Resp.Header().Set("Cache-Control", "public, max-age=60")
+			},
+			wantSecond: "2",
+		},
+		{
+			name:        "allow credentials authorization",
+			reqHeader:   http.Header{"Authorization": {"Bearer abc"}},
+			credentials: true,
+			wantSecond:  "2",
+		},
+		{
+			name:        "allow credentials authorization public",
+			reqHeader:   http.Header{"Authorization": {"Bearer abc"}},
+			credentials: true,
+			handler: func(ctx *web.Context) {
+				ctx.Resp.Header().Set("Cache-Control", "public, max-age=60")
+			},
+			wantSecond: "1",
+		},
+		{
+			name:       "cookie",
+			reqHeader:  http.Header{"Cookie": {"sess=abc"}},
+			wantSecond: "2",
+		},
+		{
+			name:        "allow credentials cookie",
+			reqHeader:   http.Header{"Cookie": {"sess=abc"}},
+			credentials: true,
+			wantSecond:  "1",
+		},
+		{
+			// 页面里面的 nonce 不能重放
+			name: "csp nonce",
+			handler: func(ctx *web.Context) {
+				ctx.CSPNonce = "abc"
+			},
+			wantSecond: "2",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cnt := 0
+			builder := NewMiddlewareBuilder(NewLRUCache(10))
+			if tc.credentials {
+				builder.AllowCredentials()
+			}
+			server := web.NewHttpServer(web.ServerWithMiddleware(builder.Build()))
+			server.Get("/user", func(ctx *web.Context) {
+				cnt++
+				if tc.handler != nil {
+					tc.handler(ctx)
+				}
+				ctx.RespData = []byte(strconv.Itoa(cnt))
+			})
+			for i := 0; i < 2; i++ {
+				req := httptest.NewRequest(http.MethodGet, "/user", nil)
+				for name, values := range tc.reqHeader {
+					req.Header[name] = values
+				}
+				resp := serve(server, req)
+				if i == 1 {
+					assert.Equal(t, tc.wantSecond, resp.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestMiddlewareBuilder_Vary(t *testing.T) {
+	testCases := []struct {
+		name    string
+		builder *MiddlewareBuilder
+		vary    string
+		// wantCnt 四个请求里面执行了几次业务
+		wantCnt int
+	}{
+		{
+			// 没有把 Accept-Language 加到 key 里面，不能缓存
+			name:    "not in key",
+			builder: NewMiddlewareBuilder(NewLRUCache(10)),
+			vary:    "Accept-Language",
+			wantCnt: 4,
+		},
+		{
+			name:    "in key",
+			builder: NewMiddlewareBuilder(NewLRUCache(10)).Header("Accept-Language"),
+			vary:    "accept-language",
+			wantCnt: 2,
+		},
+		{
+			name:    "star",
+			builder: NewMiddlewareBuilder(NewLRUCache(10)).Header("Accept-Language"),
+			vary:    "*",
+			wantCnt: 4,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cnt := 0
+			server := web.NewHttpServer(web.ServerWithMiddleware(
+				func(next web.HandleFunc) web.HandleFunc {
+					return func(ctx *web.Context) {
+						// 外面的 middleware 加的 Vary 不影响
+						ctx.Resp.Header().Add("Vary", "Accept-Encoding")
+						next(ctx)
+					}
+				},
+				tc.builder.Build()))
+			server.Get("/user", func(ctx *web.Context) {
+				cnt++
+				ctx.Resp.Header().Add("Vary", tc.vary)
+				ctx.RespData = []byte(ctx.Req.Header.Get("Accept-Language"))
+			})
+			for _, lang := range []string{"zh-CN", "en-US", "zh-CN", "en-US"} {
+				req := httptest.NewRequest(http.MethodGet, "/user", nil)
+				req.Header.Set("Accept-Language", lang)
+				resp := serve(server, req)
+				assert.Equal(t, lang, resp.Body.String())
+			}
+			assert.Equal(t, tc.wantCnt, cnt)
+		})
+	}
+}
+
+func TestMiddlewareBuilder_PerRequest(t *testing.T) {
+	cnt := 0
+	server := web.NewHttpServer(web.ServerWithMiddleware(
+		func(next web.HandleFunc) web.HandleFunc {
+			return func(ctx *web.Context) {
+				next(ctx)
+				// 外面的 middleware 直接修改 RespData 不能影响缓存
+				ctx.RespData[0] = 'x'
+			}
+		},
+		NewMiddlewareBuilder(NewLRUCache(10)).Build()))
+	server.Get("/user", func(ctx *web.Context) {
+		cnt++
+		ctx.Resp.Header().Set("Content-Security-Policy", "script-src 'nonce-"+strconv.Itoa(cnt)+"'")
+		ctx.Resp.Header().Set("Content-Type", "text/plain")
+		ctx.RespData = []byte("hello")
+	})
+	resp := serve(server, httptest.NewRequest(http.MethodGet, "/user", nil))
+	assert.Equal(t, "xello", resp.Body.String())
+	for i := 0; i < 2; i++ {
+		resp = serve(server, httptest.NewRequest(http.MethodGet, "/user", nil))
+		assert.Equal(t, "HIT", resp.Header().Get("X-Cache"))
+		assert.Equal(t, "xello", resp.Body.String())
+		assert.Equal(t, "text/plain", resp.Header().Get("Content-Type"))
+		assert.Empty(t, resp.Header().Get("Content-Security-Policy"))
+	}
+	assert.Equal(t, 1, cnt)
+}
+
+func TestMiddlewareBuilder_TTL(t *testing.T) {
+	cache := NewLRUCache(10)
+	now := time.Now()
+	cache.now = func() time.Time {
+		return now
+	}
+	cnt := 0
+	server := web.NewHttpServer(web.ServerWithMiddleware(NewMiddlewareBuilder(cache).
+		TTL(0).
+		RouteTTL("/reports/*", time.Hour).
+		RouteTTL("/short", time.Second).
+		Build()))
+	handler := func(ctx *web.Context) {
+		cnt++
+		ctx.RespData = []byte(strconv.Itoa(cnt))
+	}
+	server.Get("/reports/daily", handler)
+	server.Get("/short", handler)
+	server.Get("/max-age", func(ctx *web.Context) {
+		ctx.Resp.Header().Set("Cache-Control", "max-age=60, s-maxage=600")
+		handler(ctx)
+	})
+	server.Get("/none", handler)
+
+	get := func(path string) string {
+		return serve(server, httptest.NewRequest(http.MethodGet, path, nil)).Body.String()
+	}
+
+	assert.Equal(t, "1", get("/reports/daily"))
+	assert.Equal(t, "2", get("/short"))
+	// 默认 TTL 是 0，没有设置的路由不缓存
+	assert.Equal(t, "3", get("/none"))
+	assert.Equal(t, "4", get("/none"))
+
+	now = now.Add(2 * time.Second)
+	assert.Equal(t, "1", get("/reports/daily"))
+	assert.Equal(t, "5", get("/short"))
+
+	// 默认 TTL 是 0 的时候，Cache-Control 也不会让路由开启缓存
+	assert.Equal(t, "6", get("/max-age"))
+	assert.Equal(t, "7", get("/max-age"))
+}
+
+func TestMiddlewareBuilder_MaxAge(t *testing.T) {
+	cache := NewLRUCache(10)
+	now := time.Now()
+	cache.now = func() time.Time {
+		return now
+	}
+	cnt := 0
+	server := web.NewHttpServer(web.ServerWithMiddleware(NewMiddlewareBuilder(cache).TTL(time.Second).Build()))
+	server.Get("/shared", func(ctx *web.Context) {
+		cnt++
+		// 共享缓存使用 s-maxage
+		ctx.Resp.Header().Set("Cache-Control", "max-age=60, s-maxage=600")
+		ctx.RespData = []byte(strconv.Itoa(cnt))
+	})
+	get := func() string {
+		return serve(server, httptest.NewRequest(http.MethodGet, "/shared", nil)).Body.String()
+	}
+	assert.Equal(t, "1", get())
+	now = now.Add(5 * time.Minute)
+	assert.Equal(t, "1", get())
+	now = now.Add(6 * time.Minute)
+	assert.Equal(t, "2", get())
+}
+
+func TestMiddlewareBuilder_SingleFlight(t *testing.T) {
+	var cnt atomic.Int64
+	start := make(chan struct{})
+	server := web.NewHttpServer(web.ServerWithMiddleware(NewMiddlewareBuilder(NewLRUCache(10)).Build()))
+	server.Get("/slow", func(ctx *web.Context) {
+		cnt.Add(1)
+		<-start
+		ctx.RespData = []byte("slow")
+	})
+
+	const n = 10
+	var wg sync.WaitGroup
+	results := make([]string, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = serve(server, httptest.NewRequest(http.MethodGet, "/slow", nil)).Body.String()
+		}(i)
+	}
+	// 等所有请求都在等待第一个请求的结果
+	time.Sleep(100 * time.Millisecond)
+	close(start)
+	wg.Wait()
+	assert.Equal(t, int64(1), cnt.Load())
+	for _, res := range results {
+		assert.Equal(t, "slow", res)
+	}
+}
+
+func TestMiddlewareBuilder_SingleFlightNotCacheable(t *testing.T) {
+	var cnt atomic.Int64
+	start := make(chan struct{})
+	server := web.NewHttpServer(web.ServerWithMiddleware(NewMiddlewareBuilder(NewLRUCache(10)).Build()))
+	server.Get("/private", func(ctx *web.Context) {
+		n := cnt.Add(1)
+		if n == 1 {
+			<-start
+		}
+		ctx.Resp.Header().Set("Cache-Control", "private")
+		ctx.RespData = []byte("private")
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp := serve(server, httptest.NewRequest(http.MethodGet, "/private", nil))
+			assert.Equal(t, "private", resp.Body.String())
+		}()
+	}
+	time.Sleep(100 * time.Millisecond)
+	close(start)
+	wg.Wait()
+	// 第一个请求的结果不能共享，等待的请求只能自己执行
+	assert.Equal(t, int64(5), cnt.Load())
+}
+
+func TestMiddlewareBuilder_SingleFlightPanic(t *testing.T) {
+	server := web.NewHttpServer(web.ServerWithMiddleware(NewMiddlewareBuilder(NewLRUCache(10)).Build()))
+	first := true
+	server.Get("/panic", func(ctx *web.Context) {
+		if first {
+			first = false
+			panic("boom")
+		}
+		ctx.RespData = []byte("ok")
+	})
+	assert.Panics(t, func() {
+		serve(server, httptest.NewRequest(http.MethodGet, "/panic", nil))
+	})
+	// panic 之后不会一直卡在等待上
+	resp := serve(server, httptest.NewRequest(http.MethodGet, "/panic", nil))
+	assert.Equal(t, "ok", resp.Body.String())
+}
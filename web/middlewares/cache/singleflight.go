@@ -0,0 +1,39 @@
+package cache
+
+import "sync"
+
+// group 同一个 key 同时只有一个请求会执行业务代码，其余的等待它的结果
+// 避免缓存失效的时候大量请求同时打到后端
+type group struct {
+	mutex sync.Mutex
+	calls map[string]*call
+}
+
+type call struct {
+	done chan struct{}
+	// entry 为 nil 代表结果不能共享，例如不能缓存的响应
+	entry *Entry
+}
+
+// join 第二个返回值为 true 代表当前请求负责执行，执行完之后要调用 finish
+func (g *group) join(key string) (*call, bool) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if c, ok := g.calls[key]; ok {
+		return c, false
+	}
+	if g.calls == nil {
+		g.calls = map[string]*call{}
+	}
+	c := &call{done: make(chan struct{})}
+	g.calls[key] = c
+	return c, true
+}
+
+func (g *group) finish(key string, c *call, entry *Entry) {
+	g.mutex.Lock()
+	delete(g.calls, key)
+	g.mutex.Unlock()
+	c.entry = entry
+	close(c.done)
+}
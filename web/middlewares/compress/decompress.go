@@ -0,0 +1,190 @@
+package compress
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"web"
+)
+
+// Decoder 解压请求体的算法
+type Decoder interface {
+	// Encoding Content-Encoding 的值，例如 gzip
+	Encoding() string
+	// NewReader 返回解压 r 的 reader，格式不对的时候返回 error
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+type gzipDecoder struct{}
+
+// NewGzipDecoder 解压 gzip 格式的请求体
+func NewGzipDecoder() Decoder {
+	return gzipDecoder{}
+}
+
+func (gzipDecoder) Encoding() string {
+	return "gzip"
+}
+
+func (gzipDecoder) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+type deflateDecoder struct{}
+
+// NewDeflateDecoder 解压 deflate 格式的请求体
+// 标准的是 zlib 格式，但是有不少客户端发送的是裸的 deflate 数据，所以两种都支持
+func NewDeflateDecoder() Decoder {
+	return deflateDecoder{}
+}
+
+func (deflateDecoder) Encoding() string {
+	return "deflate"
+}
+
+func (deflateDecoder) NewReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	header, _ := br.Peek(2)
+	if isZlibHeader(header) {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
+
+// isZlibHeader RFC 1950，压缩方法是 8，并且前两个字节是 31 的倍数
+func isZlibHeader(header []byte) bool {
+	if len(header) < 2 {
+		return false
+	}
+	return header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0
+}
+
+type DecompressBuilder struct {
+	decoders map[string]Decoder
+	maxSize  int64
+}
+
+// NewDecompressBuilder 默认支持 gzip 和 deflate，解压之后最多 10MB
+func NewDecompressBuilder() *DecompressBuilder {
+	return &DecompressBuilder{
+		decoders: map[string]Decoder{
+			"gzip":    NewGzipDecoder(),
+			"deflate": NewDeflateDecoder(),
+		},
+		maxSize: 10 << 20,
+	}
+}
+
+// Decoder 注册解压算法，Encoding 相同的时候会替换已有的
+func (m *DecompressBuilder) Decoder(dec Decoder) *DecompressBuilder {
+	m.decoders[dec.Encoding()] = dec
+	return m
+}
+
+// MaxSize 解压之后的请求体的最大字节数，防止压缩炸弹，小于等于 0 不限制
+// 超过的时候 BindJSON 之类的方法会返回 *http.MaxBytesError，也就是 413
+func (m *DecompressBuilder) MaxSize(n int64) *DecompressBuilder {
+	m.maxSize = n
+	return m
+}
+
+func (m *DecompressBuilder) Build() web.Middleware {
+	return func(next web.HandleFunc) web.HandleFunc {
+		return func(ctx *web.Context) {
+			req := ctx.Req
+			encodings := parseContentEncoding(req.Header.Values("Content-Encoding"))
+			if len(encodings) == 0 || req.Body == nil || req.Body == http.NoBody {
+				next(ctx)
+				return
+			}
+
+			decoders := make([]Decoder, 0, len(encodings))
+			for _, encoding := range encodings {
+				dec, ok := m.decoders[encoding]
+				if !ok {
+					m.unsupported(ctx, encoding)
+					return
+				}
+				decoders = append(decoders, dec)
+			}
+
+			body := &decodedBody{closers: []io.Closer{req.Body}}
+			var r io.Reader = req.Body
+			// 多个编码是按照顺序压缩的，解压的时候要反过来
+			for i := len(decoders) - 1; i >= 0; i-- {
+				rc, err := decoders[i].NewReader(r)
+				if err != nil {
+					_ = body.Close()
+					p := web.NewProblem(http.StatusBadRequest)
+					p.Detail = fmt.Sprintf("请求体不是合法的 %s 格式", decoders[i].Encoding())
+					ctx.RespStatusCode = http.StatusBadRequest
+					ctx.Err = p
+					return
+				}
+				body.closers = append(body.closers, rc)
+				r = rc
+			}
+			body.Reader = r
+			req.Body = body
+			if m.maxSize > 0 {
+				req.Body = http.MaxBytesReader(ctx.Resp, body, m.maxSize)
+			}
+			// 后面看到的是解压之后的请求体，长度也不知道了
+			req.Header.Del("Content-Encoding")
+			req.Header.Del("Content-Length")
+			req.ContentLength = -1
+			next(ctx)
+		}
+	}
+}
+
+// unsupported 返回 415，并且通过 Accept-Encoding 告诉客户端支持哪些编码，参考 RFC 7694
+func (m *DecompressBuilder) unsupported(ctx *web.Context, encoding string) {
+	supported := make([]string, 0, len(m.decoders))
+	for name := range m.decoders {
+		supported = append(supported, name)
+	}
+	sort.Strings(supported)
+	ctx.Resp.Header().Set("Accept-Encoding", strings.Join(supported, ", "))
+	p := web.NewProblem(http.StatusUnsupportedMediaType)
+	p.Detail = fmt.Sprintf("不支持的 Content-Encoding: %s", encoding)
+	ctx.RespStatusCode = http.StatusUnsupportedMediaType
+	ctx.Err = p
+}
+
+// parseContentEncoding 解析 Content-Encoding，忽略 identity
+func parseContentEncoding(values []string) []string {
+	var res []string
+	for _, val := range values {
+		for _, encoding := range strings.Split(val, ",") {
+			encoding = strings.ToLower(strings.TrimSpace(encoding))
+			if encoding == "" || encoding == "identity" {
+				continue
+			}
+			res = append(res, encoding)
+		}
+	}
+	return res
+}
+
+// decodedBody 关闭的时候把解压的 reader 和原本的请求体都关掉
+type decodedBody struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (b *decodedBody) Close() error {
+	var err error
+	for i := len(b.closers) - 1; i >= 0; i-- {
+		if cerr := b.closers[i].Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
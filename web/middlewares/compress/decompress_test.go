@@ -0,0 +1,161 @@
+package compress
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"web"
+)
+
+func compressed(t *testing.T, newWriter func(w io.Writer) io.WriteCloser, data string) []byte {
+	buf := &bytes.Buffer{}
+	w := newWriter(buf)
+	_, err := w.Write([]byte(data))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func gzipped(t *testing.T, data string) []byte {
+	return compressed(t, func(w io.Writer) io.WriteCloser {
+		return gzip.NewWriter(w)
+	}, data)
+}
+
+func TestDecompressBuilder_Build(t *testing.T) {
+	type User struct {
+		Name string `json:"name"`
+	}
+	body := `{"name":"Tom"}`
+	server := web.NewHttpServer(
+		web.ServerWithProblemDetails(),
+		web.ServerWithMiddleware(NewDecompressBuilder().MaxSize(1024).Build()))
+	server.Post("/user", func(ctx *web.Context) {
+		var u User
+		if err := ctx.BindJSON(&u); err != nil {
+			ctx.RespStatusCode = http.StatusBadRequest
+			ctx.Err = err
+			return
+		}
+		ctx.Resp.Header().Set("X-Content-Encoding", ctx.Req.Header.Get("Content-Encoding"))
+		ctx.Resp.Header().Set("X-Content-Length", strconv.FormatInt(ctx.Req.ContentLength, 10))
+		ctx.RespData = []byte(u.Name)
+	})
+
+	testCases := []struct {
+		name       string
+		encoding   string
+		body       []byte
+		wantStatus int
+		wantBody   string
+		wantAccept string
+	}{
+		{
+			name:       "plain",
+			body:       []byte(body),
+			wantStatus: http.StatusOK,
+			wantBody:   "Tom",
+		},
+		{
+			name:       "identity",
+			encoding:   "identity",
+			body:       []byte(body),
+			wantStatus: http.StatusOK,
+			wantBody:   "Tom",
+		},
+		{
+			name:       "gzip",
+			encoding:   "GZIP",
+			body:       gzipped(t, body),
+			wantStatus: http.StatusOK,
+			wantBody:   "Tom",
+		},
+		{
+			name:     "zlib deflate",
+			encoding: "deflate",
+			body: compressed(t, func(w io.Writer) io.WriteCloser {
+				return zlib.NewWriter(w)
+			}, body),
+			wantStatus: http.StatusOK,
+			wantBody:   "Tom",
+		},
+		{
+			name:     "raw deflate",
+			encoding: "deflate",
+			body: compressed(t, func(w io.Writer) io.WriteCloser {
+				fw, _ := flate.NewWriter(w, flate.DefaultCompression)
+				return fw
+			}, body),
+			wantStatus: http.StatusOK,
+			wantBody:   "Tom",
+		},
+		{
+			// 先 deflate 再 gzip，解压的时候要反过来
+			name:     "multiple",
+			encoding: "deflate, gzip",
+			body: gzipped(t, string(compressed(t, func(w io.Writer) io.WriteCloser {
+				return zlib.NewWriter(w)
+			}, body))),
+			wantStatus: http.StatusOK,
+			wantBody:   "Tom",
+		},
+		{
+			name:       "unsupported",
+			encoding:   "br",
+			body:       []byte(body),
+			wantStatus: http.StatusUnsupportedMediaType,
+			wantAccept: "deflate, gzip",
+		},
+		{
+			name:       "invalid gzip",
+			encoding:   "gzip",
+			body:       []byte(body),
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			// 压缩之后很小，解压之后超过了限制
+			name:       "zip bomb",
+			encoding:   "gzip",
+			body:       gzipped(t, `{"name":"`+strings.Repeat("a", 4096)+`"}`),
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/user", bytes.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			if tc.encoding != "" {
+				req.Header.Set("Content-Encoding", tc.encoding)
+			}
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, req)
+			assert.Equal(t, tc.wantStatus, resp.Code)
+			assert.Equal(t, tc.wantAccept, resp.Header().Get("Accept-Encoding"))
+			if tc.wantStatus == http.StatusOK {
+				assert.Equal(t, tc.wantBody, resp.Body.String())
+				if tc.encoding != "" && tc.encoding != "identity" {
+					// 业务看到的是解压之后的请求体
+					assert.Empty(t, resp.Header().Get("X-Content-Encoding"))
+					assert.Equal(t, "-1", resp.Header().Get("X-Content-Length"))
+				}
+				return
+			}
+			assert.Equal(t, web.ProblemContentType, resp.Header().Get("Content-Type"))
+		})
+	}
+}
+
+func TestIsZlibHeader(t *testing.T) {
+	assert.True(t, isZlibHeader([]byte{0x78, 0x9c}))
+	assert.True(t, isZlibHeader([]byte{0x78, 0x01}))
+	assert.False(t, isZlibHeader([]byte{0x78}))
+	assert.False(t, isZlibHeader([]byte{0x1f, 0x8b}))
+}
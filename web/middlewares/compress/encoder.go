@@ -0,0 +1,151 @@
+package compress
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Encoder 压缩算法，例如可以基于 github.com/andybalholm/brotli 实现 br
+type Encoder interface {
+	// Encoding Content-Encoding 的值，例如 gzip
+	Encoding() string
+	// NewWriter 返回写入 w 的压缩 writer，用完之后会调用 Close
+	// 实现可以在 Close 的时候把 writer 放回池子里面复用
+	NewWriter(w io.Writer) io.WriteCloser
+}
+
+// Flusher 支持 Flush 的压缩 writer，流式响应的时候会用到
+type Flusher interface {
+	Flush() error
+}
+
+type gzipEncoder struct {
+	pool sync.Pool
+}
+
+// NewGzipEncoder level 是 gzip 的压缩级别，例如 gzip.DefaultCompression
+func NewGzipEncoder(level int) Encoder {
+	// 提前校验，避免在 pool 里面 panic
+	if _, err := gzip.NewWriterLevel(io.Discard, level); err != nil {
+		panic(err)
+	}
+	enc := &gzipEncoder{}
+	enc.pool.New = func() any {
+		w, _ := gzip.NewWriterLevel(io.Discard, level)
+		return w
+	}
+	return enc
+}
+
+func (e *gzipEncoder) Encoding() string {
+	return "gzip"
+}
+
+func (e *gzipEncoder) NewWriter(w io.Writer) io.WriteCloser {
+	gw := e.pool.Get().(*gzip.Writer)
+	gw.Reset(w)
+	return &pooledWriter[*gzip.Writer]{w: gw, pool: &e.pool}
+}
+
+type deflateEncoder struct {
+	pool sync.Pool
+}
+
+// NewDeflateEncoder level 是压缩级别，例如 zlib.DefaultCompression
+// HTTP 里面的 deflate 指的是 zlib 格式，而不是裸的 deflate 数据
+func NewDeflateEncoder(level int) Encoder {
+	if _, err := zlib.NewWriterLevel(io.Discard, level); err != nil {
+		panic(err)
+	}
+	enc := &deflateEncoder{}
+	enc.pool.New = func() any {
+		w, _ := zlib.NewWriterLevel(io.Discard, level)
+		return w
+	}
+	return enc
+}
+
+func (e *deflateEncoder) Encoding() string {
+	return "deflate"
+}
+
+func (e *deflateEncoder) NewWriter(w io.Writer) io.WriteCloser {
+	zw := e.pool.Get().(*zlib.Writer)
+	zw.Reset(w)
+	return &pooledWriter[*zlib.Writer]{w: zw, pool: &e.pool}
+}
+
+type resetWriter interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// pooledWriter Close 之后放回池子
+type pooledWriter[T resetWriter] struct {
+	w    T
+	pool *sync.Pool
+}
+
+func (p *pooledWriter[T]) Write(data []byte) (int, error) {
+	return p.w.Write(data)
+}
+
+func (p *pooledWriter[T]) Flush() error {
+	return p.w.Flush()
+}
+
+func (p *pooledWriter[T]) Close() error {
+	err := p.w.Close()
+	p.pool.Put(p.w)
+	return err
+}
+
+// negotiate 根据 Accept-Encoding 选择压缩算法
+// q 值高的优先，q 值一样的时候按照 encoders 的顺序，也就是服务端的偏好
+func negotiate(acceptEncoding string, encoders []Encoder) Encoder {
+	if acceptEncoding == "" {
+		return nil
+	}
+	qs := map[string]float64{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		if key, val, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(key) == "q" {
+			if f, err := strconv.ParseFloat(strings.TrimSpace(val), 64); err == nil {
+				q = f
+			}
+		}
+		qs[name] = q
+	}
+
+	type candidate struct {
+		enc Encoder
+		q   float64
+	}
+	candidates := make([]candidate, 0, len(encoders))
+	for _, enc := range encoders {
+		q, ok := qs[enc.Encoding()]
+		if !ok {
+			q, ok = qs["*"]
+		}
+		if ok && q > 0 {
+			candidates = append(candidates, candidate{enc: enc, q: q})
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].q > candidates[j].q
+	})
+	return candidates[0].enc
+}
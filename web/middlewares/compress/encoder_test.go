@@ -0,0 +1,97 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	encoders := []Encoder{
+		NewGzipEncoder(gzip.DefaultCompression),
+		NewDeflateEncoder(zlib.DefaultCompression),
+	}
+	testCases := []struct {
+		name   string
+		accept string
+		want   string
+	}{
+		{name: "empty"},
+		{name: "gzip", accept: "gzip", want: "gzip"},
+		{name: "deflate", accept: "deflate", want: "deflate"},
+		// q 值一样的时候按照服务端的偏好
+		{name: "server preference", accept: "deflate, gzip", want: "gzip"},
+		{name: "q value", accept: "gzip;q=0.5, deflate", want: "deflate"},
+		{name: "q with spaces", accept: "gzip ; q=0.2, deflate; q=0.8", want: "deflate"},
+		{name: "case insensitive", accept: "GZIP", want: "gzip"},
+		{name: "refused", accept: "gzip;q=0", want: ""},
+		{name: "wildcard", accept: "*", want: "gzip"},
+		{name: "wildcard exclude", accept: "*, gzip;q=0", want: "deflate"},
+		{name: "unsupported", accept: "br, identity", want: ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			enc := negotiate(tc.accept, encoders)
+			if tc.want == "" {
+				assert.Nil(t, enc)
+				return
+			}
+			require.NotNil(t, enc)
+			assert.Equal(t, tc.want, enc.Encoding())
+		})
+	}
+}
+
+func TestEncoder_Pooled(t *testing.T) {
+	testCases := []struct {
+		name   string
+		enc    Encoder
+		reader func(r io.Reader) (io.Reader, error)
+	}{
+		{
+			name: "gzip",
+			enc:  NewGzipEncoder(gzip.BestSpeed),
+			reader: func(r io.Reader) (io.Reader, error) {
+				return gzip.NewReader(r)
+			},
+		},
+		{
+			name: "deflate",
+			enc:  NewDeflateEncoder(zlib.BestSpeed),
+			reader: func(r io.Reader) (io.Reader, error) {
+				return zlib.NewReader(r)
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// 放回池子之后再拿出来用，不能带上一次的数据
+			for _, msg := range []string{"hello, world", "second"} {
+				buf := &bytes.Buffer{}
+				w := tc.enc.NewWriter(buf)
+				_, err := w.Write([]byte(msg))
+				require.NoError(t, err)
+				require.NoError(t, w.Close())
+
+				r, err := tc.reader(buf)
+				require.NoError(t, err)
+				data, err := io.ReadAll(r)
+				require.NoError(t, err)
+				assert.Equal(t, msg, string(data))
+			}
+		})
+	}
+}
+
+func TestNewGzipEncoder_InvalidLevel(t *testing.T) {
+	assert.Panics(t, func() {
+		NewGzipEncoder(100)
+	})
+	assert.Panics(t, func() {
+		NewDeflateEncoder(100)
+	})
+}
@@ -0,0 +1,180 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"mime"
+	"net/http"
+	"strings"
+	"web"
+)
+
+type MiddlewareBuilder struct {
+	// encoders 按照服务端的偏好排序
+	encoders     []Encoder
+	minSize      int
+	contentTypes []string
+}
+
+// NewMiddlewareBuilder 默认支持 gzip 和 deflate，大于 1KB 的文本、JSON 之类的响应才会压缩
+func NewMiddlewareBuilder() *MiddlewareBuilder {
+	return &MiddlewareBuilder{
+		encoders: []Encoder{
+			NewGzipEncoder(gzip.DefaultCompression),
+			NewDeflateEncoder(zlib.DefaultCompression),
+		},
+		minSize: 1024,
+		contentTypes: []string{
+			"text/*",
+			"application/json",
+			"application/javascript",
+			"application/xml",
+			"image/svg+xml",
+		},
+	}
+}
+
+// Encoder 注册压缩算法，优先级比已有的都高
+// 和已有的 Encoding 相同的时候会替换掉已有的，例如替换 gzip 的压缩级别
+func (m *MiddlewareBuilder) Encoder(enc Encoder) *MiddlewareBuilder {
+	encoders := make([]Encoder, 0, len(m.encoders)+1)
+	encoders = append(encoders, enc)
+	for _, e := range m.encoders {
+		if e.Encoding() != enc.Encoding() {
+			encoders = append(encoders, e)
+		}
+	}
+	m.encoders = encoders
+	return m
+}
+
+// MinSize 小于这个大小的响应不压缩，压缩的收益抵不上开销
+func (m *MiddlewareBuilder) MinSize(n int) *MiddlewareBuilder {
+	m.minSize = n
+	return m
+}
+
+// ContentTypes 需要压缩的 Content-Type，会覆盖默认值，text/* 代表前缀匹配
+// 以 +json 和 +xml 结尾的类型总是会被压缩，例如 application/problem+json
+func (m *MiddlewareBuilder) ContentTypes(types ...string) *MiddlewareBuilder {
+	m.contentTypes = types
+	return m
+}
+
+func (m *MiddlewareBuilder) Build() web.Middleware {
+	return func(next web.HandleFunc) web.HandleFunc {
+		return func(ctx *web.Context) {
+			// 不管最终有没有压缩，响应的内容都取决于 Accept-Encoding
+			ctx.Resp.Header().Add("Vary", "Accept-Encoding")
+			enc := negotiate(ctx.Req.Header.Get("Accept-Encoding"), m.encoders)
+			if enc == nil || ctx.Req.Method == http.MethodHead {
+				next(ctx)
+				return
+			}
+
+			resp := ctx.Resp
+			cw := &compressWriter{ResponseWriter: resp, builder: m, enc: enc}
+			ctx.Resp = cw
+			defer func() {
+				// 已经写入了响应头的时候保留 cw，flashResp 再次调用的 WriteHeader 会被忽略
+				if !cw.wroteHeader {
+					ctx.Resp = resp
+				}
+			}()
+			next(ctx)
+
+			switch cw.state {
+			case stateBuffering:
+				// 业务没有直接写入，或者写入的数据不多，和 RespData 合在一起处理
+				if cw.status != 0 && ctx.RespStatusCode == 0 {
+					ctx.RespStatusCode = cw.status
+				}
+				body := ctx.RespData
+				if cw.buf.Len() > 0 {
+					body = append(cw.buf.Bytes(), ctx.RespData...)
+				}
+				ctx.RespData = m.compressBody(ctx, enc, body)
+			case stateCompressing:
+				// 已经开始流式压缩了，RespData 也要经过压缩
+				if len(ctx.RespData) > 0 {
+					_, _ = cw.writer.Write(ctx.RespData)
+					ctx.RespData = nil
+				}
+				if err := cw.writer.Close(); err != nil {
+					ctx.Logger().Error("compress: 压缩响应失败", "err", err)
+				}
+				// 压缩已经结束了，不能再写入 encoder
+				cw.state = statePassthrough
+			}
+		}
+	}
+}
+
+// compressBody 压缩完整的响应体，不满足条件的时候原样返回
+func (m *MiddlewareBuilder) compressBody(ctx *web.Context, enc Encoder, body []byte) []byte {
+	header := ctx.Resp.Header()
+	if len(body) < m.minSize || !m.shouldCompress(header, ctx.RespStatusCode, body) {
+		return body
+	}
+	buf := &bytes.Buffer{}
+	w := enc.NewWriter(buf)
+	_, err := w.Write(body)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		ctx.Logger().Error("compress: 压缩响应失败", "err", err)
+		return body
+	}
+	setEncodingHeader(header, enc)
+	return buf.Bytes()
+}
+
+func (m *MiddlewareBuilder) shouldCompress(header http.Header, status int, body []byte) bool {
+	if status == http.StatusNoContent || status == http.StatusNotModified ||
+		(status >= 100 && status < 200) {
+		return false
+	}
+	// 业务自己压缩过了
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(body)
+	}
+	return m.compressible(contentType)
+}
+
+func (m *MiddlewareBuilder) compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml") {
+		return true
+	}
+	for _, t := range m.contentTypes {
+		if prefix, ok := strings.CutSuffix(t, "*"); ok {
+			if strings.HasPrefix(mediaType, prefix) {
+				return true
+			}
+			continue
+		}
+		if mediaType == t {
+			return true
+		}
+	}
+	return false
+}
+
+func setEncodingHeader(header http.Header, enc Encoder) {
+	header.Set("Content-Encoding", enc.Encoding())
+	// 压缩之后长度变了
+	header.Del("Content-Length")
+	// 压缩之后的内容和原来的字节不一样了，强 ETag 要变成弱 ETag
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		header.Set("ETag", "W/"+etag)
+	}
+}
@@ -0,0 +1,264 @@
+package compress
+
+import (
+	"compress/gzip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"web"
+)
+
+var largeJSON = `{"data":"` + strings.Repeat("a", 2048) + `"}`
+
+func gunzip(t *testing.T, data []byte) string {
+	r, err := gzip.NewReader(strings.NewReader(string(data)))
+	require.NoError(t, err)
+	res, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return string(res)
+}
+
+func TestMiddlewareBuilder_RespData(t *testing.T) {
+	server := web.NewHttpServer(web.ServerWithMiddleware(NewMiddlewareBuilder().Build()))
+	server.Get("/json", func(ctx *web.Context) {
+		ctx.Resp.Header().Set("ETag", `"v1"`)
+		ctx.Resp.Header().Set("Content-Length", "2060")
+		_ = ctx.RespJSON(http.StatusCreated, map[string]string{"data": strings.Repeat("a", 2048)})
+	})
+	server.Get("/small", func(ctx *web.Context) {
+		_ = ctx.RespJSONOK(map[string]string{"data": "a"})
+	})
+	server.Get("/png", func(ctx *web.Context) {
+		ctx.Resp.Header().Set("Content-Type", "image/png")
+		ctx.RespData = []byte(strings.Repeat("a", 2048))
+	})
+	server.Get("/sniff", func(ctx *web.Context) {
+		ctx.RespData = []byte(strings.Repeat("a", 2048))
+	})
+	server.Get("/encoded", func(ctx *web.Context) {
+		ctx.Resp.Header().Set("Content-Type", "text/plain")
+		ctx.Resp.Header().Set("Content-Encoding", "br")
+		ctx.RespData = []byte(strings.Repeat("a", 2048))
+	})
+
+	testCases := []struct {
+		name         string
+		path         string
+		accept       string
+		wantEncoding string
+		wantStatus   int
+		wantBody     string
+	}{
+		{
+			name:         "compressed",
+			path:         "/json",
+			accept:       "gzip, deflate",
+			wantEncoding: "gzip",
+			wantStatus:   http.StatusCreated,
+			wantBody:     largeJSON,
+		},
+		{
+			name:       "no accept encoding",
+			path:       "/json",
+			wantStatus: http.StatusCreated,
+			wantBody:   largeJSON,
+		},
+		{
+			name:       "too small",
+			path:       "/small",
+			accept:     "gzip",
+			wantStatus: http.StatusOK,
+			wantBody:   `{"data":"a"}`,
+		},
+		{
+			name:       "not compressible",
+			path:       "/png",
+			accept:     "gzip",
+			wantStatus: http.StatusOK,
+			wantBody:   strings.Repeat("a", 2048),
+		},
+		{
+			name:         "sniff content type",
+			path:         "/sniff",
+			accept:       "gzip",
+			wantEncoding: "gzip",
+			wantStatus:   http.StatusOK,
+			wantBody:     strings.Repeat("a", 2048),
+		},
+		{
+			name:         "already encoded",
+			path:         "/encoded",
+			accept:       "gzip",
+			wantEncoding: "br",
+			wantStatus:   http.StatusOK,
+			wantBody:     strings.Repeat("a", 2048),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			if tc.accept != "" {
+				req.Header.Set("Accept-Encoding", tc.accept)
+			}
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, req)
+			assert.Equal(t, tc.wantStatus, resp.Code)
+			assert.Equal(t, "Accept-Encoding", resp.Header().Get("Vary"))
+			assert.Equal(t, tc.wantEncoding, resp.Header().Get("Content-Encoding"))
+			if tc.wantEncoding == "gzip" {
+				assert.Equal(t, tc.wantBody, gunzip(t, resp.Body.Bytes()))
+				return
+			}
+			assert.Equal(t, tc.wantBody, resp.Body.String())
+		})
+	}
+}
+
+func TestMiddlewareBuilder_Headers(t *testing.T) {
+	server := web.NewHttpServer(web.ServerWithMiddleware(NewMiddlewareBuilder().Build()))
+	server.Get("/json", func(ctx *web.Context) {
+		ctx.Resp.Header().Set("ETag", `"v1"`)
+		ctx.Resp.Header().Set("Content-Length", "2060")
+		_ = ctx.RespJSONOK(map[string]string{"data": strings.Repeat("a", 2048)})
+	})
+	req := httptest.NewRequest(http.MethodGet, "/json", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, req)
+	assert.Equal(t, "gzip", resp.Header().Get("Content-Encoding"))
+	// 压缩之后长度变了，强 ETag 也不再成立
+	assert.Empty(t, resp.Header().Get("Content-Length"))
+	assert.Equal(t, `W/"v1"`, resp.Header().Get("ETag"))
+	assert.Equal(t, largeJSON, gunzip(t, resp.Body.Bytes()))
+}
+
+func TestMiddlewareBuilder_Stream(t *testing.T) {
+	server := web.NewHttpServer(web.ServerWithMiddleware(NewMiddlewareBuilder().MinSize(16).Build()))
+	server.Get("/stream", func(ctx *web.Context) {
+		ctx.Resp.Header().Set("Content-Type", "text/plain")
+		ctx.Resp.WriteHeader(http.StatusAccepted)
+		for i := 0; i < 3; i++ {
+			_, _ = ctx.Resp.Write([]byte("chunk "))
+			// 不够 MinSize 也要开始压缩，否则客户端收不到数据
+			ctx.Resp.(http.Flusher).Flush()
+		}
+		// flashResp 不能再次写入响应头
+		ctx.RespStatusCode = http.StatusAccepted
+		ctx.RespData = []byte("done")
+	})
+	server.Get("/small", func(ctx *web.Context) {
+		ctx.Resp.Header().Set("Content-Type", "text/plain")
+		ctx.Resp.WriteHeader(http.StatusAccepted)
+		_, _ = ctx.Resp.Write([]byte("tiny"))
+	})
+	server.Get("/large", func(ctx *web.Context) {
+		for i := 0; i < 10; i++ {
+			_, _ = ctx.Resp.Write([]byte("<p>hello</p>"))
+		}
+	})
+
+	testCases := []struct {
+		name         string
+		path         string
+		wantEncoding string
+		wantStatus   int
+		wantBody     string
+	}{
+		{
+			name:         "flush",
+			path:         "/stream",
+			wantEncoding: "gzip",
+			wantStatus:   http.StatusAccepted,
+			wantBody:     "chunk chunk chunk done",
+		},
+		{
+			name:       "buffered small",
+			path:       "/small",
+			wantStatus: http.StatusAccepted,
+			wantBody:   "tiny",
+		},
+		{
+			name:         "exceed min size",
+			path:         "/large",
+			wantEncoding: "gzip",
+			wantStatus:   http.StatusOK,
+			wantBody:     strings.Repeat("<p>hello</p>", 10),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			resp := httptest.NewRecorder()
+			w := &headerCounter{ResponseWriter: resp}
+			server.ServeHTTP(w, req)
+			assert.LessOrEqual(t, w.cnt, 1)
+			assert.Equal(t, tc.wantStatus, resp.Code)
+			assert.Equal(t, tc.wantEncoding, resp.Header().Get("Content-Encoding"))
+			if tc.wantEncoding == "gzip" {
+				assert.Equal(t, tc.wantBody, gunzip(t, resp.Body.Bytes()))
+				return
+			}
+			assert.Equal(t, tc.wantBody, resp.Body.String())
+		})
+	}
+}
+
+// headerCounter 统计 WriteHeader 的次数，多次调用 net/http 会打印 superfluous WriteHeader
+type headerCounter struct {
+	http.ResponseWriter
+	cnt int
+}
+
+func (w *headerCounter) WriteHeader(status int) {
+	w.cnt++
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *headerCounter) Flush() {
+	w.ResponseWriter.(http.Flusher).Flush()
+}
+
+// upperEncoder 测试用的编码，把内容转成大写
+type upperEncoder struct{}
+
+func (upperEncoder) Encoding() string {
+	return "x-upper"
+}
+
+func (upperEncoder) NewWriter(w io.Writer) io.WriteCloser {
+	return upperWriter{w: w}
+}
+
+type upperWriter struct {
+	w io.Writer
+}
+
+func (u upperWriter) Write(data []byte) (int, error) {
+	return u.w.Write([]byte(strings.ToUpper(string(data))))
+}
+
+func (u upperWriter) Close() error {
+	return nil
+}
+
+func TestMiddlewareBuilder_Encoder(t *testing.T) {
+	builder := NewMiddlewareBuilder().MinSize(0).Encoder(upperEncoder{})
+	server := web.NewHttpServer(web.ServerWithMiddleware(builder.Build()))
+	server.Get("/hello", func(ctx *web.Context) {
+		ctx.Resp.Header().Set("Content-Type", "text/plain")
+		ctx.RespData = []byte("hello")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/hello", nil)
+	req.Header.Set("Accept-Encoding", "gzip, x-upper")
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, req)
+	// 后注册的优先级更高
+	assert.Equal(t, "x-upper", resp.Header().Get("Content-Encoding"))
+	assert.Equal(t, "HELLO", resp.Body.String())
+}
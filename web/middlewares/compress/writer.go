@@ -0,0 +1,112 @@
+package compress
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+)
+
+type writerState int
+
+const (
+	// stateBuffering 还没有决定要不要压缩
+	stateBuffering writerState = iota
+	// stateCompressing 已经开始压缩了
+	stateCompressing
+	// statePassthrough 不压缩，直接写入
+	statePassthrough
+)
+
+// compressWriter 处理业务直接写入 Resp 的数据
+// 先缓存到 minSize，再根据大小和 Content-Type 决定要不要压缩
+type compressWriter struct {
+	http.ResponseWriter
+	builder *MiddlewareBuilder
+	enc     Encoder
+	writer  io.WriteCloser
+
+	state  writerState
+	buf    bytes.Buffer
+	status int
+	// wroteHeader 开始写入之后响应头就已经发出去了
+	// 后面 flashResp 根据 RespStatusCode 再次调用 WriteHeader 的时候要忽略
+	wroteHeader bool
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *compressWriter) Write(data []byte) (int, error) {
+	switch w.state {
+	case stateCompressing:
+		return w.writer.Write(data)
+	case statePassthrough:
+		return w.ResponseWriter.Write(data)
+	}
+	w.buf.Write(data)
+	if w.buf.Len() < w.builder.minSize {
+		return len(data), nil
+	}
+	if err := w.start(); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// Flush 流式响应，即便数据不够 minSize 也要决定要不要压缩
+func (w *compressWriter) Flush() {
+	if w.state == stateBuffering {
+		if err := w.start(); err != nil {
+			return
+		}
+	}
+	if w.state == stateCompressing {
+		if f, ok := w.writer.(Flusher); ok {
+			_ = f.Flush()
+		}
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// start 决定要不要压缩，并且把缓存的数据写出去
+func (w *compressWriter) start() error {
+	header := w.Header()
+	if w.builder.shouldCompress(header, w.status, w.buf.Bytes()) {
+		if header.Get("Content-Type") == "" {
+			// 压缩之后就没办法再推断 Content-Type 了
+			header.Set("Content-Type", http.DetectContentType(w.buf.Bytes()))
+		}
+		setEncodingHeader(header, w.enc)
+		w.state = stateCompressing
+		w.writer = w.enc.NewWriter(w.ResponseWriter)
+	} else {
+		w.state = statePassthrough
+	}
+	w.wroteHeader = true
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	var err error
+	if w.state == stateCompressing {
+		_, err = w.writer.Write(w.buf.Bytes())
+	} else {
+		_, err = w.ResponseWriter.Write(w.buf.Bytes())
+	}
+	w.buf.Reset()
+	return err
+}
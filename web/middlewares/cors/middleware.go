@@ -0,0 +1,237 @@
+package cors
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"web"
+)
+
+type MiddlewareBuilder struct {
+	// allowAll 允许任意的 Origin
+	allowAll bool
+	origins  map[string]struct{}
+	// wildcards 子域名通配，例如 https://*.example.com
+	wildcards  []wildcard
+	regexps    []*regexp.Regexp
+	originFunc func(origin string) bool
+	methods    []string
+	headers    map[string]struct{}
+	// reflectHeaders 为 true 的时候允许客户端请求的任意头部
+	reflectHeaders bool
+	exposeHeaders  []string
+	credentials    bool
+	maxAge         time.Duration
+}
+
+type wildcard struct {
+	prefix string
+	suffix string
+}
+
+func (w wildcard) match(origin string) bool {
+	return len(origin) > len(w.prefix)+len(w.suffix) &&
+		strings.HasPrefix(origin, w.prefix) && strings.HasSuffix(origin, w.suffix)
+}
+
+// NewMiddlewareBuilder 默认不允许任何 Origin，需要通过 AllowOrigins 之类的方法设置
+func NewMiddlewareBuilder() *MiddlewareBuilder {
+	m := &MiddlewareBuilder{origins: map[string]struct{}{}}
+	m.AllowMethods(http.MethodGet, http.MethodHead, http.MethodPost,
+		http.MethodPut, http.MethodPatch, http.MethodDelete)
+	m.AllowHeaders("Accept", "Authorization", "Content-Type", "X-Requested-With", "X-Request-ID")
+	return m
+}
+
+// AllowOrigins 允许的 Origin，例如 https://example.com
+// * 代表任意 Origin，https://*.example.com 代表 example.com 的任意子域名
+func (m *MiddlewareBuilder) AllowOrigins(origins ...string) *MiddlewareBuilder {
+	for _, origin := range origins {
+		origin = strings.ToLower(origin)
+		if origin == "*" {
+			m.allowAll = true
+			continue
+		}
+		if prefix, suffix, ok := strings.Cut(origin, "*"); ok {
+			m.wildcards = append(m.wildcards, wildcard{prefix: prefix, suffix: suffix})
+			continue
+		}
+		m.origins[origin] = struct{}{}
+	}
+	return m
+}
+
+// AllowOriginRegexps 用正则表达式匹配 Origin，注意要加上 ^ 和 $
+func (m *MiddlewareBuilder) AllowOriginRegexps(exprs ...*regexp.Regexp) *MiddlewareBuilder {
+	m.regexps = append(m.regexps, exprs...)
+	return m
+}
+
+// AllowOriginFunc 自定义 Origin 的校验，例如从数据库里面读取租户的域名
+func (m *MiddlewareBuilder) AllowOriginFunc(fn func(origin string) bool) *MiddlewareBuilder {
+	m.originFunc = fn
+	return m
+}
+
+// AllowMethods 允许的跨域请求方法，会覆盖默认值
+func (m *MiddlewareBuilder) AllowMethods(methods ...string) *MiddlewareBuilder {
+	m.methods = make([]string, 0, len(methods))
+	for _, method := range methods {
+		m.methods = append(m.methods, strings.ToUpper(method))
+	}
+	return m
+}
+
+// AllowHeaders 允许的请求头，会覆盖默认值
+// * 代表允许客户端预检请求里面声明的任意头部
+func (m *MiddlewareBuilder) AllowHeaders(headers ...string) *MiddlewareBuilder {
+	m.headers = make(map[string]struct{}, len(headers))
+	m.reflectHeaders = false
+	for _, header := range headers {
+		if header == "*" {
+			m.reflectHeaders = true
+			continue
+		}
+		m.headers[http.CanonicalHeaderKey(header)] = struct{}{}
+	}
+	return m
+}
+
+// ExposeHeaders 允许浏览器里面的脚本读取的响应头
+func (m *MiddlewareBuilder) ExposeHeaders(headers ...string) *MiddlewareBuilder {
+	m.exposeHeaders = append(m.exposeHeaders, headers...)
+	return m
+}
+
+// AllowCredentials 允许携带 cookie 之类的凭证
+// 这个时候 Access-Control-Allow-Origin 不能是 *，所以会回写请求的 Origin
+func (m *MiddlewareBuilder) AllowCredentials() *MiddlewareBuilder {
+	m.credentials = true
+	return m
+}
+
+// MaxAge 预检请求的结果可以缓存多久
+func (m *MiddlewareBuilder) MaxAge(maxAge time.Duration) *MiddlewareBuilder {
+	m.maxAge = maxAge
+	return m
+}
+
+func (m *MiddlewareBuilder) Build() web.Middleware {
+	return func(next web.HandleFunc) web.HandleFunc {
+		return func(ctx *web.Context) {
+			header := ctx.Resp.Header()
+			// 响应的内容取决于 Origin 的时候，要告诉缓存
+			if !m.allowAll || m.credentials {
+				header.Add("Vary", "Origin")
+			}
+			origin := ctx.Req.Header.Get("Origin")
+			preflight := ctx.Req.Method == http.MethodOptions &&
+				ctx.Req.Header.Get("Access-Control-Request-Method") != ""
+			if preflight {
+				header.Add("Vary", "Access-Control-Request-Method")
+				header.Add("Vary", "Access-Control-Request-Headers")
+				// 预检请求直接在这里返回，不需要注册 OPTIONS 路由
+				// 不允许的时候也返回 204，只是不带 CORS 的头部，由浏览器来拦截
+				ctx.RespStatusCode = http.StatusNoContent
+				if origin != "" && m.allowOrigin(origin) {
+					m.preflight(ctx, origin)
+				}
+				return
+			}
+			if origin != "" && m.allowOrigin(origin) {
+				m.setOrigin(header, origin)
+				if len(m.exposeHeaders) > 0 {
+					header.Set("Access-Control-Expose-Headers", strings.Join(m.exposeHeaders, ", "))
+				}
+			}
+			next(ctx)
+		}
+	}
+}
+
+func (m *MiddlewareBuilder) preflight(ctx *web.Context, origin string) {
+	method := strings.ToUpper(ctx.Req.Header.Get("Access-Control-Request-Method"))
+	if !m.allowMethod(method) {
+		return
+	}
+	reqHeaders, ok := m.allowHeaders(ctx.Req.Header.Get("Access-Control-Request-Headers"))
+	if !ok {
+		return
+	}
+	header := ctx.Resp.Header()
+	m.setOrigin(header, origin)
+	header.Set("Access-Control-Allow-Methods", strings.Join(m.methods, ", "))
+	if len(reqHeaders) > 0 {
+		header.Set("Access-Control-Allow-Headers", strings.Join(reqHeaders, ", "))
+	}
+	if m.maxAge > 0 {
+		header.Set("Access-Control-Max-Age", strconv.Itoa(int(m.maxAge.Seconds())))
+	}
+}
+
+func (m *MiddlewareBuilder) setOrigin(header http.Header, origin string) {
+	if m.allowAll && !m.credentials {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+	}
+	if m.credentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func (m *MiddlewareBuilder) allowOrigin(origin string) bool {
+	if m.allowAll {
+		return true
+	}
+	lower := strings.ToLower(origin)
+	if _, ok := m.origins[lower]; ok {
+		return true
+	}
+	for _, w := range m.wildcards {
+		if w.match(lower) {
+			return true
+		}
+	}
+	for _, expr := range m.regexps {
+		if expr.MatchString(origin) {
+			return true
+		}
+	}
+	return m.originFunc != nil && m.originFunc(origin)
+}
+
+func (m *MiddlewareBuilder) allowMethod(method string) bool {
+	// 简单请求的方法总是允许的
+	if method == http.MethodGet || method == http.MethodHead || method == http.MethodPost {
+		return true
+	}
+	for _, allowed := range m.methods {
+		if allowed == method {
+			return true
+		}
+	}
+	return false
+}
+
+// allowHeaders 校验预检请求声明的头部，返回规范化之后的头部
+func (m *MiddlewareBuilder) allowHeaders(reqHeaders string) ([]string, bool) {
+	if reqHeaders == "" {
+		return nil, true
+	}
+	parts := strings.Split(reqHeaders, ",")
+	res := make([]string, 0, len(parts))
+	for _, part := range parts {
+		h := http.CanonicalHeaderKey(strings.TrimSpace(part))
+		if h == "" {
+			continue
+		}
+		if _, ok := m.headers[h]; !ok && !m.reflectHeaders {
+			return nil, false
+		}
+		res = append(res, h)
+	}
+	return res, true
+}
@@ -0,0 +1,203 @@
+package cors
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+	"web"
+)
+
+func TestMiddlewareBuilder_Origin(t *testing.T) {
+	builder := NewMiddlewareBuilder().
+		AllowOrigins("https://Example.com", "https://*.example.org").
+		AllowOriginRegexps(regexp.MustCompile(`^http://localhost:\d+$`)).
+		AllowOriginFunc(func(origin string) bool {
+			return origin == "https://tenant.com"
+		}).
+		ExposeHeaders("X-Total", "X-Request-ID")
+	server := web.NewHttpServer(web.ServerWithMiddleware(builder.Build()))
+	server.Get("/user", func(ctx *web.Context) {
+		ctx.RespData = []byte("hello")
+	})
+
+	testCases := []struct {
+		origin    string
+		wantAllow string
+	}{
+		{origin: "https://example.com", wantAllow: "https://example.com"},
+		{origin: "https://a.b.example.org", wantAllow: "https://a.b.example.org"},
+		// 通配符不包括自身
+		{origin: "https://example.org"},
+		{origin: "https://evil-example.org"},
+		{origin: "http://localhost:3000", wantAllow: "http://localhost:3000"},
+		{origin: "http://localhost:3000.evil.com"},
+		{origin: "https://tenant.com", wantAllow: "https://tenant.com"},
+		{origin: "https://evil.com"},
+		// 不是跨域请求
+		{origin: ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.origin, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/user", nil)
+			if tc.origin != "" {
+				req.Header.Set("Origin", tc.origin)
+			}
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, req)
+			// 不允许的 Origin 也要处理请求，由浏览器来拦截
+			assert.Equal(t, "hello", resp.Body.String())
+			assert.Equal(t, "Origin", resp.Header().Get("Vary"))
+			assert.Equal(t, tc.wantAllow, resp.Header().Get("Access-Control-Allow-Origin"))
+			if tc.wantAllow != "" {
+				assert.Equal(t, "X-Total, X-Request-ID", resp.Header().Get("Access-Control-Expose-Headers"))
+			} else {
+				assert.Empty(t, resp.Header().Get("Access-Control-Expose-Headers"))
+			}
+			assert.Empty(t, resp.Header().Get("Access-Control-Allow-Credentials"))
+		})
+	}
+}
+
+func TestMiddlewareBuilder_AllowAll(t *testing.T) {
+	testCases := []struct {
+		name            string
+		builder         *MiddlewareBuilder
+		wantAllow       string
+		wantVary        string
+		wantCredentials string
+	}{
+		{
+			name:      "any",
+			builder:   NewMiddlewareBuilder().AllowOrigins("*"),
+			wantAllow: "*",
+		},
+		{
+			// 携带凭证的时候不能是 *
+			name:            "credentials",
+			builder:         NewMiddlewareBuilder().AllowOrigins("*").AllowCredentials(),
+			wantAllow:       "https://example.com",
+			wantVary:        "Origin",
+			wantCredentials: "true",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := web.NewHttpServer(web.ServerWithMiddleware(tc.builder.Build()))
+			server.Get("/user", func(ctx *web.Context) {})
+			req := httptest.NewRequest(http.MethodGet, "/user", nil)
+			req.Header.Set("Origin", "https://example.com")
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, req)
+			assert.Equal(t, tc.wantAllow, resp.Header().Get("Access-Control-Allow-Origin"))
+			assert.Equal(t, tc.wantVary, resp.Header().Get("Vary"))
+			assert.Equal(t, tc.wantCredentials, resp.Header().Get("Access-Control-Allow-Credentials"))
+		})
+	}
+}
+
+func TestMiddlewareBuilder_Preflight(t *testing.T) {
+	testCases := []struct {
+		name       string
+		builder    *MiddlewareBuilder
+		origin     string
+		method     string
+		headers    string
+		wantHeader http.Header
+	}{
+		{
+			name:    "allowed",
+			builder: NewMiddlewareBuilder().AllowOrigins("https://example.com").MaxAge(10 * time.Minute),
+			origin:  "https://example.com",
+			method:  http.MethodDelete,
+			headers: "content-type, x-request-id",
+			wantHeader: http.Header{
+				"Access-Control-Allow-Origin":  {"https://example.com"},
+				"Access-Control-Allow-Methods": {"GET, HEAD, POST, PUT, PATCH, DELETE"},
+				"Access-Control-Allow-Headers": {"Content-Type, X-Request-Id"},
+				"Access-Control-Max-Age":       {"600"},
+				"Vary":                         {"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"},
+			},
+		},
+		{
+			name:    "origin not allowed",
+			builder: NewMiddlewareBuilder().AllowOrigins("https://example.com"),
+			origin:  "https://evil.com",
+			method:  http.MethodPut,
+			wantHeader: http.Header{
+				"Vary": {"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"},
+			},
+		},
+		{
+			name:    "method not allowed",
+			builder: NewMiddlewareBuilder().AllowOrigins("*").AllowMethods(http.MethodPut),
+			origin:  "https://example.com",
+			method:  http.MethodDelete,
+			wantHeader: http.Header{
+				"Vary": {"Access-Control-Request-Method", "Access-Control-Request-Headers"},
+			},
+		},
+		{
+			name:    "header not allowed",
+			builder: NewMiddlewareBuilder().AllowOrigins("*"),
+			origin:  "https://example.com",
+			method:  http.MethodPut,
+			headers: "X-Secret",
+			wantHeader: http.Header{
+				"Vary": {"Access-Control-Request-Method", "Access-Control-Request-Headers"},
+			},
+		},
+		{
+			name:    "reflect headers",
+			builder: NewMiddlewareBuilder().AllowOrigins("*").AllowHeaders("*").AllowCredentials(),
+			origin:  "https://example.com",
+			method:  http.MethodPost,
+			headers: "X-Secret",
+			wantHeader: http.Header{
+				"Access-Control-Allow-Origin":      {"https://example.com"},
+				"Access-Control-Allow-Credentials": {"true"},
+				"Access-Control-Allow-Methods":     {"GET, HEAD, POST, PUT, PATCH, DELETE"},
+				"Access-Control-Allow-Headers":     {"X-Secret"},
+				"Vary":                             {"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := web.NewHttpServer(web.ServerWithMiddleware(tc.builder.Build()))
+			var called bool
+			// 没有注册 OPTIONS 路由
+			server.Post("/user", func(ctx *web.Context) {
+				called = true
+			})
+			req := httptest.NewRequest(http.MethodOptions, "/user", nil)
+			req.Header.Set("Origin", tc.origin)
+			req.Header.Set("Access-Control-Request-Method", tc.method)
+			if tc.headers != "" {
+				req.Header.Set("Access-Control-Request-Headers", tc.headers)
+			}
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, req)
+			assert.False(t, called)
+			assert.Equal(t, http.StatusNoContent, resp.Code)
+			assert.Equal(t, tc.wantHeader, resp.Header())
+		})
+	}
+}
+
+func TestMiddlewareBuilder_Options(t *testing.T) {
+	// 不是预检请求的 OPTIONS 交给路由处理
+	server := web.NewHttpServer(web.ServerWithMethodNotAllowed(),
+		web.ServerWithMiddleware(NewMiddlewareBuilder().AllowOrigins("*").Build()))
+	server.Post("/user", func(ctx *web.Context) {})
+	req := httptest.NewRequest(http.MethodOptions, "/user", nil)
+	req.Header.Set("Origin", "https://example.com")
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.Code)
+	assert.True(t, strings.Contains(resp.Header().Get("Allow"), http.MethodPost))
+	assert.Equal(t, "*", resp.Header().Get("Access-Control-Allow-Origin"))
+}
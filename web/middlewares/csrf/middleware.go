@@ -0,0 +1,272 @@
+package csrf
+
+import (
+	"errors"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"web"
+)
+
+const (
+	// TokenKey 当前请求的 token 保存在 ctx.UserValues 里面的 key
+	TokenKey = "csrf_token"
+	// fieldKey 表单字段名保存在 ctx.UserValues 里面的 key
+	fieldKey = "csrf_field"
+)
+
+var (
+	ErrOriginMismatch = errors.New("csrf: Origin 或者 Referer 不匹配")
+	ErrTokenMissing   = errors.New("csrf: 缺少 token")
+	ErrTokenInvalid   = errors.New("csrf: token 不正确")
+)
+
+type MiddlewareBuilder struct {
+	// store 不为 nil 的时候使用同步器令牌模式，否则使用双重提交 cookie 模式
+	store  TokenStore
+	secret []byte
+	// sessionID 双重提交模式下把 token 和会话绑定
+	sessionID func(ctx *web.Context) string
+	cookie    http.Cookie
+	header    string
+	field     string
+	// trustedOrigins 除了自身之外允许的 Origin，例如 https://admin.example.com
+	trustedOrigins map[string]struct{}
+	exempts        []string
+	errorHandler   func(ctx *web.Context, err error)
+}
+
+// NewMiddlewareBuilder 默认使用双重提交 cookie 模式
+// 不需要服务端保存状态，建议通过 Secret 和 SessionID 给 cookie 加上和会话绑定的签名
+// 否则防不住攻击者通过子域名之类的办法种下 cookie
+func NewMiddlewareBuilder() *MiddlewareBuilder {
+	return &MiddlewareBuilder{
+		cookie: http.Cookie{
+			Name:     "csrf_token",
+			Path:     "/",
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		},
+		header:         "X-CSRF-Token",
+		field:          "csrf_token",
+		trustedOrigins: map[string]struct{}{},
+		errorHandler:   defaultErrorHandler,
+	}
+}
+
+// Store 使用同步器令牌模式，token 保存在 store 里面，不再使用 cookie
+func (m *MiddlewareBuilder) Store(store TokenStore) *MiddlewareBuilder {
+	m.store = store
+	return m
+}
+
+// Secret 双重提交模式下用于签名 cookie 的密钥
+// 只设置 Secret 的时候，签名只能防止攻击者自己构造 token
+// 攻击者依旧可以拿到服务端给自己签发的 token 再种到受害者的浏览器里面，要配合 SessionID 使用
+func (m *MiddlewareBuilder) Secret(secret []byte) *MiddlewareBuilder {
+	m.secret = secret
+	return m
+}
+
+// SessionID 双重提交模式下返回当前请求的会话 id 或者用户 id，会被加到 cookie 的签名里面
+// 这样别的会话的 token 就不能用了，登录之后会话变了也会重新生成 token
+// 匿名用户可以返回空字符串
+func (m *MiddlewareBuilder) SessionID(fn func(ctx *web.Context) string) *MiddlewareBuilder {
+	m.sessionID = fn
+	return m
+}
+
+// Cookie 双重提交模式下 cookie 的模板，Value 和 Secure 会被覆盖
+// 前端需要用 JS 读取 token 的时候要把 HttpOnly 设置为 false
+func (m *MiddlewareBuilder) Cookie(cookie http.Cookie) *MiddlewareBuilder {
+	m.cookie = cookie
+	return m
+}
+
+// Header 读取 token 的请求头，默认是 X-CSRF-Token
+func (m *MiddlewareBuilder) Header(header string) *MiddlewareBuilder {
+	m.header = header
+	return m
+}
+
+// Field 读取 token 的表单字段，默认是 csrf_token
+func (m *MiddlewareBuilder) Field(field string) *MiddlewareBuilder {
+	m.field = field
+	return m
+}
+
+// TrustedOrigins 信任的其它 Origin，例如 https://admin.example.com
+func (m *MiddlewareBuilder) TrustedOrigins(origins ...string) *MiddlewareBuilder {
+	for _, origin := range origins {
+		m.trustedOrigins[origin] = struct{}{}
+	}
+	return m
+}
+
+// Exempt 不做校验的路由，例如第三方的回调，支持 path.Match 的语法
+func (m *MiddlewareBuilder) Exempt(patterns ...string) *MiddlewareBuilder {
+	m.exempts = append(m.exempts, patterns...)
+	return m
+}
+
+// ErrorHandler 校验失败的时候的响应
+// 默认返回 403，ctx.Err 是对应的 web.Problem
+func (m *MiddlewareBuilder) ErrorHandler(fn func(ctx *web.Context, err error)) *MiddlewareBuilder {
+	m.errorHandler = fn
+	return m
+}
+
+func (m *MiddlewareBuilder) Build() web.Middleware {
+	return func(next web.HandleFunc) web.HandleFunc {
+		return func(ctx *web.Context) {
+			if m.exempt(ctx.MatchedRoute) {
+				next(ctx)
+				return
+			}
+			token, err := m.token(ctx)
+			if err != nil {
+				ctx.Logger().Error("csrf: 读取 token 失败", slog.Any("err", err))
+			}
+
+			if !safeMethod(ctx.Req.Method) {
+				if err = m.check(ctx, token); err != nil {
+					m.errorHandler(ctx, err)
+					return
+				}
+			}
+
+			if token == "" {
+				token, err = m.newToken(ctx)
+				switch {
+				case errors.Is(err, ErrNoSession):
+					// 匿名用户访问页面是正常的，没有会话也就没有 token
+					token = ""
+				case err != nil:
+					ctx.Logger().Error("csrf: 保存 token 失败", slog.Any("err", err))
+				}
+			}
+			ctx.SetUserValue(TokenKey, token)
+			ctx.SetUserValue(fieldKey, m.field)
+			next(ctx)
+		}
+	}
+}
+
+// token 读取当前会话的 token，双重提交模式下就是 cookie 里面的值
+func (m *MiddlewareBuilder) token(ctx *web.Context) (string, error) {
+	if m.store != nil {
+		return m.store.Get(ctx)
+	}
+	cookie, err := ctx.Req.Cookie(m.cookie.Name)
+	if err != nil || !verify(m.secret, m.session(ctx), cookie.Value) {
+		return "", nil
+	}
+	return cookie.Value, nil
+}
+
+func (m *MiddlewareBuilder) newToken(ctx *web.Context) (string, error) {
+	token := newToken()
+	if m.store != nil {
+		return token, m.store.Save(ctx, token)
+	}
+	token = sign(m.secret, m.session(ctx), token)
+	cookie := m.cookie
+	cookie.Value = token
+	cookie.Secure = cookie.Secure || ctx.Scheme() == "https"
+	ctx.SetCookie(&cookie)
+	return token, nil
+}
+
+func (m *MiddlewareBuilder) session(ctx *web.Context) string {
+	if m.sessionID == nil {
+		return ""
+	}
+	return m.sessionID(ctx)
+}
+
+func (m *MiddlewareBuilder) check(ctx *web.Context, token string) error {
+	if !m.checkOrigin(ctx) {
+		return ErrOriginMismatch
+	}
+	if token == "" {
+		return ErrTokenMissing
+	}
+	submitted := ctx.Req.Header.Get(m.header)
+	if submitted == "" {
+		submitted = ctx.Req.PostFormValue(m.field)
+	}
+	if submitted == "" {
+		return ErrTokenMissing
+	}
+	if !equal(submitted, token) {
+		return ErrTokenInvalid
+	}
+	return nil
+}
+
+// checkOrigin 优先使用 Origin，没有的话使用 Referer
+// 两个都没有的时候，HTTPS 的请求直接拒绝，HTTP 的请求只依赖 token 校验
+// 因为 HTTP 的 Referer 可能被中间的代理去掉
+func (m *MiddlewareBuilder) checkOrigin(ctx *web.Context) bool {
+	origin := ctx.Req.Header.Get("Origin")
+	if origin == "" {
+		referer := ctx.Req.Referer()
+		if referer == "" {
+			return ctx.Scheme() != "https"
+		}
+		u, err := url.Parse(referer)
+		if err != nil || u.Host == "" {
+			return false
+		}
+		origin = u.Scheme + "://" + u.Host
+	}
+	if origin == ctx.Scheme()+"://"+ctx.Host() {
+		return true
+	}
+	_, ok := m.trustedOrigins[origin]
+	return ok
+}
+
+func (m *MiddlewareBuilder) exempt(route string) bool {
+	for _, pattern := range m.exempts {
+		if pattern == route {
+			return true
+		}
+		if ok, _ := path.Match(pattern, route); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func defaultErrorHandler(ctx *web.Context, err error) {
+	p := web.NewProblem(http.StatusForbidden)
+	p.Detail = err.Error()
+	ctx.RespStatusCode = http.StatusForbidden
+	ctx.Err = p
+}
+
+func safeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// Token 返回当前请求的 token，用于渲染模板或者返回给前端
+func Token(ctx *web.Context) string {
+	token, _ := ctx.UserValue(TokenKey)
+	res, _ := token.(string)
+	return res
+}
+
+// TemplateField 返回包含 token 的隐藏表单字段，可以直接放到模板的数据里面
+func TemplateField(ctx *web.Context) template.HTML {
+	field, _ := ctx.UserValue(fieldKey)
+	name, _ := field.(string)
+	return template.HTML(`<input type="hidden" name="` + template.HTMLEscapeString(name) +
+		`" value="` + template.HTMLEscapeString(Token(ctx)) + `">`)
+}
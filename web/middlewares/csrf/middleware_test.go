@@ -0,0 +1,251 @@
+package csrf
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	"web"
+)
+
+func newServer(builder *MiddlewareBuilder) *web.HttpServer {
+	server := web.NewHttpServer(web.ServerWithMiddleware(builder.Build()))
+	server.Get("/form", func(ctx *web.Context) {
+		ctx.RespData = []byte(Token(ctx))
+	})
+	server.Post("/form", func(ctx *web.Context) {
+		ctx.RespData = []byte("ok")
+	})
+	server.Post("/webhook/:name", func(ctx *web.Context) {
+		ctx.RespData = []byte("ok")
+	})
+	return server
+}
+
+func TestMiddlewareBuilder_DoubleSubmit(t *testing.T) {
+	server := newServer(NewMiddlewareBuilder().Secret([]byte("secret")))
+
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/form", nil))
+	cookies := resp.Result().Cookies()
+	require.Len(t, cookies, 1)
+	cookie := cookies[0]
+	token := resp.Body.String()
+	assert.Equal(t, "csrf_token", cookie.Name)
+	assert.Equal(t, token, cookie.Value)
+	assert.True(t, cookie.HttpOnly)
+	assert.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+
+	// 已经有 cookie 的时候不会重新生成
+	req := httptest.NewRequest(http.MethodGet, "/form", nil)
+	req.AddCookie(cookie)
+	resp = httptest.NewRecorder()
+	server.ServeHTTP(resp, req)
+	assert.Empty(t, resp.Result().Cookies())
+	assert.Equal(t, token, resp.Body.String())
+
+	// 伪造的没有签名的 cookie
+	forged := &http.Cookie{Name: "csrf_token", Value: "abc"}
+	testCases := []struct {
+		name     string
+		cookie   *http.Cookie
+		header   string
+		form     string
+		wantCode int
+	}{
+		{name: "header", cookie: cookie, header: token, wantCode: http.StatusOK},
+		{name: "form", cookie: cookie, form: token, wantCode: http.StatusOK},
+		{name: "no cookie", header: token, wantCode: http.StatusForbidden},
+		{name: "no token", cookie: cookie, wantCode: http.StatusForbidden},
+		{name: "wrong token", cookie: cookie, header: token + "x", wantCode: http.StatusForbidden},
+		{name: "forged cookie", cookie: forged, header: "abc", wantCode: http.StatusForbidden},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			form := url.Values{}
+			if tc.form != "" {
+				form.Set("csrf_token", tc.form)
+			}
+			req := httptest.NewRequest(http.MethodPost, "/form", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tc.cookie != nil {
+				req.AddCookie(tc.cookie)
+			}
+			if tc.header != "" {
+				req.Header.Set("X-CSRF-Token", tc.header)
+			}
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, req)
+			assert.Equal(t, tc.wantCode, resp.Code)
+		})
+	}
+}
+
+func TestMiddlewareBuilder_DoubleSubmitSession(t *testing.T) {
+	server := newServer(NewMiddlewareBuilder().Secret([]byte("secret")).
+		SessionID(func(ctx *web.Context) string {
+			c, err := ctx.Req.Cookie("sid")
+			if err != nil {
+				return ""
+			}
+			return c.Value
+		}))
+	// 攻击者拿到了服务端给自己的会话签发的 token
+	req := httptest.NewRequest(http.MethodGet, "/form", nil)
+	req.AddCookie(&http.Cookie{Name: "sid", Value: "attacker"})
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, req)
+	cookies := resp.Result().Cookies()
+	require.Len(t, cookies, 1)
+	token := resp.Body.String()
+
+	post := func(sid string) int {
+		req := httptest.NewRequest(http.MethodPost, "/form", nil)
+		req.AddCookie(&http.Cookie{Name: "sid", Value: sid})
+		req.AddCookie(cookies[0])
+		req.Header.Set("X-CSRF-Token", token)
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, req)
+		return resp.Code
+	}
+	assert.Equal(t, http.StatusOK, post("attacker"))
+	// 种到受害者的浏览器里面也用不了
+	assert.Equal(t, http.StatusForbidden, post("victim"))
+}
+
+func TestMiddlewareBuilder_Origin(t *testing.T) {
+	server := newServer(NewMiddlewareBuilder().TrustedOrigins("https://admin.example.com"))
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/form", nil))
+	cookie := resp.Result().Cookies()[0]
+
+	testCases := []struct {
+		name     string
+		url      string
+		origin   string
+		referer  string
+		wantCode int
+	}{
+		{name: "same origin", url: "http://example.com/form", origin: "http://example.com", wantCode: http.StatusOK},
+		{name: "trusted", url: "http://example.com/form", origin: "https://admin.example.com", wantCode: http.StatusOK},
+		{name: "cross origin", url: "http://example.com/form", origin: "https://evil.com", wantCode: http.StatusForbidden},
+		{name: "referer", url: "http://example.com/form", referer: "http://example.com/page", wantCode: http.StatusOK},
+		{name: "cross referer", url: "http://example.com/form", referer: "https://evil.com/page", wantCode: http.StatusForbidden},
+		{name: "http without origin", url: "http://example.com/form", wantCode: http.StatusOK},
+		// HTTPS 的请求必须有 Origin 或者 Referer
+		{name: "https without origin", url: "https://example.com/form", wantCode: http.StatusForbidden},
+		{name: "https", url: "https://example.com/form", origin: "https://example.com", wantCode: http.StatusOK},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tc.url, nil)
+			req.AddCookie(cookie)
+			req.Header.Set("X-CSRF-Token", cookie.Value)
+			if tc.origin != "" {
+				req.Header.Set("Origin", tc.origin)
+			}
+			if tc.referer != "" {
+				req.Header.Set("Referer", tc.referer)
+			}
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, req)
+			assert.Equal(t, tc.wantCode, resp.Code)
+		})
+	}
+}
+
+func TestMiddlewareBuilder_Synchronizer(t *testing.T) {
+	store := NewMemoryStore(func(ctx *web.Context) string {
+		c, err := ctx.Req.Cookie("sid")
+		if err != nil {
+			return ""
+		}
+		return c.Value
+	}, time.Hour)
+	server := newServer(NewMiddlewareBuilder().Store(store))
+
+	req := httptest.NewRequest(http.MethodGet, "/form", nil)
+	req.AddCookie(&http.Cookie{Name: "sid", Value: "session-1"})
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, req)
+	// 同步器模式下不会写 cookie
+	assert.Empty(t, resp.Result().Cookies())
+	token := resp.Body.String()
+	require.NotEmpty(t, token)
+
+	post := func(sid, token string) int {
+		req := httptest.NewRequest(http.MethodPost, "/form", nil)
+		req.AddCookie(&http.Cookie{Name: "sid", Value: sid})
+		req.Header.Set("X-CSRF-Token", token)
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, req)
+		return resp.Code
+	}
+	assert.Equal(t, http.StatusOK, post("session-1", token))
+	// 别的会话的 token 不能用
+	assert.Equal(t, http.StatusForbidden, post("session-2", token))
+
+	// 过期之后需要重新获取
+	store.now = func() time.Time {
+		return time.Now().Add(2 * time.Hour)
+	}
+	assert.Equal(t, http.StatusForbidden, post("session-1", token))
+}
+
+func TestMiddlewareBuilder_SynchronizerAnonymous(t *testing.T) {
+	store := NewMemoryStore(func(ctx *web.Context) string {
+		return ""
+	}, time.Hour)
+	logs := &bytes.Buffer{}
+	server := web.NewHttpServer(
+		web.ServerWithLogger(slog.New(slog.NewTextHandler(logs, nil))),
+		web.ServerWithMiddleware(NewMiddlewareBuilder().Store(store).Build()))
+	server.Get("/form", func(ctx *web.Context) {
+		ctx.RespData = []byte(Token(ctx))
+	})
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/form", nil))
+	// 没有会话的 GET 请求是正常的，不需要打错误日志
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Empty(t, resp.Body.String())
+	assert.Empty(t, logs.String())
+}
+
+func TestMiddlewareBuilder_Exempt(t *testing.T) {
+	server := newServer(NewMiddlewareBuilder().Exempt("/webhook/*"))
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/webhook/github", nil))
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	resp = httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/form", nil))
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+}
+
+func TestMiddlewareBuilder_ErrorHandler(t *testing.T) {
+	server := web.NewHttpServer(web.ServerWithProblemDetails(),
+		web.ServerWithMiddleware(NewMiddlewareBuilder().Build()))
+	server.Post("/form", func(ctx *web.Context) {})
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/form", nil))
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+	assert.Equal(t, web.ProblemContentType, resp.Header().Get("Content-Type"))
+	assert.Contains(t, resp.Body.String(), ErrTokenMissing.Error())
+}
+
+func TestTemplateField(t *testing.T) {
+	server := web.NewHttpServer(web.ServerWithMiddleware(NewMiddlewareBuilder().Field("_csrf").Build()))
+	server.Get("/form", func(ctx *web.Context) {
+		ctx.RespData = []byte(TemplateField(ctx))
+	})
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/form", nil))
+	token := resp.Result().Cookies()[0].Value
+	assert.Equal(t, `<input type="hidden" name="_csrf" value="`+token+`">`, resp.Body.String())
+}
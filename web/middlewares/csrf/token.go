@@ -0,0 +1,127 @@
+package csrf
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+	"web"
+)
+
+// TokenStore 同步器令牌模式下在服务端保存 token 的地方，一般是和 session 绑定的
+type TokenStore interface {
+	// Get 返回当前会话的 token，没有的时候返回空字符串
+	Get(ctx *web.Context) (string, error)
+	// Save 保存当前会话的 token，没有会话的时候返回 ErrNoSession
+	Save(ctx *web.Context, token string) error
+}
+
+// ErrNoSession 没有办法确定当前请求属于哪个会话
+var ErrNoSession = errors.New("csrf: 没有会话")
+
+// MemoryStore 基于内存的 TokenStore，适合单实例部署
+type MemoryStore struct {
+	mutex     sync.Mutex
+	sessionID func(ctx *web.Context) string
+	ttl       time.Duration
+	tokens    map[string]storedToken
+	// lastCleanup 上一次清理过期 token 的时间
+	lastCleanup time.Time
+	now         func() time.Time
+}
+
+type storedToken struct {
+	token    string
+	expireAt time.Time
+}
+
+// NewMemoryStore sessionID 返回当前请求的会话 id，例如从 session 的 cookie 里面读取
+// token 在 ttl 之内没有被使用就会过期
+func NewMemoryStore(sessionID func(ctx *web.Context) string, ttl time.Duration) *MemoryStore {
+	return &MemoryStore{
+		sessionID: sessionID,
+		ttl:       ttl,
+		tokens:    map[string]storedToken{},
+		now:       time.Now,
+	}
+}
+
+func (s *MemoryStore) Get(ctx *web.Context) (string, error) {
+	sid := s.sessionID(ctx)
+	if sid == "" {
+		return "", nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := s.now()
+	st, ok := s.tokens[sid]
+	if !ok || !now.Before(st.expireAt) {
+		return "", nil
+	}
+	// 续期
+	st.expireAt = now.Add(s.ttl)
+	s.tokens[sid] = st
+	return st.token, nil
+}
+
+func (s *MemoryStore) Save(ctx *web.Context, token string) error {
+	sid := s.sessionID(ctx)
+	if sid == "" {
+		return ErrNoSession
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := s.now()
+	if now.Sub(s.lastCleanup) >= s.ttl {
+		for k, st := range s.tokens {
+			if !now.Before(st.expireAt) {
+				delete(s.tokens, k)
+			}
+		}
+		s.lastCleanup = now
+	}
+	s.tokens[sid] = storedToken{token: token, expireAt: now.Add(s.ttl)}
+	return nil
+}
+
+// newToken 生成 32 字节的随机 token
+func newToken() string {
+	var b [32]byte
+	_, _ = rand.Read(b[:])
+	return base64.RawURLEncoding.EncodeToString(b[:])
+}
+
+// sign 双重提交模式下给 token 加上签名，签名里面包含了会话 id
+// 只签 token 的话，攻击者可以从服务端拿到自己的会话的合法 token，再通过子域名之类的办法种到受害者的浏览器里面
+// token 是 base64，不会出现 \x00，所以和会话 id 拼在一起不会有歧义
+func sign(secret []byte, sessionID string, token string) string {
+	if len(secret) == 0 {
+		return token
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(token))
+	mac.Write([]byte{0})
+	mac.Write([]byte(sessionID))
+	return token + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verify 校验签名，会话变了之后之前的 token 就失效了
+func verify(secret []byte, sessionID string, signed string) bool {
+	if signed == "" {
+		return false
+	}
+	if len(secret) == 0 {
+		return true
+	}
+	token, _, ok := strings.Cut(signed, ".")
+	return ok && equal(sign(secret, sessionID, token), signed)
+}
+
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
@@ -0,0 +1,39 @@
+package errorhandler
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// acceptJSON 判断客户端是不是更希望拿到 JSON
+// 只有明确声明了 JSON，并且优先级比 HTML 高的时候才返回 true，*/* 不算
+func acceptJSON(req *http.Request) bool {
+	var jsonQ, htmlQ float64
+	for _, part := range strings.Split(req.Header.Get("Accept"), ",") {
+		mediaType, q := parseMediaRange(part)
+		switch {
+		case mediaType == "text/html" || mediaType == "application/xhtml+xml":
+			htmlQ = max(htmlQ, q)
+		case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+			jsonQ = max(jsonQ, q)
+		}
+	}
+	return jsonQ > 0 && jsonQ > htmlQ
+}
+
+// parseMediaRange 解析 application/json;q=0.9 这种格式
+func parseMediaRange(part string) (string, float64) {
+	params := strings.Split(part, ";")
+	mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+	q := 1.0
+	for _, param := range params[1:] {
+		key, val, ok := strings.Cut(strings.TrimSpace(param), "=")
+		if ok && strings.TrimSpace(key) == "q" {
+			if f, err := strconv.ParseFloat(strings.TrimSpace(val), 64); err == nil {
+				q = f
+			}
+		}
+	}
+	return mediaType, q
+}
@@ -1,21 +1,62 @@
 package errorhandler
 
-import "web"
+import (
+	"net/http"
+	"web"
+)
 
 type MiddlewareBuilder struct {
-	// 这种设计只能返回固定的值
-	// 不能做到动态渲染
-	resp map[int][]byte
+	// 精确匹配响应码
+	handlers map[int]web.HandleFunc
+	// 按照范围匹配，例如 4xx、5xx
+	ranges []rangeHandler
+	// fallback 没有匹配上的错误响应码使用的
+	fallback web.HandleFunc
+	// disableJSON 为 true 的时候不会根据 Accept 返回 JSON
+	disableJSON bool
+}
+
+type rangeHandler struct {
+	min     int
+	max     int
+	handler web.HandleFunc
 }
 
 func NewMiddlewareBuilder() *MiddlewareBuilder {
 	return &MiddlewareBuilder{
-		resp: map[int][]byte{},
+		handlers: map[int]web.HandleFunc{},
 	}
 }
 
+// AddCode 返回固定的数据
 func (m *MiddlewareBuilder) AddCode(status int, data []byte) *MiddlewareBuilder {
-	m.resp[status] = data
+	return m.AddHandler(status, func(ctx *web.Context) {
+		ctx.RespData = data
+	})
+}
+
+// AddHandler 按照响应码动态渲染，例如使用 TemplateHandler 渲染模板
+func (m *MiddlewareBuilder) AddHandler(status int, handler web.HandleFunc) *MiddlewareBuilder {
+	m.handlers[status] = handler
+	return m
+}
+
+// AddRange 处理 [min, max] 范围内的响应码，例如 AddRange(500, 599, handler)
+// 优先级低于 AddCode 和 AddHandler，多个范围重叠的时候先注册的生效
+func (m *MiddlewareBuilder) AddRange(min, max int, handler web.HandleFunc) *MiddlewareBuilder {
+	m.ranges = append(m.ranges, rangeHandler{min: min, max: max, handler: handler})
+	return m
+}
+
+// Fallback 处理其它没有匹配上的错误响应码（>= 400）
+func (m *MiddlewareBuilder) Fallback(handler web.HandleFunc) *MiddlewareBuilder {
+	m.fallback = handler
+	return m
+}
+
+// DisableJSON 不再根据 Accept 给 API 客户端返回 JSON
+func (m *MiddlewareBuilder) DisableJSON() *MiddlewareBuilder {
+	m.disableJSON = true
 	return m
 }
 
@@ -23,11 +64,94 @@ func (m MiddlewareBuilder) Build() web.Middleware {
 	return func(next web.HandleFunc) web.HandleFunc {
 		return func(ctx *web.Context) {
 			next(ctx)
-			resp, ok := m.resp[ctx.RespStatusCode]
-			if ok {
-				// 篡改结果
-				ctx.RespData = resp
+			handler := m.handler(ctx.RespStatusCode)
+			if handler == nil {
+				return
+			}
+			isProblem := ctx.Resp.Header().Get("Content-Type") == web.ProblemContentType
+			if !m.disableJSON && acceptJSON(ctx.Req) {
+				// 业务已经返回了 Problem，就不需要再覆盖了
+				if !isProblem {
+					problemHandler(ctx)
+				}
+				return
 			}
+			if isProblem {
+				// 给浏览器返回页面，Content-Type 要交给页面的 handler 来决定
+				ctx.Resp.Header().Del("Content-Type")
+			}
+			// 篡改结果
+			handler(ctx)
 		}
 	}
 }
+
+func (m MiddlewareBuilder) handler(status int) web.HandleFunc {
+	if h, ok := m.handlers[status]; ok {
+		return h
+	}
+	if status < http.StatusBadRequest {
+		return nil
+	}
+	for _, r := range m.ranges {
+		if status >= r.min && status <= r.max {
+			return r.handler
+		}
+	}
+	return m.fallback
+}
+
+// ErrorData 渲染错误页面的模板时使用的数据
+type ErrorData struct {
+	Status    int
+	Title     string
+	Method    string
+	Path      string
+	RequestID string
+	// Err 可能是内部错误，要注意不要直接展示给用户
+	Err error
+}
+
+func newErrorData(ctx *web.Context) ErrorData {
+	return ErrorData{
+		Status:    ctx.RespStatusCode,
+		Title:     http.StatusText(ctx.RespStatusCode),
+		Method:    ctx.Req.Method,
+		Path:      ctx.Req.URL.Path,
+		RequestID: ctx.RequestID,
+		Err:       ctx.Err,
+	}
+}
+
+// TemplateHandler 使用 server 设置的 TemplateEngine 渲染错误页面，数据是 ErrorData
+// 渲染失败的时候会返回响应码对应的文本
+func TemplateHandler(tplName string) web.HandleFunc {
+	return func(ctx *web.Context) {
+		status := ctx.RespStatusCode
+		err := ctx.Render(tplName, newErrorData(ctx))
+		// Render 会修改响应码，这里要改回去
+		ctx.RespStatusCode = status
+		if err != nil {
+			ctx.Logger().Error("errorhandler: 渲染错误页面失败", "template", tplName, "err", err)
+			ctx.RespData = []byte(http.StatusText(status))
+			return
+		}
+		ctx.Resp.Header().Set("Content-Type", "text/html; charset=utf-8")
+	}
+}
+
+// problemHandler 返回 RFC 9457 定义的 application/problem+json
+func problemHandler(ctx *web.Context) {
+	var p *web.Problem
+	if ctx.Err != nil {
+		p = web.ProblemFromError(ctx.Err, ctx.RespStatusCode)
+	} else {
+		p = web.NewProblem(ctx.RespStatusCode)
+	}
+	if ctx.RequestID != "" {
+		p.With("request_id", ctx.RequestID)
+	}
+	if err := ctx.RespProblem(p); err != nil {
+		ctx.Logger().Error("errorhandler: 返回 Problem 失败", "err", err)
+	}
+}
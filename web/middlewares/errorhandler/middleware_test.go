@@ -1,12 +1,19 @@
 package errorhandler
 
 import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"html/template"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"web"
 )
 
 func TestMiddlewareBuilder_Build(t *testing.T) {
+	tpl := template.Must(template.New("error").Parse(`<h1>{{.Status}} {{.Title}}</h1><p>{{.Path}}</p>`))
 	builder := NewMiddlewareBuilder()
 	builder.AddCode(http.StatusNotFound, []byte(`
 <html>
@@ -15,13 +22,139 @@ func TestMiddlewareBuilder_Build(t *testing.T) {
   </body>
 </html>
 `)).
-		AddCode(http.StatusBadRequest, []byte(`
-<html>
-  <body>
-	<h1>500 Inter Error</h1>
-  </body>
-</html>
-`))
+		AddHandler(http.StatusBadRequest, TemplateHandler("error")).
+		AddRange(500, 599, func(ctx *web.Context) {
+			ctx.RespData = []byte("5xx")
+		}).
+		Fallback(func(ctx *web.Context) {
+			ctx.RespData = []byte("fallback")
+		})
+	server := web.NewHttpServer(web.ServerWithMiddleware(builder.Build()),
+		web.ServerWithTemplateEngine(&goTemplateEngine{tpl: tpl}))
+	server.Get("/status/:code", func(ctx *web.Context) {
+		code, _ := ctx.PathValue("code").AsInt64()
+		ctx.RespStatusCode = int(code)
+		ctx.RespData = []byte("original")
+	})
+
+	testCases := []struct {
+		name       string
+		path       string
+		accept     string
+		wantStatus int
+		wantBody   string
+		wantType   string
+	}{
+		{
+			name:       "not found page",
+			path:       "/abc",
+			wantStatus: http.StatusNotFound,
+			wantBody:   "\n<html>\n  <body>\n\t<h1>404 Not Found</h1>\n  </body>\n</html>\n",
+		},
+		{
+			name:       "template",
+			path:       "/status/400",
+			accept:     "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8",
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "<h1>400 Bad Request</h1><p>/status/400</p>",
+			wantType:   "text/html; charset=utf-8",
+		},
+		{
+			name:       "range",
+			path:       "/status/503",
+			wantStatus: http.StatusServiceUnavailable,
+			wantBody:   "5xx",
+		},
+		{
+			name:       "fallback",
+			path:       "/status/418",
+			wantStatus: http.StatusTeapot,
+			wantBody:   "fallback",
+		},
+		{
+			name:       "ok",
+			path:       "/status/200",
+			wantStatus: http.StatusOK,
+			wantBody:   "original",
+		},
+		{
+			name:       "json",
+			path:       "/status/503",
+			accept:     "application/json",
+			wantStatus: http.StatusServiceUnavailable,
+			wantBody:   `{"instance":"/status/503","status":503,"title":"Service Unavailable","type":"about:blank"}`,
+			wantType:   "application/problem+json",
+		},
+		{
+			name:       "prefer html",
+			path:       "/status/503",
+			accept:     "application/json;q=0.5, text/html",
+			wantStatus: http.StatusServiceUnavailable,
+			wantBody:   "5xx",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			if tc.accept != "" {
+				req.Header.Set("Accept", tc.accept)
+			}
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, req)
+			assert.Equal(t, tc.wantStatus, recorder.Code)
+			assert.Equal(t, tc.wantBody, recorder.Body.String())
+			if tc.wantType != "" {
+				assert.Equal(t, tc.wantType, recorder.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestMiddlewareBuilder_Problem(t *testing.T) {
+	builder := NewMiddlewareBuilder().AddCode(http.StatusBadRequest, []byte("bad request page"))
 	server := web.NewHttpServer(web.ServerWithMiddleware(builder.Build()))
-	server.Start(":8081")
+	server.Post("/user", func(ctx *web.Context) {
+		var u struct{ Name string }
+		if err := ctx.BindJSON(&u); err != nil {
+			_ = ctx.RespProblem(web.ProblemFromError(err, http.StatusBadRequest))
+		}
+	})
+
+	// API 客户端拿到的是业务返回的 Problem
+	req := httptest.NewRequest(http.MethodPost, "/user", strings.NewReader("abc"))
+	req.Header.Set("Accept", "application/json")
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Equal(t, web.ProblemContentType, recorder.Header().Get("Content-Type"))
+	assert.Contains(t, recorder.Body.String(), `"detail":"invalid character 'a' looking for beginning of value"`)
+
+	// 浏览器拿到的还是页面
+	req = httptest.NewRequest(http.MethodPost, "/user", strings.NewReader("abc"))
+	req.Header.Set("Accept", "text/html")
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Equal(t, "bad request page", recorder.Body.String())
+	assert.NotEqual(t, web.ProblemContentType, recorder.Header().Get("Content-Type"))
+}
+
+func TestTemplateHandler_NoEngine(t *testing.T) {
+	builder := NewMiddlewareBuilder().AddHandler(http.StatusNotFound, TemplateHandler("404"))
+	server := web.NewHttpServer(web.ServerWithMiddleware(builder.Build()))
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/abc", nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+	assert.Equal(t, "Not Found", recorder.Body.String())
+}
+
+type goTemplateEngine struct {
+	tpl *template.Template
+}
+
+func (g *goTemplateEngine) Render(ctx context.Context, tplName string, data any) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	err := g.tpl.ExecuteTemplate(buf, tplName, data)
+	return buf.Bytes(), err
 }
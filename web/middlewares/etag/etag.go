@@ -0,0 +1,75 @@
+package etag
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// Strong 根据内容生成强 ETag，内容的每个字节都一样的时候才相同
+func Strong(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// Weak 根据内容生成弱 ETag，语义相同就可以认为是同一个版本
+func Weak(data []byte) string {
+	return "W/" + Strong(data)
+}
+
+func isWeak(etag string) bool {
+	return strings.HasPrefix(etag, "W/")
+}
+
+// weakMatch RFC 9110 8.8.3.2，忽略 W/ 之后比较
+func weakMatch(a, b string) bool {
+	return strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+}
+
+// strongMatch 两个都不能是弱 ETag
+func strongMatch(a, b string) bool {
+	return !isWeak(a) && !isWeak(b) && a == b
+}
+
+// matchAny header 是 If-Match 或者 If-None-Match 的值，* 匹配任何存在的 ETag
+func matchAny(header string, etag string, match func(a, b string) bool) bool {
+	if strings.TrimSpace(header) == "*" {
+		return etag != ""
+	}
+	if etag == "" {
+		return false
+	}
+	for _, candidate := range parseList(header) {
+		if match(candidate, etag) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseList 解析逗号分隔的 ETag 列表
+// ETag 的引号里面也可能出现逗号，所以不能简单地按照逗号切割
+func parseList(header string) []string {
+	var res []string
+	for {
+		header = strings.TrimLeft(header, " \t,")
+		if header == "" {
+			return res
+		}
+		prefix := ""
+		if strings.HasPrefix(header, "W/") {
+			prefix = "W/"
+			header = header[2:]
+		}
+		if header == "" || header[0] != '"' {
+			// 格式不对，忽略剩下的部分
+			return res
+		}
+		end := strings.IndexByte(header[1:], '"')
+		if end < 0 {
+			return res
+		}
+		res = append(res, prefix+header[:end+2])
+		header = header[end+2:]
+	}
+}
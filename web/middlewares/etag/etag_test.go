@@ -0,0 +1,51 @@
+package etag
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseList(t *testing.T) {
+	testCases := []struct {
+		header string
+		want   []string
+	}{
+		{header: `"a"`, want: []string{`"a"`}},
+		{header: `"a", W/"b",  "c"`, want: []string{`"a"`, `W/"b"`, `"c"`}},
+		// 引号里面的逗号
+		{header: `"a,b", "c"`, want: []string{`"a,b"`, `"c"`}},
+		{header: `"a", bad, "c"`, want: []string{`"a"`}},
+		{header: `"unterminated`},
+		{header: ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.header, func(t *testing.T) {
+			assert.Equal(t, tc.want, parseList(tc.header))
+		})
+	}
+}
+
+func TestMatch(t *testing.T) {
+	testCases := []struct {
+		a, b       string
+		wantStrong bool
+		wantWeak   bool
+	}{
+		{a: `"1"`, b: `"1"`, wantStrong: true, wantWeak: true},
+		{a: `W/"1"`, b: `"1"`, wantWeak: true},
+		{a: `W/"1"`, b: `W/"1"`, wantWeak: true},
+		{a: `"1"`, b: `"2"`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.a+tc.b, func(t *testing.T) {
+			assert.Equal(t, tc.wantStrong, strongMatch(tc.a, tc.b))
+			assert.Equal(t, tc.wantWeak, weakMatch(tc.a, tc.b))
+		})
+	}
+}
+
+func TestStrong(t *testing.T) {
+	assert.Equal(t, Strong([]byte("hello")), Strong([]byte("hello")))
+	assert.NotEqual(t, Strong([]byte("hello")), Strong([]byte("world")))
+	assert.Equal(t, "W/"+Strong([]byte("hello")), Weak([]byte("hello")))
+}
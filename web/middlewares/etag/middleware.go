@@ -0,0 +1,164 @@
+package etag
+
+import (
+	"net/http"
+	"time"
+	"web"
+)
+
+// CurrentFunc 返回资源当前的 ETag，资源不存在的时候返回空字符串
+type CurrentFunc func(ctx *web.Context) (string, error)
+
+type MiddlewareBuilder struct {
+	weak    bool
+	current CurrentFunc
+}
+
+// NewMiddlewareBuilder 默认根据 RespData 生成强 ETag
+// 没有设置 Current 的时候，带有 If-Match 或者 If-None-Match 的 PUT、PATCH、DELETE 请求都会返回 412
+// 因为没有办法校验，直接执行的话客户端以为有保护，实际上会丢失更新；不带这两个头部的请求不受影响
+func NewMiddlewareBuilder() *MiddlewareBuilder {
+	return &MiddlewareBuilder{}
+}
+
+// Weak 生成弱 ETag，例如 JSON 字段顺序之类的变化不影响语义的时候
+func (m *MiddlewareBuilder) Weak() *MiddlewareBuilder {
+	m.weak = true
+	return m
+}
+
+// Current 查找资源当前的 ETag，用来在执行 PUT、PATCH、DELETE 之前校验 If-Match 和 If-None-Match
+// 要在修改之前校验，才能避免并发修改导致的更新丢失，所以没有办法根据 RespData 计算
+// 没有设置的时候这些方法带有前置条件的请求都会返回 412
+func (m *MiddlewareBuilder) Current(fn CurrentFunc) *MiddlewareBuilder {
+	m.current = fn
+	return m
+}
+
+// Build 业务可以自己设置 ETag 和 Last-Modified 响应头，没有设置 ETag 的时候根据 RespData 生成
+// 直接写入 Resp 的响应已经发出去了，没有办法处理
+func (m *MiddlewareBuilder) Build() web.Middleware {
+	return func(next web.HandleFunc) web.HandleFunc {
+		return func(ctx *web.Context) {
+			switch ctx.Req.Method {
+			case http.MethodGet, http.MethodHead:
+				m.serveGet(ctx, next)
+			case http.MethodPut, http.MethodPatch, http.MethodDelete:
+				if !m.checkPrecondition(ctx) {
+					return
+				}
+				next(ctx)
+			default:
+				next(ctx)
+			}
+		}
+	}
+}
+
+func (m *MiddlewareBuilder) serveGet(ctx *web.Context, next web.HandleFunc) {
+	resp := ctx.Resp
+	w := web.NewResponseWriter(resp)
+	ctx.Resp = w
+	defer func() {
+		ctx.Resp = resp
+	}()
+	next(ctx)
+	if !w.Wrote() {
+		m.conditionalGet(ctx)
+	}
+}
+
+// conditionalGet 处理 If-None-Match 和 If-Modified-Since，命中的时候返回 304
+func (m *MiddlewareBuilder) conditionalGet(ctx *web.Context) {
+	// 只有原本会返回 200 的时候才能返回 304
+	if ctx.Err != nil || (ctx.RespStatusCode != 0 && ctx.RespStatusCode != http.StatusOK) {
+		return
+	}
+	header := ctx.Resp.Header()
+	etag := header.Get("ETag")
+	if etag == "" {
+		if m.weak {
+			etag = Weak(ctx.RespData)
+		} else {
+			etag = Strong(ctx.RespData)
+		}
+		header.Set("ETag", etag)
+	}
+
+	req := ctx.Req
+	// 有 If-None-Match 的时候忽略 If-Modified-Since，RFC 9110 13.1.3
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		if matchAny(inm, etag, weakMatch) {
+			notModified(ctx)
+		}
+		return
+	}
+	if ims := req.Header.Get("If-Modified-Since"); ims != "" {
+		if notModifiedSince(header.Get("Last-Modified"), ims) {
+			notModified(ctx)
+		}
+	}
+}
+
+func notModifiedSince(lastModified, ifModifiedSince string) bool {
+	if lastModified == "" {
+		return false
+	}
+	lm, err := http.ParseTime(lastModified)
+	if err != nil {
+		return false
+	}
+	ims, err := http.ParseTime(ifModifiedSince)
+	if err != nil {
+		return false
+	}
+	// HTTP 时间只精确到秒
+	return !lm.Truncate(time.Second).After(ims)
+}
+
+func notModified(ctx *web.Context) {
+	header := ctx.Resp.Header()
+	// 304 没有响应体，ETag、Cache-Control、Vary 之类的要保留
+	header.Del("Content-Type")
+	header.Del("Content-Length")
+	ctx.RespStatusCode = http.StatusNotModified
+	ctx.RespData = nil
+}
+
+// checkPrecondition 不满足前置条件的时候返回 412，并且不会执行业务
+func (m *MiddlewareBuilder) checkPrecondition(ctx *web.Context) bool {
+	ifMatch := ctx.Req.Header.Get("If-Match")
+	ifNoneMatch := ctx.Req.Header.Get("If-None-Match")
+	if ifMatch == "" && ifNoneMatch == "" {
+		return true
+	}
+	if m.current == nil {
+		p := web.NewProblem(http.StatusPreconditionFailed)
+		p.Detail = "没有办法校验前置条件"
+		ctx.RespStatusCode = http.StatusPreconditionFailed
+		ctx.Err = p
+		return false
+	}
+	current, err := m.current(ctx)
+	if err != nil {
+		ctx.RespStatusCode = http.StatusInternalServerError
+		ctx.Err = err
+		return false
+	}
+	// If-Match 使用强比较，If-None-Match 使用弱比较，RFC 9110 13.1
+	if ifMatch != "" && !matchAny(ifMatch, current, strongMatch) {
+		preconditionFailed(ctx)
+		return false
+	}
+	// 例如 If-None-Match: * 代表只允许创建，资源已经存在的时候失败
+	if ifNoneMatch != "" && matchAny(ifNoneMatch, current, weakMatch) {
+		preconditionFailed(ctx)
+		return false
+	}
+	return true
+}
+
+func preconditionFailed(ctx *web.Context) {
+	ctx.RespStatusCode = http.StatusPreconditionFailed
+	ctx.Err = web.NewProblem(http.StatusPreconditionFailed)
+}
@@ -0,0 +1,284 @@
+package etag
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"web"
+)
+
+func TestMiddlewareBuilder_ConditionalGet(t *testing.T) {
+	lastModified := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	server := web.NewHttpServer(web.ServerWithMiddleware(NewMiddlewareBuilder().Build()))
+	server.Get("/user", func(ctx *web.Context) {
+		ctx.Resp.Header().Set("Cache-Control", "max-age=60")
+		_ = ctx.RespJSONOK(map[string]string{"name": "Tom"})
+	})
+	server.Get("/custom", func(ctx *web.Context) {
+		ctx.Resp.Header().Set("ETag", `W/"v2"`)
+		ctx.Resp.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+		ctx.RespData = []byte("custom")
+	})
+	server.Get("/stream", func(ctx *web.Context) {
+		_, _ = ctx.Resp.Write([]byte("stream"))
+	})
+	server.Get("/error", func(ctx *web.Context) {
+		ctx.RespStatusCode = http.StatusNotFound
+		ctx.RespData = []byte("not found")
+	})
+	userETag := Strong([]byte(`{"name":"Tom"}`))
+
+	testCases := []struct {
+		name       string
+		path       string
+		header     http.Header
+		wantStatus int
+		wantETag   string
+		wantBody   string
+	}{
+		{
+			name:       "generate",
+			path:       "/user",
+			wantStatus: http.StatusOK,
+			wantETag:   userETag,
+			wantBody:   `{"name":"Tom"}`,
+		},
+		{
+			name:       "if-none-match hit",
+			path:       "/user",
+			header:     http.Header{"If-None-Match": {`"other", ` + userETag}},
+			wantStatus: http.StatusNotModified,
+			wantETag:   userETag,
+		},
+		{
+			// If-None-Match 使用弱比较
+			name:       "if-none-match weak",
+			path:       "/user",
+			header:     http.Header{"If-None-Match": {"W/" + userETag}},
+			wantStatus: http.StatusNotModified,
+			wantETag:   userETag,
+		},
+		{
+			name:       "if-none-match star",
+			path:       "/user",
+			header:     http.Header{"If-None-Match": {"*"}},
+			wantStatus: http.StatusNotModified,
+			wantETag:   userETag,
+		},
+		{
+			name:       "if-none-match miss",
+			path:       "/user",
+			header:     http.Header{"If-None-Match": {`"other"`}},
+			wantStatus: http.StatusOK,
+			wantETag:   userETag,
+			wantBody:   `{"name":"Tom"}`,
+		},
+		{
+			name:       "handler etag",
+			path:       "/custom",
+			header:     http.Header{"If-None-Match": {`"v2"`}},
+			wantStatus: http.StatusNotModified,
+			wantETag:   `W/"v2"`,
+		},
+		{
+			name:       "if-modified-since equal",
+			path:       "/custom",
+			header:     http.Header{"If-Modified-Since": {lastModified.Format(http.TimeFormat)}},
+			wantStatus: http.StatusNotModified,
+			wantETag:   `W/"v2"`,
+		},
+		{
+			name:       "if-modified-since before",
+			path:       "/custom",
+			header:     http.Header{"If-Modified-Since": {lastModified.Add(-time.Hour).Format(http.TimeFormat)}},
+			wantStatus: http.StatusOK,
+			wantETag:   `W/"v2"`,
+			wantBody:   "custom",
+		},
+		{
+			// 有 If-None-Match 的时候忽略 If-Modified-Since
+			name: "if-none-match takes precedence",
+			path: "/custom",
+			header: http.Header{
+				"If-None-Match":     {`"other"`},
+				"If-Modified-Since": {lastModified.Format(http.TimeFormat)},
+			},
+			wantStatus: http.StatusOK,
+			wantETag:   `W/"v2"`,
+			wantBody:   "custom",
+		},
+		{
+			name:       "write directly",
+			path:       "/stream",
+			header:     http.Header{"If-None-Match": {"*"}},
+			wantStatus: http.StatusOK,
+			wantBody:   "stream",
+		},
+		{
+			name:       "not 200",
+			path:       "/error",
+			header:     http.Header{"If-None-Match": {"*"}},
+			wantStatus: http.StatusNotFound,
+			wantBody:   "not found",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			for name, values := range tc.header {
+				req.Header[name] = values
+			}
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, req)
+			assert.Equal(t, tc.wantStatus, resp.Code)
+			assert.Equal(t, tc.wantETag, resp.Header().Get("ETag"))
+			assert.Equal(t, tc.wantBody, resp.Body.String())
+			if tc.wantStatus == http.StatusNotModified {
+				assert.Empty(t, resp.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestMiddlewareBuilder_Weak(t *testing.T) {
+	server := web.NewHttpServer(web.ServerWithMiddleware(NewMiddlewareBuilder().Weak().Build()))
+	server.Get("/user", func(ctx *web.Context) {
+		ctx.RespData = []byte("hello")
+	})
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/user", nil))
+	assert.Equal(t, Weak([]byte("hello")), resp.Header().Get("ETag"))
+}
+
+func TestMiddlewareBuilder_Precondition(t *testing.T) {
+	versions := map[string]string{"1": `"v1"`, "2": `W/"v2"`}
+	builder := NewMiddlewareBuilder().Current(func(ctx *web.Context) (string, error) {
+		id := ctx.PathParams["id"]
+		if id == "err" {
+			return "", errors.New("db error")
+		}
+		return versions[id], nil
+	})
+	server := web.NewHttpServer(web.ServerWithProblemDetails(), web.ServerWithMiddleware(builder.Build()))
+	for _, method := range []string{http.MethodPut, http.MethodPatch, http.MethodDelete} {
+		server.Handle(method, "/users/:id", func(ctx *web.Context) {
+			ctx.RespData = []byte("updated")
+		})
+	}
+
+	testCases := []struct {
+		name       string
+		method     string
+		path       string
+		header     http.Header
+		wantStatus int
+	}{
+		{
+			name:       "no precondition",
+			method:     http.MethodPut,
+			path:       "/users/1",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "if-match",
+			method:     http.MethodPut,
+			path:       "/users/1",
+			header:     http.Header{"If-Match": {`"v0", "v1"`}},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "if-match stale",
+			method:     http.MethodPatch,
+			path:       "/users/1",
+			header:     http.Header{"If-Match": {`"v0"`}},
+			wantStatus: http.StatusPreconditionFailed,
+		},
+		{
+			// If-Match 使用强比较，弱 ETag 永远不会匹配
+			name:       "if-match weak",
+			method:     http.MethodDelete,
+			path:       "/users/2",
+			header:     http.Header{"If-Match": {`W/"v2"`}},
+			wantStatus: http.StatusPreconditionFailed,
+		},
+		{
+			name:       "if-match star",
+			method:     http.MethodDelete,
+			path:       "/users/1",
+			header:     http.Header{"If-Match": {"*"}},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "if-match star not found",
+			method:     http.MethodDelete,
+			path:       "/users/3",
+			header:     http.Header{"If-Match": {"*"}},
+			wantStatus: http.StatusPreconditionFailed,
+		},
+		{
+			// 只允许创建
+			name:       "if-none-match star exists",
+			method:     http.MethodPut,
+			path:       "/users/1",
+			header:     http.Header{"If-None-Match": {"*"}},
+			wantStatus: http.StatusPreconditionFailed,
+		},
+		{
+			name:       "if-none-match star create",
+			method:     http.MethodPut,
+			path:       "/users/3",
+			header:     http.Header{"If-None-Match": {"*"}},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "current error",
+			method:     http.MethodPut,
+			path:       "/users/err",
+			header:     http.Header{"If-Match": {`"v1"`}},
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			for name, values := range tc.header {
+				req.Header[name] = values
+			}
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, req)
+			assert.Equal(t, tc.wantStatus, resp.Code)
+			if tc.wantStatus == http.StatusOK {
+				assert.Equal(t, "updated", resp.Body.String())
+				return
+			}
+			// 不满足前置条件的时候不会执行业务
+			assert.Equal(t, web.ProblemContentType, resp.Header().Get("Content-Type"))
+		})
+	}
+}
+
+func TestMiddlewareBuilder_PreconditionWithoutCurrent(t *testing.T) {
+	server := web.NewHttpServer(web.ServerWithMiddleware(NewMiddlewareBuilder().Build()))
+	var called bool
+	server.Put("/users/:id", func(ctx *web.Context) {
+		called = true
+	})
+
+	// 没有前置条件的请求不受影响
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest(http.MethodPut, "/users/1", nil))
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.True(t, called)
+
+	// 没有办法校验的时候不能当作没有看到，否则会丢失更新
+	called = false
+	req := httptest.NewRequest(http.MethodPut, "/users/1", nil)
+	req.Header.Set("If-Match", `"v1"`)
+	resp = httptest.NewRecorder()
+	server.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusPreconditionFailed, resp.Code)
+	assert.False(t, called)
+}
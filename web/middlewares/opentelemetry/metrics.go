@@ -0,0 +1,99 @@
+package opentelemetry
+
+import (
+	"context"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"time"
+	"web"
+)
+
+// durationBuckets 语义规范推荐的桶，单位是秒
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.075, 0.1, 0.25, 0.5, 0.75, 1, 2.5, 5, 7.5, 10}
+
+// serverMetrics HTTP 服务端的指标
+// https://opentelemetry.io/docs/specs/semconv/http/http-metrics/#http-server
+// 记录的时候使用的是带有 span 的 context，SDK 会据此生成关联到 span 的 exemplar
+type serverMetrics struct {
+	duration       metric.Float64Histogram
+	activeRequests metric.Int64UpDownCounter
+	reqSize        metric.Int64Histogram
+	respSize       metric.Int64Histogram
+}
+
+func newServerMetrics(mp metric.MeterProvider) *serverMetrics {
+	meter := mp.Meter(instrumentationName)
+	var (
+		res = &serverMetrics{}
+		err error
+	)
+	// 创建失败的时候返回的依旧是可用的 noop 指标，交给全局的 ErrorHandler 处理就可以
+	res.duration, err = meter.Float64Histogram(semconv.HTTPServerRequestDurationName,
+		metric.WithDescription(semconv.HTTPServerRequestDurationDescription),
+		metric.WithUnit(semconv.HTTPServerRequestDurationUnit),
+		metric.WithExplicitBucketBoundaries(durationBuckets...))
+	handleErr(err)
+	res.activeRequests, err = meter.Int64UpDownCounter(semconv.HTTPServerActiveRequestsName,
+		metric.WithDescription(semconv.HTTPServerActiveRequestsDescription),
+		metric.WithUnit(semconv.HTTPServerActiveRequestsUnit))
+	handleErr(err)
+	res.reqSize, err = meter.Int64Histogram(semconv.HTTPServerRequestBodySizeName,
+		metric.WithDescription(semconv.HTTPServerRequestBodySizeDescription),
+		metric.WithUnit(semconv.HTTPServerRequestBodySizeUnit))
+	handleErr(err)
+	res.respSize, err = meter.Int64Histogram(semconv.HTTPServerResponseBodySizeName,
+		metric.WithDescription(semconv.HTTPServerResponseBodySizeDescription),
+		metric.WithUnit(semconv.HTTPServerResponseBodySizeUnit))
+	handleErr(err)
+	return res
+}
+
+func handleErr(err error) {
+	if err != nil {
+		otel.Handle(err)
+	}
+}
+
+// start 开始处理请求
+func (s *serverMetrics) start(reqCtx context.Context, ctx *web.Context) {
+	s.activeRequests.Add(reqCtx, 1, metric.WithAttributeSet(activeAttributes(ctx)))
+}
+
+// end 请求处理完毕，errType 为空说明没有出错，resp 用于统计响应的大小
+func (s *serverMetrics) end(reqCtx context.Context, ctx *web.Context, resp *web.ResponseWriter,
+	startTime time.Time, status int, errType string) {
+	s.activeRequests.Add(reqCtx, -1, metric.WithAttributeSet(activeAttributes(ctx)))
+
+	attrs := []attribute.KeyValue{
+		semconv.HTTPRequestMethodKey.String(ctx.Req.Method),
+		semconv.URLScheme(ctx.Scheme()),
+		semconv.NetworkProtocolVersion(protocolVersion(ctx.Req)),
+		semconv.HTTPResponseStatusCode(status),
+	}
+	if ctx.MatchedRoute != "" {
+		attrs = append(attrs, semconv.HTTPRoute(ctx.MatchedRoute))
+	}
+	if errType != "" {
+		attrs = append(attrs, semconv.ErrorTypeKey.String(errType))
+	}
+	opt := metric.WithAttributeSet(attribute.NewSet(attrs...))
+
+	s.duration.Record(reqCtx, time.Since(startTime).Seconds(), opt)
+	reqBytes := ctx.Req.ContentLength
+	if reqBytes < 0 {
+		reqBytes = 0
+	}
+	s.reqSize.Record(reqCtx, reqBytes, opt)
+	// RespData 是在所有 middleware 执行完之后才写入的，需要单独计算
+	s.respSize.Record(reqCtx, int64(resp.Size()+len(ctx.RespData)), opt)
+}
+
+// activeAttributes 正在处理的请求只使用请求一开始就能确定的属性
+func activeAttributes(ctx *web.Context) attribute.Set {
+	return attribute.NewSet(
+		semconv.HTTPRequestMethodKey.String(ctx.Req.Method),
+		semconv.URLScheme(ctx.Scheme()),
+	)
+}
@@ -0,0 +1,141 @@
+package opentelemetry
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"web"
+)
+
+func TestMiddlewareBuilder_Metrics(t *testing.T) {
+	// exemplar 在当前版本的 SDK 里面还是实验特性
+	t.Setenv("OTEL_GO_X_EXEMPLAR", "true")
+	reader := sdkmetric.NewManualReader()
+	server, spans := newTestServer(t, MiddlewareBuilder{
+		MeterProvider: sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)),
+	})
+
+	var active metricdata.Metrics
+	server.Post("/user/:id", func(ctx *web.Context) {
+		// 处理过程中能够看到正在处理的请求
+		active = collect(t, reader)["http.server.active_requests"]
+		_, _ = ctx.Resp.Write([]byte("abc"))
+		ctx.RespData = []byte("de")
+	})
+	server.Get("/panic", func(ctx *web.Context) {
+		panic("abc")
+	})
+
+	server.ServeHTTP(httptest.NewRecorder(),
+		httptest.NewRequest(http.MethodPost, "/user/123", bytes.NewBufferString("hello")))
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/panic", nil))
+
+	activeSum := active.Data.(metricdata.Sum[int64])
+	require.Len(t, activeSum.DataPoints, 1)
+	assert.Equal(t, int64(1), activeSum.DataPoints[0].Value)
+
+	metrics := collect(t, reader)
+	assert.Equal(t, "s", metrics["http.server.request.duration"].Unit)
+	assert.Equal(t, "By", metrics["http.server.request.body.size"].Unit)
+
+	activeSum = metrics["http.server.active_requests"].Data.(metricdata.Sum[int64])
+	for _, dp := range activeSum.DataPoints {
+		assert.Equal(t, int64(0), dp.Value)
+	}
+
+	duration := metrics["http.server.request.duration"].Data.(metricdata.Histogram[float64])
+	require.Len(t, duration.DataPoints, 2)
+	ended := spans.Ended()
+	require.Len(t, ended, 2)
+	for _, dp := range duration.DataPoints {
+		assert.Equal(t, uint64(1), dp.Count)
+		// exemplar 关联到对应的 span
+		require.Len(t, dp.Exemplars, 1)
+		route, _ := dp.Attributes.Value("http.route")
+		span := ended[0]
+		if route.AsString() == "/panic" {
+			span = ended[1]
+			errType, _ := dp.Attributes.Value("error.type")
+			assert.Equal(t, "panic", errType.AsString())
+			status, _ := dp.Attributes.Value("http.response.status_code")
+			assert.Equal(t, int64(http.StatusInternalServerError), status.AsInt64())
+		}
+		traceID := span.SpanContext().TraceID()
+		spanID := span.SpanContext().SpanID()
+		assert.Equal(t, traceID[:], dp.Exemplars[0].TraceID)
+		assert.Equal(t, spanID[:], dp.Exemplars[0].SpanID)
+	}
+
+	want := attribute.NewSet(
+		attribute.String("http.request.method", http.MethodPost),
+		attribute.String("http.route", "/user/:id"),
+		attribute.String("url.scheme", "http"),
+		attribute.String("network.protocol.version", "1.1"),
+		attribute.Int("http.response.status_code", http.StatusOK),
+	)
+	assert.Equal(t, int64(5), sumOf(metrics["http.server.request.body.size"], want))
+	assert.Equal(t, int64(5), sumOf(metrics["http.server.response.body.size"], want))
+}
+
+func TestMiddlewareBuilder_WriteHeaderDirectly(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	server, spans := newTestServer(t, MiddlewareBuilder{
+		MeterProvider: sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)),
+	})
+	server.Get("/user", func(ctx *web.Context) {
+		ctx.Resp.WriteHeader(http.StatusInternalServerError)
+	})
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/user", nil))
+
+	// 直接写入的 5xx 也要算作错误
+	ended := spans.Ended()
+	require.Len(t, ended, 1)
+	assert.Equal(t, codes.Error, ended[0].Status().Code)
+	duration := collect(t, reader)["http.server.request.duration"].Data.(metricdata.Histogram[float64])
+	require.Len(t, duration.DataPoints, 1)
+	status, _ := duration.DataPoints[0].Attributes.Value("http.response.status_code")
+	assert.Equal(t, int64(http.StatusInternalServerError), status.AsInt64())
+}
+
+func TestMiddlewareBuilder_NoMetrics(t *testing.T) {
+	server, spans := newTestServer(t, MiddlewareBuilder{})
+	server.Get("/user", func(ctx *web.Context) {
+		// 没有 metrics 的时候也要知道直接写入的响应码
+		_, ok := ctx.Resp.(*web.ResponseWriter)
+		assert.True(t, ok)
+		ctx.Resp.WriteHeader(http.StatusBadGateway)
+	})
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/user", nil))
+	ended := spans.Ended()
+	require.Len(t, ended, 1)
+	assert.Equal(t, codes.Error, ended[0].Status().Code)
+}
+
+func collect(t *testing.T, reader sdkmetric.Reader) map[string]metricdata.Metrics {
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+	res := make(map[string]metricdata.Metrics)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			res[m.Name] = m
+		}
+	}
+	return res
+}
+
+func sumOf(m metricdata.Metrics, attrs attribute.Set) int64 {
+	for _, dp := range m.Data.(metricdata.Histogram[int64]).DataPoints {
+		if dp.Attributes.Equals(&attrs) {
+			return dp.Sum
+		}
+	}
+	return -1
+}
@@ -1,57 +1,211 @@
 package opentelemetry
 
 import (
+	"fmt"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
 	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 	"go.opentelemetry.io/otel/trace"
+	"net"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
 	"web"
 )
 
 type MiddlewareBuilder struct {
 	Tracer trace.Tracer
+	// Propagator 默认是 otel.GetTextMapPropagator()
+	Propagator propagation.TextMapPropagator
+	// SpanNameFormatter 默认是 "{method} {route}"，没有命中路由的时候只有 method
+	SpanNameFormatter func(ctx *web.Context) string
+	// Filter 返回 false 的请求不会创建 span，例如健康检查
+	Filter func(ctx *web.Context) bool
+	// DisableResponsePropagation 为 true 的时候不会在响应里面写入 traceparent 和 traceresponse
+	DisableResponsePropagation bool
+	// MeterProvider 不为 nil 的时候会按照语义规范记录请求耗时、正在处理的请求数量以及请求和响应的大小
+	// 指标是用带有 span 的 context 记录的，开启了 exemplar 的 SDK 会把数据关联到对应的 trace
+	MeterProvider metric.MeterProvider
 }
 
 const instrumentationName = "gitee.com/ObambooO/go_geektime_bootcamp/web/middlewares/opentelemetry"
 
+// requestIDKey 不在语义规范里面，使用自定义的 key
+const requestIDKey = attribute.Key("http.request_id")
+
 func (m MiddlewareBuilder) Build() web.Middleware {
 	if m.Tracer == nil {
 		m.Tracer = otel.GetTracerProvider().Tracer(instrumentationName)
 	}
+	if m.Propagator == nil {
+		m.Propagator = otel.GetTextMapPropagator()
+	}
+	if m.SpanNameFormatter == nil {
+		m.SpanNameFormatter = defaultSpanName
+	}
+	var metrics *serverMetrics
+	if m.MeterProvider != nil {
+		metrics = newServerMetrics(m.MeterProvider)
+	}
 	return func(next web.HandleFunc) web.HandleFunc {
 		return func(ctx *web.Context) {
+			if m.Filter != nil && !m.Filter(ctx) {
+				next(ctx)
+				return
+			}
 
+			startTime := time.Now()
 			reqCtx := ctx.Req.Context()
 
 			// 尝试和客户端的trace结合
-			reqCtx = otel.GetTextMapPropagator().Extract(reqCtx, propagation.HeaderCarrier(ctx.Req.Header))
+			reqCtx = m.Propagator.Extract(reqCtx, propagation.HeaderCarrier(ctx.Req.Header))
 
-			reqCtx, span := m.Tracer.Start(reqCtx, "unknown")
-			defer span.End()
+			// 路由在执行 middleware 之前就已经匹配好了，所以一开始就能确定 span 的名字
+			reqCtx, span := m.Tracer.Start(reqCtx, m.SpanNameFormatter(ctx),
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(requestAttributes(ctx)...))
+			// span 结束的时候如果还在 panic，SDK 会把 panic 记录成 exception 事件
+			defer span.End(trace.WithStackTrace(true))
 
-			span.SetAttributes(attribute.String("http.method", ctx.Req.Method))
-			span.SetAttributes(attribute.String("http.url", ctx.Req.URL.String()))
-			span.SetAttributes(attribute.String("http.schema", ctx.Req.URL.Scheme))
-			span.SetAttributes(attribute.String("http.host", ctx.Req.Host))
-
-			// 你这里还可以继续加
+			if !m.DisableResponsePropagation {
+				m.injectResponse(ctx, span.SpanContext())
+			}
 
 			// ctx是私有的，需要传递给下一个
 			// :性能会比较差，但逼不得已
 			ctx.Req = ctx.Req.WithContext(reqCtx)
 
+			// 业务可能直接写入响应，要从这里拿到响应码
+			resp := web.NewResponseWriter(ctx.Resp)
+			ctx.Resp = resp
+			if metrics != nil {
+				metrics.start(reqCtx, ctx)
+			}
+
+			defer func() {
+				if val := recover(); val != nil {
+					span.SetStatus(codes.Error, fmt.Sprintf("panic: %v", val))
+					span.SetAttributes(semconv.ErrorTypeKey.String("panic"))
+					if metrics != nil {
+						metrics.end(reqCtx, ctx, resp, startTime, http.StatusInternalServerError, "panic")
+					}
+					// 继续往上抛，交给 recover middleware 处理
+					panic(val)
+				}
+			}()
+
 			// 直接调用下一步
 			next(ctx)
-			// 这个是只有执行完next才可能有值
-			span.SetName(ctx.MatchedRoute)
 
-			// 把响应码加上去
-			span.SetAttributes(attribute.Int("http.status", ctx.RespStatusCode))
+			status := ctx.RespStatusCode
+			if status == 0 {
+				status = resp.Status()
+			}
+			if status == 0 {
+				status = http.StatusOK
+			}
+			span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+			// request id 可能是在后面的 middleware 里面设置的，所以放到最后
+			if ctx.RequestID != "" {
+				span.SetAttributes(requestIDKey.String(ctx.RequestID))
+			}
+			if ctx.Err != nil {
+				span.RecordError(ctx.Err)
+			}
+			// 对于服务端来说，只有 5xx 才算错误，4xx 是客户端的问题
+			var errType string
+			if status >= http.StatusInternalServerError {
+				errType = errorType(ctx.Err, status)
+				span.SetStatus(codes.Error, http.StatusText(status))
+				span.SetAttributes(semconv.ErrorTypeKey.String(errType))
+			}
+			if metrics != nil {
+				metrics.end(reqCtx, ctx, resp, startTime, status, errType)
+			}
 		}
 	}
 }
 
-// 支持自己传参
-//func NewMiddlewareBuilder(tracer trace.Tracer) *MiddlewareBuilder {
-//	return &MiddlewareBuilder{}
-//}
+// injectResponse 把 trace 信息写到响应头里面，方便客户端根据响应找到对应的 trace
+// traceparent 取决于 Propagator，traceresponse 是 W3C Trace Context Level 2 定义的
+func (m MiddlewareBuilder) injectResponse(ctx *web.Context, sc trace.SpanContext) {
+	if !sc.IsValid() {
+		return
+	}
+	header := ctx.Resp.Header()
+	m.Propagator.Inject(trace.ContextWithSpanContext(ctx.Req.Context(), sc), propagation.HeaderCarrier(header))
+	header.Set("traceresponse", fmt.Sprintf("00-%s-%s-%s", sc.TraceID(), sc.SpanID(), sc.TraceFlags()))
+}
+
+func defaultSpanName(ctx *web.Context) string {
+	if ctx.MatchedRoute == "" {
+		return ctx.Req.Method
+	}
+	return ctx.Req.Method + " " + ctx.MatchedRoute
+}
+
+// requestAttributes HTTP 服务端的语义规范
+// https://opentelemetry.io/docs/specs/semconv/http/http-spans/#http-server
+func requestAttributes(ctx *web.Context) []attribute.KeyValue {
+	req := ctx.Req
+	attrs := make([]attribute.KeyValue, 0, 12)
+	attrs = append(attrs,
+		semconv.HTTPRequestMethodKey.String(req.Method),
+		semconv.URLScheme(ctx.Scheme()),
+		semconv.URLPath(req.URL.Path),
+		semconv.ClientAddress(ctx.ClientIP()),
+		semconv.NetworkProtocolVersion(protocolVersion(req)),
+	)
+	if req.URL.RawQuery != "" {
+		attrs = append(attrs, semconv.URLQuery(req.URL.RawQuery))
+	}
+	if ctx.MatchedRoute != "" {
+		attrs = append(attrs, semconv.HTTPRoute(ctx.MatchedRoute))
+	}
+	host, port := splitHostPort(ctx.Host())
+	if host != "" {
+		attrs = append(attrs, semconv.ServerAddress(host))
+	}
+	if port > 0 {
+		attrs = append(attrs, semconv.ServerPort(port))
+	}
+	if peer, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		attrs = append(attrs, semconv.NetworkPeerAddress(peer))
+	}
+	if ua := req.UserAgent(); ua != "" {
+		attrs = append(attrs, semconv.UserAgentOriginal(ua))
+	}
+	if req.ContentLength > 0 {
+		attrs = append(attrs, semconv.HTTPRequestBodySize(int(req.ContentLength)))
+	}
+	return attrs
+}
+
+func protocolVersion(req *http.Request) string {
+	if req.ProtoMajor == 2 {
+		return "2"
+	}
+	return fmt.Sprintf("%d.%d", req.ProtoMajor, req.ProtoMinor)
+}
+
+func splitHostPort(hostport string) (string, int) {
+	host, portStr, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return strings.Trim(hostport, "[]"), 0
+	}
+	port, _ := strconv.Atoi(portStr)
+	return host, port
+}
+
+// errorType 有错误就用错误的类型，否则用响应码
+func errorType(err error, status int) string {
+	if err == nil {
+		return strconv.Itoa(status)
+	}
+	return reflect.TypeOf(err).String()
+}
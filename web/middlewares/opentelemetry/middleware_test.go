@@ -1,35 +1,168 @@
-//go:build e2e
-
 package opentelemetry
 
 import (
-	"go.opentelemetry.io/otel"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"net/http/httptest"
 	"testing"
-	"time"
 	"web"
+	"web/middlewares/recover"
+	"web/middlewares/requestid"
 )
 
+func newTestServer(t *testing.T, builder MiddlewareBuilder) (*web.HttpServer, *tracetest.SpanRecorder) {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	builder.Tracer = tp.Tracer(instrumentationName)
+	builder.Propagator = propagation.TraceContext{}
+	server := web.NewHttpServer(web.ServerWithMiddleware(
+		(&recover.MiddlewareBuilder{Log: func(ctx *web.Context, val any, stack string) {}}).Build(),
+		requestid.NewMiddlewareBuilder().Build(),
+		builder.Build()))
+	return server, recorder
+}
+
 func TestMiddlewareBuilder_Build(t *testing.T) {
-	tracer := otel.GetTracerProvider().Tracer(instrumentationName)
-	builder := MiddlewareBuilder{
-		Tracer: tracer,
+	server, spans := newTestServer(t, MiddlewareBuilder{})
+	server.Get("/user/:id", func(ctx *web.Context) {
+		ctx.RespData = []byte("hello")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com:8081/user/123?a=b", nil)
+	req.Header.Set("User-Agent", "test-agent")
+	req.Header.Set(requestid.DefaultHeader, "abc-123")
+	// 上游传过来的 trace
+	req.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, req)
+
+	ended := spans.Ended()
+	require.Len(t, ended, 1)
+	span := ended[0]
+	assert.Equal(t, "GET /user/:id", span.Name())
+	assert.Equal(t, trace.SpanKindServer, span.SpanKind())
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", span.SpanContext().TraceID().String())
+	assert.Equal(t, "b7ad6b7169203331", span.Parent().SpanID().String())
+	assert.Equal(t, codes.Unset, span.Status().Code)
+
+	attrs := attribute.NewSet(span.Attributes()...)
+	for key, want := range map[attribute.Key]attribute.Value{
+		"http.request.method":       attribute.StringValue(http.MethodGet),
+		"http.route":                attribute.StringValue("/user/:id"),
+		"url.scheme":                attribute.StringValue("http"),
+		"url.path":                  attribute.StringValue("/user/123"),
+		"url.query":                 attribute.StringValue("a=b"),
+		"server.address":            attribute.StringValue("example.com"),
+		"server.port":               attribute.IntValue(8081),
+		"client.address":            attribute.StringValue("192.0.2.1"),
+		"network.peer.address":      attribute.StringValue("192.0.2.1"),
+		"network.protocol.version":  attribute.StringValue("1.1"),
+		"user_agent.original":       attribute.StringValue("test-agent"),
+		"http.response.status_code": attribute.IntValue(http.StatusOK),
+		"http.request_id":           attribute.StringValue("abc-123"),
+	} {
+		got, ok := attrs.Value(key)
+		assert.True(t, ok, key)
+		assert.Equal(t, want, got, key)
 	}
-	server := web.NewHttpServer(web.ServerWithMiddleware(builder.Build()))
-
-	server.Get("/user", func(ctx *web.Context) {
-		c, span := tracer.Start(ctx.Req.Context(), "first_layer")
-		defer span.End()
-
-		c, second := tracer.Start(c, "second_layer")
-		time.Sleep(time.Second)
-		c, third1 := tracer.Start(c, "third_layer_1")
-		time.Sleep(100 * time.Millisecond)
-		third1.End()
-		c, third2 := tracer.Start(c, "third_layer_2")
-		time.Sleep(300 * time.Millisecond)
-		third2.End()
-		second.End()
+
+	sc := span.SpanContext()
+	assert.Equal(t, "00-"+sc.TraceID().String()+"-"+sc.SpanID().String()+"-01", resp.Header().Get("traceparent"))
+	assert.Equal(t, resp.Header().Get("traceparent"), resp.Header().Get("traceresponse"))
+}
+
+func TestMiddlewareBuilder_Error(t *testing.T) {
+	testCases := []struct {
+		name       string
+		handler    web.HandleFunc
+		wantStatus codes.Code
+		wantType   string
+		wantEvents int
+	}{
+		{
+			name: "client error",
+			handler: func(ctx *web.Context) {
+				ctx.RespStatusCode = http.StatusBadRequest
+			},
+			wantStatus: codes.Unset,
+		},
+		{
+			name: "server error",
+			handler: func(ctx *web.Context) {
+				ctx.RespStatusCode = http.StatusServiceUnavailable
+			},
+			wantStatus: codes.Error,
+			wantType:   "503",
+		},
+		{
+			name: "server error with err",
+			handler: func(ctx *web.Context) {
+				ctx.RespStatusCode = http.StatusInternalServerError
+				ctx.Err = &web.BindError{Err: errors.New("abc")}
+			},
+			wantStatus: codes.Error,
+			wantType:   "*web.BindError",
+			wantEvents: 1,
+		},
+		{
+			name: "panic",
+			handler: func(ctx *web.Context) {
+				panic("abc")
+			},
+			wantStatus: codes.Error,
+			wantType:   "panic",
+			wantEvents: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server, spans := newTestServer(t, MiddlewareBuilder{})
+			server.Get("/user", tc.handler)
+			server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/user", nil))
+
+			ended := spans.Ended()
+			require.Len(t, ended, 1)
+			span := ended[0]
+			assert.Equal(t, tc.wantStatus, span.Status().Code)
+			attrs := attribute.NewSet(span.Attributes()...)
+			errType, _ := attrs.Value("error.type")
+			assert.Equal(t, tc.wantType, errType.AsString())
+			assert.Len(t, span.Events(), tc.wantEvents)
+		})
+	}
+}
+
+func TestMiddlewareBuilder_FilterAndName(t *testing.T) {
+	server, spans := newTestServer(t, MiddlewareBuilder{
+		Filter: func(ctx *web.Context) bool {
+			return ctx.MatchedRoute != "/health"
+		},
+		SpanNameFormatter: func(ctx *web.Context) string {
+			return "HTTP " + ctx.MatchedRoute
+		},
+		DisableResponsePropagation: true,
 	})
+	server.Get("/health", func(ctx *web.Context) {})
+	server.Get("/user", func(ctx *web.Context) {})
+
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health", nil))
+	assert.Empty(t, spans.Ended())
 
-	server.Start(":8081")
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/user", nil))
+	ended := spans.Ended()
+	require.Len(t, ended, 1)
+	assert.Equal(t, "HTTP /user", ended[0].Name())
+	assert.Empty(t, resp.Header().Get("traceparent"))
+	assert.Empty(t, resp.Header().Get("traceresponse"))
 }
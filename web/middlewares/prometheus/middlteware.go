@@ -1,7 +1,9 @@
 package prometheus
 
 import (
+	"errors"
 	"github.com/prometheus/client_golang/prometheus"
+	"net/http"
 	"strconv"
 	"time"
 	"web"
@@ -10,41 +12,134 @@ import (
 type MiddlewareBuilder struct {
 	Namespace string
 	Subsystem string
-	Name      string
-	Help      string
+	// Name 请求耗时的指标名，默认是 http_request_duration_seconds，单位是秒
+	Name string
+	Help string
+
+	// Registerer 注册指标的地方，默认是 prometheus.DefaultRegisterer
+	// 多次 Build 会复用已经注册的指标，不会 panic
+	Registerer prometheus.Registerer
+
+	// Buckets 经典直方图的桶，默认是 prometheus.DefBuckets
+	Buckets []float64
+	// NativeHistogramBucketFactor 大于 1 的时候开启原生直方图
+	// 如果同时没有设置 Buckets，那么就只有原生直方图
+	NativeHistogramBucketFactor float64
+	// SizeBuckets 请求和响应大小的桶，单位是字节
+	SizeBuckets []float64
+
+	// ExtraLabels 额外的标签，值从 ctx 里面提取，例如租户、客户端类型
+	ExtraLabels []Label
+}
+
+// Label 额外的标签
+type Label struct {
+	Name  string
+	Value func(ctx *web.Context) string
 }
 
 func (m MiddlewareBuilder) Build() web.Middleware {
-	vector := prometheus.NewSummaryVec(prometheus.SummaryOpts{
-		Name:      m.Name,
+	if m.Registerer == nil {
+		m.Registerer = prometheus.DefaultRegisterer
+	}
+	if m.Name == "" {
+		m.Name = "http_request_duration_seconds"
+	}
+	if m.Help == "" {
+		m.Help = "HTTP 请求的处理时间，单位是秒"
+	}
+	if m.SizeBuckets == nil {
+		m.SizeBuckets = prometheus.ExponentialBuckets(100, 10, 7)
+	}
+	if m.Buckets == nil && m.NativeHistogramBucketFactor <= 1 {
+		m.Buckets = prometheus.DefBuckets
+	}
+
+	labels := []string{"pattern", "method", "status"}
+	for _, l := range m.ExtraLabels {
+		labels = append(labels, l.Name)
+	}
+
+	duration := register(m.Registerer, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:                   m.Namespace,
+		Subsystem:                   m.Subsystem,
+		Name:                        m.Name,
+		Help:                        m.Help,
+		Buckets:                     m.Buckets,
+		NativeHistogramBucketFactor: m.NativeHistogramBucketFactor,
+	}, labels))
+	reqSize := register(m.Registerer, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: m.Namespace,
 		Subsystem: m.Subsystem,
-		Namespace: m.Name,
-		Help:      m.Help,
-		Objectives: map[float64]float64{
-			0.5:   0.01,
-			0.75:  0.01,
-			0.90:  0.01,
-			0.99:  0.001,
-			0.999: 0.0001,
-		},
-	}, []string{"pattern", "method", "status"})
-
-	prometheus.MustRegister(vector)
+		Name:      "http_request_size_bytes",
+		Help:      "HTTP 请求的大小，单位是字节",
+		Buckets:   m.SizeBuckets,
+	}, labels))
+	respSize := register(m.Registerer, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: m.Namespace,
+		Subsystem: m.Subsystem,
+		Name:      "http_response_size_bytes",
+		Help:      "HTTP 响应的大小，单位是字节",
+		Buckets:   m.SizeBuckets,
+	}, labels))
+	inFlight := register(m.Registerer, prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: m.Namespace,
+		Subsystem: m.Subsystem,
+		Name:      "http_requests_in_flight",
+		Help:      "正在处理的 HTTP 请求数量",
+	}))
 
 	return func(next web.HandleFunc) web.HandleFunc {
 		return func(ctx *web.Context) {
 			startTime := time.Now()
+			inFlight.Inc()
+			resp := web.NewResponseWriter(ctx.Resp)
+			ctx.Resp = resp
 			defer func() {
-				duration := time.Now().Sub(startTime).Milliseconds()
+				inFlight.Dec()
 				pattern := ctx.MatchedRoute
 				if pattern == "" {
 					pattern = "unknown"
 				}
-				vector.WithLabelValues(pattern, ctx.Req.Method,
-					strconv.Itoa(ctx.RespStatusCode)).Observe(float64(duration))
+				// 业务可能直接调用了 ctx.Resp.WriteHeader
+				status := ctx.RespStatusCode
+				if status == 0 {
+					status = resp.Status()
+				}
+				if status == 0 {
+					status = http.StatusOK
+				}
+				values := []string{pattern, ctx.Req.Method, strconv.Itoa(status)}
+				for _, l := range m.ExtraLabels {
+					values = append(values, l.Value(ctx))
+				}
+
+				duration.WithLabelValues(values...).Observe(time.Since(startTime).Seconds())
+				reqBytes := ctx.Req.ContentLength
+				if reqBytes < 0 {
+					reqBytes = 0
+				}
+				reqSize.WithLabelValues(values...).Observe(float64(reqBytes))
+				respSize.WithLabelValues(values...).Observe(float64(resp.Size() + len(ctx.RespData)))
 			}()
 
 			next(ctx)
 		}
 	}
 }
+
+// register 注册指标，如果已经注册过了，就返回已经注册的那个
+// 这样同一个 Registerer 上多次 Build 也不会 panic
+func register[T prometheus.Collector](reg prometheus.Registerer, c T) T {
+	err := reg.Register(c)
+	if err == nil {
+		return c
+	}
+	var are prometheus.AlreadyRegisteredError
+	if errors.As(err, &are) {
+		if existing, ok := are.ExistingCollector.(T); ok {
+			return existing
+		}
+	}
+	panic(err)
+}
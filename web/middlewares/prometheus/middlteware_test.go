@@ -1,39 +1,158 @@
-//go:build e2e
-
 package prometheus
 
 import (
-	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"math/rand"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
-	"time"
 	"web"
 )
 
 func TestMiddlewareBuilder_Build(t *testing.T) {
+	reg := prometheus.NewRegistry()
 	builder := MiddlewareBuilder{
-		Namespace: "geekbang",
-		Subsystem: "web",
-		Name:      "http_response",
+		Namespace:  "geekbang",
+		Subsystem:  "web",
+		Registerer: reg,
+		Buckets:    []float64{0.1, 1},
+		ExtraLabels: []Label{
+			{
+				Name: "tenant",
+				Value: func(ctx *web.Context) string {
+					return ctx.Req.Header.Get("X-Tenant")
+				},
+			},
+		},
 	}
 	server := web.NewHttpServer(web.ServerWithMiddleware(builder.Build()))
+	var inFlight float64
+	server.Post("/user/:id", func(ctx *web.Context) {
+		inFlight = testutil.ToFloat64(builder.inFlight(t, reg))
+		ctx.RespStatusCode = http.StatusCreated
+		ctx.RespData = []byte("hello")
+	})
 
-	server.Get("/user", func(ctx *web.Context) {
-		val := rand.Intn(1000) + 1
+	req := httptest.NewRequest(http.MethodPost, "/user/123", strings.NewReader("abc"))
+	req.Header.Set("X-Tenant", "geekbang")
+	server.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, float64(1), inFlight)
+	err := testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP geekbang_web_http_request_size_bytes HTTP 请求的大小，单位是字节
+# TYPE geekbang_web_http_request_size_bytes histogram
+geekbang_web_http_request_size_bytes_bucket{method="POST",pattern="/user/:id",status="201",tenant="geekbang",le="100"} 1
+geekbang_web_http_request_size_bytes_bucket{method="POST",pattern="/user/:id",status="201",tenant="geekbang",le="1000"} 1
+geekbang_web_http_request_size_bytes_bucket{method="POST",pattern="/user/:id",status="201",tenant="geekbang",le="10000"} 1
+geekbang_web_http_request_size_bytes_bucket{method="POST",pattern="/user/:id",status="201",tenant="geekbang",le="100000"} 1
+geekbang_web_http_request_size_bytes_bucket{method="POST",pattern="/user/:id",status="201",tenant="geekbang",le="1e+06"} 1
+geekbang_web_http_request_size_bytes_bucket{method="POST",pattern="/user/:id",status="201",tenant="geekbang",le="1e+07"} 1
+geekbang_web_http_request_size_bytes_bucket{method="POST",pattern="/user/:id",status="201",tenant="geekbang",le="1e+08"} 1
+geekbang_web_http_request_size_bytes_bucket{method="POST",pattern="/user/:id",status="201",tenant="geekbang",le="+Inf"} 1
+geekbang_web_http_request_size_bytes_sum{method="POST",pattern="/user/:id",status="201",tenant="geekbang"} 3
+geekbang_web_http_request_size_bytes_count{method="POST",pattern="/user/:id",status="201",tenant="geekbang"} 1
+# HELP geekbang_web_http_response_size_bytes HTTP 响应的大小，单位是字节
+# TYPE geekbang_web_http_response_size_bytes histogram
+geekbang_web_http_response_size_bytes_bucket{method="POST",pattern="/user/:id",status="201",tenant="geekbang",le="100"} 1
+geekbang_web_http_response_size_bytes_bucket{method="POST",pattern="/user/:id",status="201",tenant="geekbang",le="1000"} 1
+geekbang_web_http_response_size_bytes_bucket{method="POST",pattern="/user/:id",status="201",tenant="geekbang",le="10000"} 1
+geekbang_web_http_response_size_bytes_bucket{method="POST",pattern="/user/:id",status="201",tenant="geekbang",le="100000"} 1
+geekbang_web_http_response_size_bytes_bucket{method="POST",pattern="/user/:id",status="201",tenant="geekbang",le="1e+06"} 1
+geekbang_web_http_response_size_bytes_bucket{method="POST",pattern="/user/:id",status="201",tenant="geekbang",le="1e+07"} 1
+geekbang_web_http_response_size_bytes_bucket{method="POST",pattern="/user/:id",status="201",tenant="geekbang",le="1e+08"} 1
+geekbang_web_http_response_size_bytes_bucket{method="POST",pattern="/user/:id",status="201",tenant="geekbang",le="+Inf"} 1
+geekbang_web_http_response_size_bytes_sum{method="POST",pattern="/user/:id",status="201",tenant="geekbang"} 5
+geekbang_web_http_response_size_bytes_count{method="POST",pattern="/user/:id",status="201",tenant="geekbang"} 1
+# HELP geekbang_web_http_requests_in_flight 正在处理的 HTTP 请求数量
+# TYPE geekbang_web_http_requests_in_flight gauge
+geekbang_web_http_requests_in_flight 0
+`), "geekbang_web_http_request_size_bytes", "geekbang_web_http_response_size_bytes",
+		"geekbang_web_http_requests_in_flight")
+	require.NoError(t, err)
+
+	// 耗时是不确定的，只看数量
+	assert.Equal(t, 1, testutil.CollectAndCount(builder.durationVec(t, reg)))
 
-		time.Sleep(time.Duration(val) * time.Millisecond)
-		ctx.RespJSON(200, User{Name: "tom"})
+	// 重复 Build 不会 panic，而是复用已经注册的指标
+	assert.NotPanics(t, func() {
+		builder.Build()
+	})
+}
+
+func TestMiddlewareBuilder_WriteDirectly(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	builder := MiddlewareBuilder{Namespace: "geekbang", Subsystem: "web", Registerer: reg}
+	server := web.NewHttpServer(web.ServerWithMiddleware(builder.Build()))
+	server.Get("/user", func(ctx *web.Context) {
+		ctx.Resp.WriteHeader(http.StatusInternalServerError)
+		_, _ = ctx.Resp.Write([]byte("error"))
 	})
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/user", nil))
+
+	// 直接写入的响应码也要记录下来，否则告警统计不到
+	err := testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP geekbang_web_http_response_size_bytes HTTP 响应的大小，单位是字节
+# TYPE geekbang_web_http_response_size_bytes histogram
+geekbang_web_http_response_size_bytes_bucket{method="GET",pattern="/user",status="500",le="100"} 1
+geekbang_web_http_response_size_bytes_bucket{method="GET",pattern="/user",status="500",le="1000"} 1
+geekbang_web_http_response_size_bytes_bucket{method="GET",pattern="/user",status="500",le="10000"} 1
+geekbang_web_http_response_size_bytes_bucket{method="GET",pattern="/user",status="500",le="100000"} 1
+geekbang_web_http_response_size_bytes_bucket{method="GET",pattern="/user",status="500",le="1e+06"} 1
+geekbang_web_http_response_size_bytes_bucket{method="GET",pattern="/user",status="500",le="1e+07"} 1
+geekbang_web_http_response_size_bytes_bucket{method="GET",pattern="/user",status="500",le="1e+08"} 1
+geekbang_web_http_response_size_bytes_bucket{method="GET",pattern="/user",status="500",le="+Inf"} 1
+geekbang_web_http_response_size_bytes_sum{method="GET",pattern="/user",status="500"} 5
+geekbang_web_http_response_size_bytes_count{method="GET",pattern="/user",status="500"} 1
+`), "geekbang_web_http_response_size_bytes")
+	require.NoError(t, err)
+}
 
-	go func() {
-		http.Handle("/metrics", promhttp.Handler())
-		http.ListenAndServe(":8082", nil)
-	}()
+func TestMiddlewareBuilder_NativeHistogram(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	builder := MiddlewareBuilder{
+		Registerer:                  reg,
+		NativeHistogramBucketFactor: 1.1,
+	}
+	server := web.NewHttpServer(web.ServerWithMiddleware(builder.Build()))
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/abc", nil))
+
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	for _, f := range families {
+		if f.GetName() != "http_request_duration_seconds" {
+			continue
+		}
+		h := f.GetMetric()[0].GetHistogram()
+		// 只有原生直方图，没有经典的桶
+		assert.Empty(t, h.GetBucket())
+		assert.Equal(t, int32(3), h.GetSchema())
+		assert.Equal(t, "unknown", f.GetMetric()[0].GetLabel()[1].GetValue())
+		return
+	}
+	t.Fatal("没有找到耗时指标")
+}
 
-	server.Start(":8081")
+// inFlight 重新构造一个同名的指标，注册的时候拿到已有的那个
+func (m MiddlewareBuilder) inFlight(t *testing.T, reg prometheus.Registerer) prometheus.Gauge {
+	t.Helper()
+	return register(reg, prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: m.Namespace,
+		Subsystem: m.Subsystem,
+		Name:      "http_requests_in_flight",
+		Help:      "正在处理的 HTTP 请求数量",
+	}))
 }
 
-type User struct {
-	Name string
+func (m MiddlewareBuilder) durationVec(t *testing.T, reg prometheus.Registerer) *prometheus.HistogramVec {
+	t.Helper()
+	return register(reg, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: m.Namespace,
+		Subsystem: m.Subsystem,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP 请求的处理时间，单位是秒",
+		Buckets:   m.Buckets,
+	}, []string{"pattern", "method", "status", "tenant"}))
 }
@@ -0,0 +1,234 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// Result 一次限流判断的结果，用于设置 RateLimit-* 响应头
+type Result struct {
+	Allowed bool
+	// Limit 窗口内允许的最大请求数，令牌桶就是桶的容量
+	Limit int
+	// Remaining 剩余的配额
+	Remaining int
+	// ResetAfter 配额完全恢复需要的时间
+	ResetAfter time.Duration
+	// RetryAfter 被拒绝的时候，至少要等多久才能重试
+	RetryAfter time.Duration
+}
+
+// Limiter 限流器，key 代表限流的对象，例如 IP、路由
+// 默认提供了基于内存的实现，多实例部署的时候可以基于 Redis 之类的实现
+type Limiter interface {
+	Allow(ctx context.Context, key string) (Result, error)
+}
+
+// TokenBucketLimiter 令牌桶，允许一定程度的突发流量
+type TokenBucketLimiter struct {
+	store *memoryStore[bucket]
+	// interval 生成一个令牌需要的时间
+	interval time.Duration
+	burst    int
+	now      func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucketLimiter 每个 period 生成 limit 个令牌，桶里面最多有 burst 个令牌
+// 参数必须大于 0，否则 panic
+func NewTokenBucketLimiter(limit int, period time.Duration, burst int) *TokenBucketLimiter {
+	checkArgs(limit, period)
+	if burst <= 0 {
+		panic(fmt.Sprintf("ratelimit: burst 必须大于 0，实际是 %d", burst))
+	}
+	interval := period / time.Duration(limit)
+	if interval <= 0 {
+		panic(fmt.Sprintf("ratelimit: period %s 内不能生成 %d 个令牌", period, limit))
+	}
+	return &TokenBucketLimiter{
+		store:    newMemoryStore[bucket](),
+		interval: interval,
+		burst:    burst,
+		now:      time.Now,
+	}
+}
+
+func (l *TokenBucketLimiter) Allow(_ context.Context, key string) (Result, error) {
+	now := l.now()
+	res := Result{Limit: l.burst}
+	l.store.update(key, now, func(b *bucket, fresh bool) time.Time {
+		if fresh {
+			b.tokens = float64(l.burst)
+		} else {
+			b.tokens = math.Min(float64(l.burst), b.tokens+float64(now.Sub(b.last))/float64(l.interval))
+		}
+		b.last = now
+		if b.tokens >= 1 {
+			b.tokens--
+			res.Allowed = true
+		} else {
+			res.RetryAfter = time.Duration((1 - b.tokens) * float64(l.interval))
+		}
+		res.Remaining = int(b.tokens)
+		res.ResetAfter = time.Duration((float64(l.burst) - b.tokens) * float64(l.interval))
+		// 桶满了之后就没有必要保存了
+		return now.Add(res.ResetAfter)
+	})
+	return res, nil
+}
+
+// checkArgs 参数不对的时候限流器根本没有办法工作，和配置错误一样在启动的时候就 panic
+func checkArgs(limit int, window time.Duration) {
+	if limit <= 0 {
+		panic(fmt.Sprintf("ratelimit: limit 必须大于 0，实际是 %d", limit))
+	}
+	if window <= 0 {
+		panic(fmt.Sprintf("ratelimit: 时间窗口必须大于 0，实际是 %s", window))
+	}
+}
+
+// FixedWindowLimiter 固定窗口，实现简单，但是窗口交界处可能会有两倍的流量
+type FixedWindowLimiter struct {
+	store  *memoryStore[fixedWindow]
+	limit  int
+	window time.Duration
+	now    func() time.Time
+}
+
+type fixedWindow struct {
+	start time.Time
+	count int
+}
+
+// NewFixedWindowLimiter 每个 window 最多 limit 个请求
+// 参数必须大于 0，否则 panic
+func NewFixedWindowLimiter(limit int, window time.Duration) *FixedWindowLimiter {
+	checkArgs(limit, window)
+	return &FixedWindowLimiter{
+		store:  newMemoryStore[fixedWindow](),
+		limit:  limit,
+		window: window,
+		now:    time.Now,
+	}
+}
+
+func (l *FixedWindowLimiter) Allow(_ context.Context, key string) (Result, error) {
+	now := l.now()
+	res := Result{Limit: l.limit}
+	l.store.update(key, now, func(w *fixedWindow, fresh bool) time.Time {
+		start := now.Truncate(l.window)
+		if fresh || !w.start.Equal(start) {
+			w.start, w.count = start, 0
+		}
+		end := start.Add(l.window)
+		res.ResetAfter = end.Sub(now)
+		if w.count < l.limit {
+			w.count++
+			res.Allowed = true
+		} else {
+			res.RetryAfter = res.ResetAfter
+		}
+		res.Remaining = l.limit - w.count
+		return end
+	})
+	return res, nil
+}
+
+// SlidingLogLimiter 滑动窗口日志，记录窗口内每个请求的时间，最精确，但是占用的内存和 limit 成正比
+type SlidingLogLimiter struct {
+	store  *memoryStore[[]time.Time]
+	limit  int
+	window time.Duration
+	now    func() time.Time
+}
+
+// NewSlidingLogLimiter 任意 window 长度的时间内最多 limit 个请求
+// 参数必须大于 0，否则 panic
+func NewSlidingLogLimiter(limit int, window time.Duration) *SlidingLogLimiter {
+	checkArgs(limit, window)
+	return &SlidingLogLimiter{
+		store:  newMemoryStore[[]time.Time](),
+		limit:  limit,
+		window: window,
+		now:    time.Now,
+	}
+}
+
+func (l *SlidingLogLimiter) Allow(_ context.Context, key string) (Result, error) {
+	now := l.now()
+	res := Result{Limit: l.limit}
+	l.store.update(key, now, func(log *[]time.Time, fresh bool) time.Time {
+		if fresh {
+			*log = make([]time.Time, 0, l.limit)
+		}
+		// 去掉已经滑出窗口的请求
+		boundary := now.Add(-l.window)
+		i := 0
+		for i < len(*log) && !(*log)[i].After(boundary) {
+			i++
+		}
+		*log = append((*log)[:0], (*log)[i:]...)
+		if len(*log) < l.limit {
+			*log = append(*log, now)
+			res.Allowed = true
+		} else {
+			res.RetryAfter = (*log)[0].Add(l.window).Sub(now)
+		}
+		res.Remaining = l.limit - len(*log)
+		last := (*log)[len(*log)-1].Add(l.window)
+		res.ResetAfter = last.Sub(now)
+		return last
+	})
+	return res, nil
+}
+
+// cleanupInterval 清理过期 key 的间隔
+const cleanupInterval = time.Minute
+
+// memoryStore 在内存里面保存每个 key 的状态
+// 过期的 key 会在访问的时候顺便清理掉，不需要额外的 goroutine
+type memoryStore[T any] struct {
+	mutex       sync.Mutex
+	items       map[string]*item[T]
+	lastCleanup time.Time
+}
+
+type item[T any] struct {
+	val      T
+	expireAt time.Time
+}
+
+func newMemoryStore[T any]() *memoryStore[T] {
+	return &memoryStore[T]{
+		items: map[string]*item[T]{},
+	}
+}
+
+// update 在锁里面修改 key 的状态，fresh 代表 key 不存在或者已经过期了
+// fn 返回状态的过期时间
+func (s *memoryStore[T]) update(key string, now time.Time, fn func(val *T, fresh bool) time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if now.Sub(s.lastCleanup) >= cleanupInterval {
+		for k, it := range s.items {
+			if !now.Before(it.expireAt) {
+				delete(s.items, k)
+			}
+		}
+		s.lastCleanup = now
+	}
+	it, ok := s.items[key]
+	fresh := !ok || !now.Before(it.expireAt)
+	if !ok {
+		it = &item[T]{}
+		s.items[key] = it
+	}
+	it.expireAt = fn(&it.val, fresh)
+}
@@ -0,0 +1,147 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Add(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 2, 15, 4, 0, 0, time.UTC)}
+}
+
+func allow(t *testing.T, l Limiter, key string) Result {
+	t.Helper()
+	res, err := l.Allow(context.Background(), key)
+	require.NoError(t, err)
+	return res
+}
+
+func TestTokenBucketLimiter(t *testing.T) {
+	clock := newFakeClock()
+	// 每秒两个令牌，最多攒三个
+	l := NewTokenBucketLimiter(2, time.Second, 3)
+	l.now = clock.Now
+
+	for i := 2; i >= 0; i-- {
+		res := allow(t, l, "a")
+		assert.True(t, res.Allowed)
+		assert.Equal(t, 3, res.Limit)
+		assert.Equal(t, i, res.Remaining)
+	}
+	res := allow(t, l, "a")
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 500*time.Millisecond, res.RetryAfter)
+	assert.Equal(t, 1500*time.Millisecond, res.ResetAfter)
+
+	// 不同的 key 互不影响
+	assert.True(t, allow(t, l, "b").Allowed)
+
+	clock.Add(500 * time.Millisecond)
+	res = allow(t, l, "a")
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 0, res.Remaining)
+
+	// 很久之后，桶满了，但是不会超过容量
+	clock.Add(time.Hour)
+	res = allow(t, l, "a")
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 2, res.Remaining)
+}
+
+func TestFixedWindowLimiter(t *testing.T) {
+	clock := newFakeClock()
+	l := NewFixedWindowLimiter(2, time.Minute)
+	l.now = clock.Now
+
+	clock.Add(40 * time.Second)
+	assert.Equal(t, 1, allow(t, l, "a").Remaining)
+	assert.Equal(t, 0, allow(t, l, "a").Remaining)
+	res := allow(t, l, "a")
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 20*time.Second, res.RetryAfter)
+	assert.Equal(t, 20*time.Second, res.ResetAfter)
+
+	// 进入下一个窗口就重新计算
+	clock.Add(20 * time.Second)
+	res = allow(t, l, "a")
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 1, res.Remaining)
+	assert.Equal(t, time.Minute, res.ResetAfter)
+}
+
+func TestSlidingLogLimiter(t *testing.T) {
+	clock := newFakeClock()
+	l := NewSlidingLogLimiter(2, time.Minute)
+	l.now = clock.Now
+
+	assert.True(t, allow(t, l, "a").Allowed)
+	clock.Add(40 * time.Second)
+	res := allow(t, l, "a")
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 0, res.Remaining)
+	assert.Equal(t, time.Minute, res.ResetAfter)
+
+	// 和固定窗口不一样，跨过整分钟也不会放行
+	clock.Add(10 * time.Second)
+	res = allow(t, l, "a")
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 10*time.Second, res.RetryAfter)
+
+	// 第一个请求滑出了窗口
+	clock.Add(10 * time.Second)
+	res = allow(t, l, "a")
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 0, res.Remaining)
+	assert.Equal(t, time.Minute, res.ResetAfter)
+}
+
+func TestLimiter_InvalidArgs(t *testing.T) {
+	testCases := []struct {
+		name string
+		fn   func()
+	}{
+		{name: "token bucket zero limit", fn: func() { NewTokenBucketLimiter(0, time.Second, 1) }},
+		{name: "token bucket zero period", fn: func() { NewTokenBucketLimiter(1, 0, 1) }},
+		{name: "token bucket zero burst", fn: func() { NewTokenBucketLimiter(1, time.Second, 0) }},
+		{name: "token bucket short period", fn: func() { NewTokenBucketLimiter(10, time.Nanosecond, 1) }},
+		{name: "fixed window negative limit", fn: func() { NewFixedWindowLimiter(-1, time.Second) }},
+		{name: "fixed window zero window", fn: func() { NewFixedWindowLimiter(1, 0) }},
+		{name: "sliding log zero limit", fn: func() { NewSlidingLogLimiter(0, time.Second) }},
+		{name: "sliding log negative window", fn: func() { NewSlidingLogLimiter(1, -time.Second) }},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Panics(t, tc.fn)
+		})
+	}
+}
+
+func TestMemoryStore_Cleanup(t *testing.T) {
+	clock := newFakeClock()
+	l := NewFixedWindowLimiter(1, time.Second)
+	l.now = clock.Now
+	allow(t, l, "a")
+	allow(t, l, "b")
+	assert.Len(t, l.store.items, 2)
+
+	// 过期的 key 在下一次清理的时候被删除
+	clock.Add(cleanupInterval)
+	allow(t, l, "c")
+	assert.Len(t, l.store.items, 1)
+	assert.Contains(t, l.store.items, "c")
+}
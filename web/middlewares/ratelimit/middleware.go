@@ -0,0 +1,119 @@
+package ratelimit
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"web"
+)
+
+// KeyFunc 从请求里面提取限流的 key，返回空字符串的请求不限流
+type KeyFunc func(ctx *web.Context) string
+
+// KeyByIP 按照客户端 IP 限流，IP 的解析依赖 server 设置的可信代理
+func KeyByIP(ctx *web.Context) string {
+	return ctx.ClientIP()
+}
+
+// KeyByRoute 按照路由限流，所有客户端共享同一个配额
+func KeyByRoute(ctx *web.Context) string {
+	if ctx.MatchedRoute == "" {
+		return ""
+	}
+	return ctx.Req.Method + " " + ctx.MatchedRoute
+}
+
+// KeyByHeader 按照请求头限流，例如 API key，没有这个请求头的请求不限流
+func KeyByHeader(header string) KeyFunc {
+	return func(ctx *web.Context) string {
+		return ctx.Req.Header.Get(header)
+	}
+}
+
+// CombineKeys 组合多个 key，例如每个 IP 在每个路由上面单独计算
+// 任何一个 key 为空都不限流
+func CombineKeys(fns ...KeyFunc) KeyFunc {
+	return func(ctx *web.Context) string {
+		keys := make([]string, 0, len(fns))
+		for _, fn := range fns {
+			key := fn(ctx)
+			if key == "" {
+				return ""
+			}
+			keys = append(keys, key)
+		}
+		return strings.Join(keys, "|")
+	}
+}
+
+type MiddlewareBuilder struct {
+	limiter   Limiter
+	keyFunc   KeyFunc
+	onLimited web.HandleFunc
+}
+
+// NewMiddlewareBuilder 默认按照客户端 IP 限流
+func NewMiddlewareBuilder(limiter Limiter) *MiddlewareBuilder {
+	return &MiddlewareBuilder{
+		limiter:   limiter,
+		keyFunc:   KeyByIP,
+		onLimited: defaultOnLimited,
+	}
+}
+
+// KeyFunc 设置限流的 key
+func (m *MiddlewareBuilder) KeyFunc(fn KeyFunc) *MiddlewareBuilder {
+	m.keyFunc = fn
+	return m
+}
+
+// OnLimited 被限流的时候的响应，响应头已经设置好了
+// 默认返回 429，ctx.Err 是对应的 web.Problem
+func (m *MiddlewareBuilder) OnLimited(handler web.HandleFunc) *MiddlewareBuilder {
+	m.onLimited = handler
+	return m
+}
+
+func (m *MiddlewareBuilder) Build() web.Middleware {
+	return func(next web.HandleFunc) web.HandleFunc {
+		return func(ctx *web.Context) {
+			key := m.keyFunc(ctx)
+			if key == "" {
+				next(ctx)
+				return
+			}
+			res, err := m.limiter.Allow(ctx.Req.Context(), key)
+			if err != nil {
+				// 限流器本身出问题了，例如 Redis 连不上，这个时候放行，不要影响业务
+				ctx.Logger().Error("ratelimit: 限流判断失败", slog.String("key", key), slog.Any("err", err))
+				next(ctx)
+				return
+			}
+
+			// https://datatracker.ietf.org/doc/draft-ietf-httpapi-ratelimit-headers/
+			header := ctx.Resp.Header()
+			header.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+			header.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			header.Set("RateLimit-Reset", seconds(res.ResetAfter))
+			if !res.Allowed {
+				header.Set("Retry-After", seconds(res.RetryAfter))
+				m.onLimited(ctx)
+				return
+			}
+			next(ctx)
+		}
+	}
+}
+
+func defaultOnLimited(ctx *web.Context) {
+	ctx.RespStatusCode = http.StatusTooManyRequests
+	ctx.Err = web.NewProblem(http.StatusTooManyRequests)
+}
+
+// seconds 向上取整，避免客户端过早重试
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
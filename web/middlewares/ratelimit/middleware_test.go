@@ -0,0 +1,157 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"web"
+)
+
+type errLimiter struct{}
+
+func (errLimiter) Allow(ctx context.Context, key string) (Result, error) {
+	return Result{}, errors.New("mock error")
+}
+
+func TestMiddlewareBuilder_Build(t *testing.T) {
+	limiter := NewFixedWindowLimiter(1, time.Minute)
+	clock := newFakeClock()
+	clock.Add(30 * time.Second)
+	limiter.now = clock.Now
+	server := web.NewHttpServer(web.ServerWithProblemDetails(),
+		web.ServerWithMiddleware(NewMiddlewareBuilder(limiter).Build()))
+	server.Get("/user", func(ctx *web.Context) {
+		ctx.RespData = []byte("hello")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/user", nil)
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "hello", resp.Body.String())
+	assert.Equal(t, "1", resp.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "0", resp.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "30", resp.Header().Get("RateLimit-Reset"))
+	assert.Empty(t, resp.Header().Get("Retry-After"))
+
+	resp = httptest.NewRecorder()
+	server.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusTooManyRequests, resp.Code)
+	assert.Equal(t, "30", resp.Header().Get("Retry-After"))
+	assert.Equal(t, web.ProblemContentType, resp.Header().Get("Content-Type"))
+
+	// 其它 IP 不受影响
+	req = httptest.NewRequest(http.MethodGet, "/user", nil)
+	req.RemoteAddr = "192.0.2.2:1234"
+	resp = httptest.NewRecorder()
+	server.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+}
+
+func TestMiddlewareBuilder_KeyFunc(t *testing.T) {
+	testCases := []struct {
+		name    string
+		keyFunc KeyFunc
+		reqs    []*http.Request
+		// 每个请求的响应码
+		wantCodes []int
+	}{
+		{
+			name:    "route",
+			keyFunc: KeyByRoute,
+			reqs: []*http.Request{
+				httptest.NewRequest(http.MethodGet, "/user/1", nil),
+				httptest.NewRequest(http.MethodGet, "/user/2", nil),
+				httptest.NewRequest(http.MethodGet, "/order", nil),
+				// 没有命中路由的不限流
+				httptest.NewRequest(http.MethodGet, "/not-found", nil),
+				httptest.NewRequest(http.MethodGet, "/not-found", nil),
+			},
+			wantCodes: []int{200, 429, 200, 404, 404},
+		},
+		{
+			name:    "header",
+			keyFunc: KeyByHeader("X-Api-Key"),
+			reqs: []*http.Request{
+				withHeader(httptest.NewRequest(http.MethodGet, "/user/1", nil), "X-Api-Key", "a"),
+				withHeader(httptest.NewRequest(http.MethodGet, "/order", nil), "X-Api-Key", "a"),
+				withHeader(httptest.NewRequest(http.MethodGet, "/order", nil), "X-Api-Key", "b"),
+				httptest.NewRequest(http.MethodGet, "/order", nil),
+				httptest.NewRequest(http.MethodGet, "/order", nil),
+			},
+			wantCodes: []int{200, 429, 200, 200, 200},
+		},
+		{
+			name:    "combine",
+			keyFunc: CombineKeys(KeyByRoute, KeyByIP),
+			reqs: []*http.Request{
+				httptest.NewRequest(http.MethodGet, "/user/1", nil),
+				httptest.NewRequest(http.MethodGet, "/order", nil),
+				httptest.NewRequest(http.MethodGet, "/user/2", nil),
+			},
+			wantCodes: []int{200, 200, 429},
+		},
+		{
+			name: "custom",
+			keyFunc: func(ctx *web.Context) string {
+				return ctx.Req.URL.Query().Get("tenant")
+			},
+			reqs: []*http.Request{
+				httptest.NewRequest(http.MethodGet, "/user/1?tenant=a", nil),
+				httptest.NewRequest(http.MethodGet, "/user/1?tenant=b", nil),
+				httptest.NewRequest(http.MethodGet, "/order?tenant=a", nil),
+			},
+			wantCodes: []int{200, 200, 429},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			builder := NewMiddlewareBuilder(NewSlidingLogLimiter(1, time.Minute)).KeyFunc(tc.keyFunc)
+			server := web.NewHttpServer(web.ServerWithMiddleware(builder.Build()))
+			server.Get("/user/:id", func(ctx *web.Context) {})
+			server.Get("/order", func(ctx *web.Context) {})
+			for i, req := range tc.reqs {
+				resp := httptest.NewRecorder()
+				server.ServeHTTP(resp, req)
+				assert.Equal(t, tc.wantCodes[i], resp.Code, i)
+			}
+		})
+	}
+}
+
+func TestMiddlewareBuilder_OnLimited(t *testing.T) {
+	builder := NewMiddlewareBuilder(NewTokenBucketLimiter(1, time.Second, 1)).
+		OnLimited(func(ctx *web.Context) {
+			ctx.RespStatusCode = http.StatusServiceUnavailable
+			ctx.RespData = []byte("slow down")
+		})
+	server := web.NewHttpServer(web.ServerWithMiddleware(builder.Build()))
+	server.Get("/user", func(ctx *web.Context) {})
+
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/user", nil))
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/user", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, resp.Code)
+	assert.Equal(t, "slow down", resp.Body.String())
+	assert.Equal(t, "1", resp.Header().Get("Retry-After"))
+}
+
+func TestMiddlewareBuilder_LimiterError(t *testing.T) {
+	server := web.NewHttpServer(web.ServerWithMiddleware(NewMiddlewareBuilder(errLimiter{}).Build()))
+	server.Get("/user", func(ctx *web.Context) {})
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/user", nil))
+	// 限流器出错的时候放行
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Empty(t, resp.Header().Get("RateLimit-Limit"))
+}
+
+func withHeader(req *http.Request, key, val string) *http.Request {
+	req.Header.Set(key, val)
+	return req
+}
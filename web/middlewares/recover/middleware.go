@@ -1,26 +1,99 @@
 package recover
 
-import "web"
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"web"
+)
 
 type MiddlewareBuilder struct {
 	StatusCode int
 	Data       []byte
-	//Log        func(err any)
-	Log func(ctx *web.Context)
-	//log        func(stack string)
+	// Log 记录 panic，val 是 panic 的值，stack 是去掉了 recover 本身的调用栈
+	// 默认通过 ctx.Logger() 输出
+	Log func(ctx *web.Context, val any, stack string)
+	// AsError 为 true 的时候不会写入 Data，而是把 panic 转换成 PanicError 放到 ctx.Err
+	// 交给外层的错误处理，例如 errorhandler 来渲染响应
+	AsError bool
+}
+
+// PanicError 代表处理请求的时候发生了 panic
+// 在别的 goroutine 里面 panic 的时候，也可以带上那个 goroutine 的调用栈，用它再次 panic
+type PanicError struct {
+	Value any
+	Stack string
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("web: panic: %v", e.Value)
+}
+
+// Unwrap 如果 panic 的值本身就是 error，那么可以用 errors.Is 之类的判断
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
 }
 
 func (m *MiddlewareBuilder) Build() web.Middleware {
+	if m.Log == nil {
+		m.Log = func(ctx *web.Context, val any, stack string) {
+			ctx.Logger().Error("web: panic", slog.String("path", ctx.Req.URL.Path),
+				slog.Any("panic", val), slog.String("stack", stack))
+		}
+	}
+	if m.StatusCode == 0 {
+		m.StatusCode = http.StatusInternalServerError
+	}
 	return func(next web.HandleFunc) web.HandleFunc {
 		return func(ctx *web.Context) {
 			defer func() {
-				if err := recover(); err != nil {
-					ctx.RespStatusCode = m.StatusCode
-					ctx.RespData = m.Data
-					m.Log(ctx)
+				val := recover()
+				if val == nil {
+					return
+				}
+				stack := ""
+				// 例如 timeout middleware 把别的 goroutine 里面的 panic 转发过来，要使用原本的调用栈
+				if pe, ok := val.(*PanicError); ok {
+					val, stack = pe.Value, trimStack([]byte(pe.Stack))
 				}
+				// http.ErrAbortHandler 是用户主动中断请求，net/http 会静默处理，继续往上抛
+				if err, ok := val.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+					panic(val)
+				}
+				web.RecordPanic()
+				if stack == "" {
+					stack = trimStack(debug.Stack())
+				}
+				m.Log(ctx, val, stack)
+
+				ctx.RespStatusCode = m.StatusCode
+				if m.AsError {
+					ctx.RespData = nil
+					ctx.Err = &PanicError{Value: val, Stack: stack}
+					return
+				}
+				ctx.RespData = m.Data
 			}()
 			next(ctx)
 		}
 	}
 }
+
+// trimStack 去掉 debug.Stack、recover 和 runtime 本身的栈帧，只保留发生 panic 的位置往上的部分
+// 每个栈帧占两行，第一行是函数，第二行是文件
+func trimStack(stack []byte) string {
+	lines := strings.Split(strings.TrimSpace(string(stack)), "\n")
+	if len(lines) == 0 {
+		return ""
+	}
+	for i := 1; i+1 < len(lines); i += 2 {
+		if strings.HasPrefix(lines[i], "panic(") {
+			return strings.Join(append([]string{lines[0]}, lines[i+2:]...), "\n")
+		}
+	}
+	return strings.Join(lines, "\n")
+}
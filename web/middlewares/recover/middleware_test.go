@@ -1,24 +1,93 @@
 package recover
 
 import (
+	"errors"
 	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"web"
+	"web/middlewares/errorhandler"
+	"web/middlewares/requestid"
 )
 
 func TestMiddlewareBuilder_Build(t *testing.T) {
+	var logged, stack string
 	builder := MiddlewareBuilder{
 		StatusCode: 500,
 		Data:       []byte("server error"),
-		Log: func(ctx *web.Context) {
-			fmt.Printf("panic 路径：%s", ctx.Req.URL.String())
+		Log: func(ctx *web.Context, val any, s string) {
+			logged = fmt.Sprintf("panic 路径：%s, request id: %s, %v", ctx.Req.URL.String(), ctx.RequestID, val)
+			stack = s
 		},
 	}
-	server := web.NewHttpServer(web.ServerWithMiddleware(builder.Build()))
+	server := web.NewHttpServer(web.ServerWithMiddleware(
+		requestid.NewMiddlewareBuilder().Build(), builder.Build()))
 
 	server.Get("/user", func(ctx *web.Context) {
 		panic("user error")
 	})
 
-	server.Start(":8081")
+	req, err := http.NewRequest(http.MethodGet, "/user", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(requestid.DefaultHeader, "abc-123")
+	recorder := httptest.NewRecorder()
+	before := web.PanicsRecovered()
+	server.ServeHTTP(recorder, req)
+
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+	assert.Equal(t, "server error", recorder.Body.String())
+	assert.Equal(t, "panic 路径：/user, request id: abc-123, user error", logged)
+	assert.Equal(t, before+1, web.PanicsRecovered())
+	// 栈是从发生 panic 的地方开始的
+	assert.Regexp(t, `^goroutine \d+ \[running\]:\nweb/middlewares/recover.TestMiddlewareBuilder_Build.func2`, stack)
+	assert.NotContains(t, stack, "runtime/debug.Stack")
+}
+
+func TestMiddlewareBuilder_AsError(t *testing.T) {
+	var handledErr error
+	builder := &MiddlewareBuilder{AsError: true, Log: func(ctx *web.Context, val any, stack string) {}}
+	server := web.NewHttpServer(web.ServerWithMiddleware(
+		errorhandler.NewMiddlewareBuilder().AddCode(http.StatusInternalServerError, []byte("error page")).Build(),
+		func(next web.HandleFunc) web.HandleFunc {
+			return func(ctx *web.Context) {
+				next(ctx)
+				handledErr = ctx.Err
+			}
+		},
+		builder.Build()))
+	errBiz := errors.New("biz error")
+	server.Get("/user", func(ctx *web.Context) {
+		panic(errBiz)
+	})
+
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/user", nil))
+
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+	assert.Equal(t, "error page", recorder.Body.String())
+	var panicErr *PanicError
+	require.ErrorAs(t, handledErr, &panicErr)
+	assert.Equal(t, errBiz, panicErr.Value)
+	assert.ErrorIs(t, handledErr, errBiz)
+}
+
+func TestMiddlewareBuilder_ErrAbortHandler(t *testing.T) {
+	logged := false
+	builder := &MiddlewareBuilder{Log: func(ctx *web.Context, val any, stack string) {
+		logged = true
+	}}
+	server := web.NewHttpServer(web.ServerWithMiddleware(builder.Build()))
+	server.Get("/user", func(ctx *web.Context) {
+		panic(http.ErrAbortHandler)
+	})
+
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/user", nil))
+	})
+	assert.False(t, logged)
 }
@@ -0,0 +1,82 @@
+package requestid
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+	"web"
+)
+
+// DefaultHeader 默认使用的请求头
+const DefaultHeader = "X-Request-ID"
+
+// 默认只接受字母、数字和 -_.: 这几个符号，长度不超过 128
+// 防止客户端塞进来一些奇奇怪怪的东西，污染日志
+var defaultPattern = regexp.MustCompile(`^[A-Za-z0-9\-_.:]{1,128}$`)
+
+type MiddlewareBuilder struct {
+	header    string
+	validator func(id string) bool
+	generator func() string
+}
+
+func NewMiddlewareBuilder() *MiddlewareBuilder {
+	return &MiddlewareBuilder{
+		header:    DefaultHeader,
+		validator: defaultPattern.MatchString,
+		generator: newID,
+	}
+}
+
+// Header 设置读取和回写 request id 的头部
+func (m *MiddlewareBuilder) Header(header string) *MiddlewareBuilder {
+	m.header = header
+	return m
+}
+
+// Validator 校验客户端传过来的 request id，校验不通过就重新生成一个
+func (m *MiddlewareBuilder) Validator(fn func(id string) bool) *MiddlewareBuilder {
+	m.validator = fn
+	return m
+}
+
+// Generator 生成 request id
+func (m *MiddlewareBuilder) Generator(fn func() string) *MiddlewareBuilder {
+	m.generator = fn
+	return m
+}
+
+func (m *MiddlewareBuilder) Build() web.Middleware {
+	return func(next web.HandleFunc) web.HandleFunc {
+		return func(ctx *web.Context) {
+			id := ctx.Req.Header.Get(m.header)
+			if id == "" || !m.validator(id) {
+				id = m.generator()
+			}
+			ctx.RequestID = id
+			// 响应头必须在写入响应之前设置，所以放在 next 前面
+			ctx.Resp.Header().Set(m.header, id)
+			next(ctx)
+		}
+	}
+}
+
+// newID 生成一个 UUID v4 格式的 id
+func newID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	var buf [36]byte
+	hex.Encode(buf[0:8], b[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], b[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], b[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], b[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], b[10:])
+	return string(buf[:])
+}
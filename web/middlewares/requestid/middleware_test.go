@@ -0,0 +1,70 @@
+package requestid
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"web"
+)
+
+func TestMiddlewareBuilder_Build(t *testing.T) {
+	testCases := []struct {
+		name   string
+		header string
+		reqID  string
+		// 为空说明要生成一个新的
+		wantID string
+	}{
+		{
+			name:   "generate",
+			header: DefaultHeader,
+		},
+		{
+			name:   "propagate",
+			header: DefaultHeader,
+			reqID:  "abc-123",
+			wantID: "abc-123",
+		},
+		{
+			name:   "invalid",
+			header: DefaultHeader,
+			reqID:  "abc\n123",
+		},
+		{
+			name:   "custom header",
+			header: "X-Trace-Id",
+			reqID:  "abc-123",
+			wantID: "abc-123",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			builder := NewMiddlewareBuilder().Header(tc.header)
+			server := web.NewHttpServer(web.ServerWithMiddleware(builder.Build()))
+			var got string
+			server.Get("/user", func(ctx *web.Context) {
+				got = ctx.RequestID
+			})
+
+			req, err := http.NewRequest(http.MethodGet, "/user", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.reqID != "" {
+				req.Header.Set(tc.header, tc.reqID)
+			}
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, req)
+
+			if tc.wantID != "" {
+				assert.Equal(t, tc.wantID, got)
+			} else {
+				assert.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, got)
+				assert.NotEqual(t, tc.reqID, got)
+			}
+			assert.Equal(t, got, recorder.Header().Get(tc.header))
+		})
+	}
+}
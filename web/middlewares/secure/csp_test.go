@@ -0,0 +1,32 @@
+package secure
+
+import (
+	"encoding/base64"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestCSP_String(t *testing.T) {
+	csp := NewCSP().
+		DefaultSrc("'self'").
+		ScriptSrc("'self'", Nonce).
+		Directive("SCRIPT-SRC", "https://cdn.example.com").
+		FrameAncestors("'none'").
+		Directive("upgrade-insecure-requests")
+	assert.Equal(t, "default-src 'self'; script-src 'self' 'nonce-{nonce}' https://cdn.example.com; "+
+		"frame-ancestors 'none'; upgrade-insecure-requests", csp.String())
+	assert.Equal(t, "Content-Security-Policy", csp.headerName())
+	assert.Equal(t, "Content-Security-Policy-Report-Only", csp.ReportOnly().headerName())
+}
+
+func TestNewNonce(t *testing.T) {
+	a, err := newNonce()
+	require.NoError(t, err)
+	b, err := newNonce()
+	require.NoError(t, err)
+	assert.NotEqual(t, a, b)
+	raw, err := base64.StdEncoding.DecodeString(a)
+	require.NoError(t, err)
+	assert.Len(t, raw, 16)
+}
@@ -0,0 +1,176 @@
+package secure
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"web"
+)
+
+type MiddlewareBuilder struct {
+	hsts               string
+	frameOptions       string
+	contentTypeOptions string
+	referrerPolicy     string
+	permissionsPolicy  string
+
+	csp        *CSP
+	cspHeader  string
+	cspPolicy  string
+	cspByNonce bool
+
+	redirectHTTPS  bool
+	redirectStatus int
+	// redirectHosts 允许重定向的 Host，已经转成了小写
+	redirectHosts map[string]bool
+}
+
+// NewMiddlewareBuilder 默认设置比较保守的安全响应头
+// HSTS 一年并且包括子域名，X-Frame-Options 是 SAMEORIGIN，X-Content-Type-Options 是 nosniff
+// Referrer-Policy 是 strict-origin-when-cross-origin，默认不设置 Permissions-Policy 和 CSP
+// 响应头是在执行业务之前设置的，业务可以覆盖，例如某个页面允许被嵌入
+func NewMiddlewareBuilder() *MiddlewareBuilder {
+	return (&MiddlewareBuilder{
+		frameOptions:       "SAMEORIGIN",
+		contentTypeOptions: "nosniff",
+		referrerPolicy:     "strict-origin-when-cross-origin",
+		redirectStatus:     http.StatusPermanentRedirect,
+	}).HSTS(365*24*time.Hour, true, false)
+}
+
+// HSTS 只会在 HTTPS 的请求上设置，RFC 6797 7.2
+// maxAge 小于等于 0 的时候不设置，preload 要求 maxAge 至少一年并且包括子域名
+func (m *MiddlewareBuilder) HSTS(maxAge time.Duration, includeSubDomains, preload bool) *MiddlewareBuilder {
+	if maxAge <= 0 {
+		m.hsts = ""
+		return m
+	}
+	hsts := "max-age=" + strconv.FormatInt(int64(maxAge/time.Second), 10)
+	if includeSubDomains {
+		hsts += "; includeSubDomains"
+	}
+	if preload {
+		hsts += "; preload"
+	}
+	m.hsts = hsts
+	return m
+}
+
+// FrameOptions X-Frame-Options，DENY 或者 SAMEORIGIN，空字符串代表不设置
+func (m *MiddlewareBuilder) FrameOptions(val string) *MiddlewareBuilder {
+	m.frameOptions = val
+	return m
+}
+
+// ContentTypeOptions X-Content-Type-Options，空字符串代表不设置
+func (m *MiddlewareBuilder) ContentTypeOptions(val string) *MiddlewareBuilder {
+	m.contentTypeOptions = val
+	return m
+}
+
+// ReferrerPolicy 空字符串代表不设置
+func (m *MiddlewareBuilder) ReferrerPolicy(val string) *MiddlewareBuilder {
+	m.referrerPolicy = val
+	return m
+}
+
+// PermissionsPolicy 例如 "camera=(), geolocation=(self)"
+func (m *MiddlewareBuilder) PermissionsPolicy(val string) *MiddlewareBuilder {
+	m.permissionsPolicy = val
+	return m
+}
+
+// ContentSecurityPolicy 使用了 Nonce 的时候，每个请求都会生成新的 nonce，并且设置到 ctx.CSPNonce
+func (m *MiddlewareBuilder) ContentSecurityPolicy(csp *CSP) *MiddlewareBuilder {
+	m.csp = csp
+	return m
+}
+
+// RedirectHTTPS 把 HTTP 请求重定向到 HTTPS，status 为 0 的时候使用 308，这样 POST 之类的请求不会变成 GET
+// Host 可以被客户端或者代理前面的请求伪造，所以只会重定向到 hosts 里面的，其余的返回 400，避免变成开放重定向
+// 在代理后面的时候，要通过 web.ServerWithTrustedProxies 信任代理，才会使用代理传过来的协议和 Host
+func (m *MiddlewareBuilder) RedirectHTTPS(status int, hosts ...string) *MiddlewareBuilder {
+	if len(hosts) == 0 {
+		panic("secure: RedirectHTTPS 至少需要一个允许重定向的 Host")
+	}
+	m.redirectHTTPS = true
+	if status != 0 {
+		m.redirectStatus = status
+	}
+	m.redirectHosts = make(map[string]bool, len(hosts))
+	for _, host := range hosts {
+		m.redirectHosts[strings.ToLower(host)] = true
+	}
+	return m
+}
+
+func (m *MiddlewareBuilder) Build() web.Middleware {
+	if m.csp != nil {
+		m.cspHeader = m.csp.headerName()
+		m.cspPolicy = m.csp.String()
+		m.cspByNonce = strings.Contains(m.cspPolicy, noncePlaceholder)
+	}
+	return func(next web.HandleFunc) web.HandleFunc {
+		return func(ctx *web.Context) {
+			https := ctx.Scheme() == "https"
+			if m.redirectHTTPS && !https {
+				m.redirect(ctx)
+				return
+			}
+
+			header := ctx.Resp.Header()
+			if https && m.hsts != "" {
+				header.Set("Strict-Transport-Security", m.hsts)
+			}
+			setIfNotEmpty(header, "X-Frame-Options", m.frameOptions)
+			setIfNotEmpty(header, "X-Content-Type-Options", m.contentTypeOptions)
+			setIfNotEmpty(header, "Referrer-Policy", m.referrerPolicy)
+			setIfNotEmpty(header, "Permissions-Policy", m.permissionsPolicy)
+			if m.cspPolicy != "" {
+				policy := m.cspPolicy
+				if m.cspByNonce {
+					nonce, err := newNonce()
+					if err != nil {
+						ctx.RespStatusCode = http.StatusInternalServerError
+						ctx.Err = err
+						return
+					}
+					ctx.CSPNonce = nonce
+					policy = strings.ReplaceAll(policy, noncePlaceholder, nonce)
+				}
+				header.Set(m.cspHeader, policy)
+			}
+			next(ctx)
+		}
+	}
+}
+
+// redirect 去掉 HTTP 的端口，使用 HTTPS 的默认端口
+func (m *MiddlewareBuilder) redirect(ctx *web.Context) {
+	host := ctx.Host()
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"))
+	if !m.redirectHosts[host] {
+		p := web.NewProblem(http.StatusBadRequest)
+		p.Detail = "不允许的 Host"
+		ctx.RespStatusCode = http.StatusBadRequest
+		ctx.Err = p
+		return
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	target := "https://" + host + ctx.Req.URL.RequestURI()
+	ctx.Resp.Header().Set("Location", target)
+	ctx.RespStatusCode = m.redirectStatus
+}
+
+func setIfNotEmpty(header http.Header, name, val string) {
+	if val != "" {
+		header.Set(name, val)
+	}
+}
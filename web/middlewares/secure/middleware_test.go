@@ -0,0 +1,260 @@
+package secure
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"web"
+)
+
+func TestMiddlewareBuilder_Headers(t *testing.T) {
+	testCases := []struct {
+		name       string
+		builder    *MiddlewareBuilder
+		https      bool
+		wantHeader http.Header
+	}{
+		{
+			name:    "default http",
+			builder: NewMiddlewareBuilder(),
+			wantHeader: http.Header{
+				"X-Frame-Options":        {"SAMEORIGIN"},
+				"X-Content-Type-Options": {"nosniff"},
+				"Referrer-Policy":        {"strict-origin-when-cross-origin"},
+			},
+		},
+		{
+			// HSTS 只在 HTTPS 上生效
+			name:    "default https",
+			builder: NewMiddlewareBuilder(),
+			https:   true,
+			wantHeader: http.Header{
+				"Strict-Transport-Security": {"max-age=31536000; includeSubDomains"},
+				"X-Frame-Options":           {"SAMEORIGIN"},
+				"X-Content-Type-Options":    {"nosniff"},
+				"Referrer-Policy":           {"strict-origin-when-cross-origin"},
+			},
+		},
+		{
+			name: "custom",
+			builder: NewMiddlewareBuilder().
+				HSTS(2*365*24*time.Hour, true, true).
+				FrameOptions("DENY").
+				ContentTypeOptions("").
+				ReferrerPolicy("no-referrer").
+				PermissionsPolicy("camera=(), geolocation=(self)").
+				ContentSecurityPolicy(NewCSP().DefaultSrc("'self'")),
+			https: true,
+			wantHeader: http.Header{
+				"Strict-Transport-Security": {"max-age=63072000; includeSubDomains; preload"},
+				"X-Frame-Options":           {"DENY"},
+				"Referrer-Policy":           {"no-referrer"},
+				"Permissions-Policy":        {"camera=(), geolocation=(self)"},
+				"Content-Security-Policy":   {"default-src 'self'"},
+			},
+		},
+		{
+			name:    "disable hsts",
+			builder: NewMiddlewareBuilder().HSTS(0, false, false).FrameOptions("").ReferrerPolicy(""),
+			https:   true,
+			wantHeader: http.Header{
+				"X-Content-Type-Options": {"nosniff"},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := web.NewHttpServer(web.ServerWithMiddleware(tc.builder.Build()))
+			server.Get("/", func(ctx *web.Context) {
+				ctx.RespData = []byte("ok")
+			})
+			tc.wantHeader.Set("Content-Type", "text/plain; charset=utf-8")
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.https {
+				req.TLS = &tls.ConnectionState{}
+			}
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, req)
+			assert.Equal(t, tc.wantHeader, resp.Header())
+		})
+	}
+}
+
+func TestMiddlewareBuilder_Override(t *testing.T) {
+	server := web.NewHttpServer(web.ServerWithMiddleware(NewMiddlewareBuilder().Build()))
+	server.Get("/embed", func(ctx *web.Context) {
+		// 业务可以覆盖默认的响应头
+		ctx.Resp.Header().Del("X-Frame-Options")
+	})
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/embed", nil))
+	assert.Empty(t, resp.Header().Get("X-Frame-Options"))
+	assert.Equal(t, "nosniff", resp.Header().Get("X-Content-Type-Options"))
+}
+
+type nonceEngine struct{}
+
+func (nonceEngine) Render(ctx context.Context, tplName string, data any) ([]byte, error) {
+	return []byte(web.CSPNonceFromContext(ctx)), nil
+}
+
+func TestMiddlewareBuilder_Nonce(t *testing.T) {
+	csp := NewCSP().DefaultSrc("'self'").ScriptSrc("'self'", Nonce).StyleSrc("'self'", Nonce)
+	server := web.NewHttpServer(
+		web.ServerWithTemplateEngine(nonceEngine{}),
+		web.ServerWithMiddleware(NewMiddlewareBuilder().ContentSecurityPolicy(csp.ReportOnly()).Build()))
+	server.Get("/page", func(ctx *web.Context) {
+		_ = ctx.Render("page", nil)
+	})
+
+	var nonces []string
+	for i := 0; i < 2; i++ {
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/page", nil))
+		nonce := resp.Body.String()
+		assert.NotEmpty(t, nonce)
+		assert.Empty(t, resp.Header().Get("Content-Security-Policy"))
+		assert.Equal(t, "default-src 'self'; script-src 'self' 'nonce-"+nonce+"'; style-src 'self' 'nonce-"+nonce+"'",
+			resp.Header().Get("Content-Security-Policy-Report-Only"))
+		nonces = append(nonces, nonce)
+	}
+	// 每个请求都不一样
+	assert.NotEqual(t, nonces[0], nonces[1])
+}
+
+func TestMiddlewareBuilder_NoNonce(t *testing.T) {
+	server := web.NewHttpServer(web.ServerWithMiddleware(
+		NewMiddlewareBuilder().ContentSecurityPolicy(NewCSP().DefaultSrc("'self'")).Build()))
+	server.Get("/page", func(ctx *web.Context) {
+		// 策略里面没有使用 nonce 的时候不会生成
+		assert.Empty(t, ctx.CSPNonce)
+	})
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/page", nil))
+}
+
+func TestMiddlewareBuilder_RedirectHTTPS(t *testing.T) {
+	testCases := []struct {
+		name         string
+		status       int
+		target       string
+		remoteAddr   string
+		header       http.Header
+		tls          bool
+		wantStatus   int
+		wantLocation string
+	}{
+		{
+			name:         "http",
+			target:       "http://example.com:8080/users?id=1",
+			wantStatus:   http.StatusPermanentRedirect,
+			wantLocation: "https://example.com/users?id=1",
+		},
+		{
+			name:         "ipv6",
+			target:       "http://[::1]:8080/users",
+			wantStatus:   http.StatusPermanentRedirect,
+			wantLocation: "https://[::1]/users",
+		},
+		{
+			name:         "custom status",
+			status:       http.StatusMovedPermanently,
+			target:       "http://example.com/users",
+			wantStatus:   http.StatusMovedPermanently,
+			wantLocation: "https://example.com/users",
+		},
+		{
+			name:       "https",
+			target:     "https://example.com/users",
+			tls:        true,
+			wantStatus: http.StatusOK,
+		},
+		{
+			// 可信代理已经终止了 TLS
+			name:       "trusted proxy https",
+			target:     "http://internal/users",
+			remoteAddr: "10.0.0.1:1234",
+			header: http.Header{
+				"X-Forwarded-Proto": {"https"},
+				"X-Forwarded-Host":  {"example.com"},
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "trusted proxy http",
+			target:     "http://internal/users",
+			remoteAddr: "10.0.0.1:1234",
+			header: http.Header{
+				"X-Forwarded-Proto": {"http"},
+				"X-Forwarded-Host":  {"example.com"},
+			},
+			wantStatus:   http.StatusPermanentRedirect,
+			wantLocation: "https://example.com/users",
+		},
+		{
+			name:       "host not allowed",
+			target:     "http://evil.com/users",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			// 可信代理传过来的 Host 也要校验
+			name:       "proxy host not allowed",
+			target:     "http://internal/users",
+			remoteAddr: "10.0.0.1:1234",
+			header: http.Header{
+				"X-Forwarded-Proto": {"http"},
+				"X-Forwarded-Host":  {"evil.com"},
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			// 不可信的来源伪造的头部会被忽略
+			name:       "untrusted proxy",
+			target:     "http://example.com/users",
+			remoteAddr: "1.2.3.4:1234",
+			header: http.Header{
+				"X-Forwarded-Proto": {"https"},
+			},
+			wantStatus:   http.StatusPermanentRedirect,
+			wantLocation: "https://example.com/users",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := web.NewHttpServer(
+				web.ServerWithTrustedProxies("10.0.0.0/8"),
+				web.ServerWithMiddleware(NewMiddlewareBuilder().RedirectHTTPS(tc.status, "Example.com", "::1").Build()))
+			server.Post("/users", func(ctx *web.Context) {
+				ctx.RespData = []byte("ok")
+			})
+			req := httptest.NewRequest(http.MethodPost, tc.target, nil)
+			if tc.remoteAddr != "" {
+				req.RemoteAddr = tc.remoteAddr
+			}
+			if !tc.tls {
+				req.TLS = nil
+			}
+			for name, values := range tc.header {
+				req.Header[name] = values
+			}
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, req)
+			assert.Equal(t, tc.wantStatus, resp.Code)
+			assert.Equal(t, tc.wantLocation, resp.Header().Get("Location"))
+			if tc.wantStatus == http.StatusOK {
+				assert.Equal(t, "ok", resp.Body.String())
+				assert.True(t, strings.HasPrefix(resp.Header().Get("Strict-Transport-Security"), "max-age="))
+			}
+		})
+	}
+}
+
+func TestMiddlewareBuilder_RedirectHTTPSNoHosts(t *testing.T) {
+	assert.Panics(t, func() {
+		NewMiddlewareBuilder().RedirectHTTPS(0)
+	})
+}
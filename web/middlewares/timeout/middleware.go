@@ -0,0 +1,240 @@
+package timeout
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"maps"
+	"net/http"
+	"path"
+	"runtime/debug"
+	"sync"
+	"time"
+	"web"
+	webrecover "web/middlewares/recover"
+)
+
+// StatusClientClosedRequest 客户端在处理完之前断开了连接，沿用 nginx 的 499
+// 只用于日志和监控，客户端已经收不到了
+const StatusClientClosedRequest = 499
+
+type MiddlewareBuilder struct {
+	timeout    time.Duration
+	routes     []routeTimeout
+	statusCode int
+	body       []byte
+}
+
+type routeTimeout struct {
+	pattern string
+	timeout time.Duration
+}
+
+// NewMiddlewareBuilder 超时之后默认返回 503，和 http.TimeoutHandler 一致
+func NewMiddlewareBuilder(timeout time.Duration) *MiddlewareBuilder {
+	return &MiddlewareBuilder{
+		timeout:    timeout,
+		statusCode: http.StatusServiceUnavailable,
+	}
+}
+
+// RouteTimeout 单独设置某些路由的超时时间，支持 path.Match 的语法，先添加的优先
+// timeout 小于等于 0 的时候不限制，例如文件下载、SSE 之类的长连接
+func (m *MiddlewareBuilder) RouteTimeout(pattern string, timeout time.Duration) *MiddlewareBuilder {
+	m.routes = append(m.routes, routeTimeout{pattern: pattern, timeout: timeout})
+	return m
+}
+
+// StatusCode 超时的响应码，例如作为网关的时候可以使用 504
+func (m *MiddlewareBuilder) StatusCode(status int) *MiddlewareBuilder {
+	m.statusCode = status
+	return m
+}
+
+// Body 超时的响应，为空的时候交给 errorhandler 之类的处理，ctx.Err 是 context.DeadlineExceeded
+func (m *MiddlewareBuilder) Body(body []byte) *MiddlewareBuilder {
+	m.body = body
+	return m
+}
+
+func (m *MiddlewareBuilder) Build() web.Middleware {
+	return func(next web.HandleFunc) web.HandleFunc {
+		return func(ctx *web.Context) {
+			timeout := m.timeoutOf(ctx.MatchedRoute)
+			if timeout <= 0 {
+				next(ctx)
+				return
+			}
+			req := ctx.Req
+			reqCtx, cancel := context.WithTimeout(req.Context(), timeout)
+			defer cancel()
+
+			// 超时之后业务代码可能还在运行，所以让它操作一个副本
+			// 这样就不会和后面的 flashResp 产生并发问题
+			tw := &timeoutWriter{header: ctx.Resp.Header().Clone()}
+			inner := *ctx
+			innerReq := req.WithContext(reqCtx)
+			inner.Req = innerReq
+			inner.Resp = tw
+			inner.UserValues = maps.Clone(ctx.UserValues)
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				defer tw.finish(&inner, reqCtx)
+				next(&inner)
+			}()
+
+			select {
+			case <-done:
+			case <-reqCtx.Done():
+			}
+
+			// 两个 case 同时满足的时候 select 是随机选的，所以要以 finish 记录的为准
+			tw.mutex.Lock()
+			if !tw.finished {
+				// 业务还没有处理完，后面的写入都会失败
+				tw.timedOut = true
+			}
+			timedOut := tw.timedOut
+			tw.mutex.Unlock()
+			if timedOut {
+				m.abandon(ctx, reqCtx)
+				return
+			}
+			if tw.panicVal != nil {
+				// 交给外面的 recover middleware 处理
+				panic(tw.panicVal)
+			}
+
+			resp := ctx.Resp
+			*ctx = inner
+			ctx.Resp = resp
+			ctx.Req = req
+			if inner.Req != innerReq {
+				// 后面的 middleware 换了 Req，例如往 context 里面放了数据，要保留下来
+				// 但是超时的 context 马上就要取消了，所以取消和超时要用原本的
+				ctx.Req = inner.Req.WithContext(valuesContext{Context: req.Context(), values: inner.Req.Context()})
+			}
+			tw.flush(resp)
+		}
+	}
+}
+
+// abandon 不再等待业务，超时返回 StatusCode，客户端断开的时候不能算是服务端的问题
+func (m *MiddlewareBuilder) abandon(ctx *web.Context, reqCtx context.Context) {
+	ctx.Err = reqCtx.Err()
+	if !errors.Is(ctx.Err, context.DeadlineExceeded) {
+		ctx.RespStatusCode = StatusClientClosedRequest
+		ctx.RespData = nil
+		return
+	}
+	ctx.RespStatusCode = m.statusCode
+	ctx.RespData = m.body
+}
+
+func (m *MiddlewareBuilder) timeoutOf(route string) time.Duration {
+	for _, r := range m.routes {
+		if r.pattern == route {
+			return r.timeout
+		}
+		if ok, _ := path.Match(r.pattern, route); ok {
+			return r.timeout
+		}
+	}
+	return m.timeout
+}
+
+// timeoutWriter 缓存业务直接写入的响应，没有超时的时候再写到真正的 ResponseWriter
+type timeoutWriter struct {
+	mutex    sync.Mutex
+	header   http.Header
+	buf      bytes.Buffer
+	status   int
+	timedOut bool
+	// finished 业务已经处理完了，在超时之后才处理完的也算超时
+	finished bool
+	// panicVal 业务 panic 了，带上了业务 goroutine 的调用栈
+	panicVal any
+}
+
+// finish 在业务的 goroutine 里面执行，记录结果
+// 已经超时的时候没有人会处理 panic 了，只能打日志
+func (w *timeoutWriter) finish(inner *web.Context, reqCtx context.Context) {
+	val := recover()
+	var panicVal any
+	var stack string
+	if val != nil {
+		stack = string(debug.Stack())
+		panicVal = val
+		// net/http 会静默处理这个 panic，要保持原样
+		if err, ok := val.(error); !ok || !errors.Is(err, http.ErrAbortHandler) {
+			panicVal = &webrecover.PanicError{Value: val, Stack: stack}
+		}
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.finished = true
+	if reqCtx.Err() != nil {
+		w.timedOut = true
+	}
+	if !w.timedOut {
+		w.panicVal = panicVal
+		return
+	}
+	if val != nil {
+		inner.Logger().Error("timeout: 超时之后业务 panic", slog.Any("panic", val), slog.String("stack", stack))
+	}
+}
+
+func (w *timeoutWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *timeoutWriter) Write(data []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	return w.buf.Write(data)
+}
+
+func (w *timeoutWriter) WriteHeader(status int) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.timedOut || w.status != 0 {
+		return
+	}
+	w.status = status
+}
+
+// flush 业务在超时之前处理完了，把缓存的头部和数据写到 resp
+func (w *timeoutWriter) flush(resp http.ResponseWriter) {
+	dst := resp.Header()
+	for key := range dst {
+		if _, ok := w.header[key]; !ok {
+			dst.Del(key)
+		}
+	}
+	for key, vals := range w.header {
+		dst[key] = vals
+	}
+	if w.status != 0 {
+		resp.WriteHeader(w.status)
+	}
+	if w.buf.Len() > 0 {
+		_, _ = resp.Write(w.buf.Bytes())
+	}
+}
+
+// valuesContext 取消和超时使用 Context，数据使用 values
+type valuesContext struct {
+	context.Context
+	values context.Context
+}
+
+func (c valuesContext) Value(key any) any {
+	return c.values.Value(key)
+}
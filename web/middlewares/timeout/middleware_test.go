@@ -0,0 +1,242 @@
+package timeout
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"web"
+	"web/middlewares/recover"
+)
+
+func TestMiddlewareBuilder_Build(t *testing.T) {
+	// late 在超时之后写入的结果
+	late := make(chan error, 1)
+	// deadline 业务能够感知到超时
+	deadline := make(chan error, 1)
+	builder := NewMiddlewareBuilder(50*time.Millisecond).
+		RouteTimeout("/slow/long", time.Second).
+		RouteTimeout("/stream/*", 0)
+	server := web.NewHttpServer(web.ServerWithMiddleware(
+		func(next web.HandleFunc) web.HandleFunc {
+			return func(ctx *web.Context) {
+				ctx.Resp.Header().Set("X-Outer", "outer")
+				next(ctx)
+			}
+		},
+		builder.Build()))
+
+	server.Get("/fast", func(ctx *web.Context) {
+		ctx.Resp.Header().Set("X-Inner", "inner")
+		ctx.Resp.Header().Del("X-Outer")
+		ctx.RespStatusCode = http.StatusCreated
+		ctx.RespData = []byte("hello")
+	})
+	slow := func(ctx *web.Context) {
+		time.Sleep(100 * time.Millisecond)
+		ctx.Resp.Header().Set("X-Inner", "inner")
+		_, err := ctx.Resp.Write([]byte("late"))
+		ctx.RespData = []byte("late")
+		deadline <- ctx.Req.Context().Err()
+		late <- err
+	}
+	server.Get("/slow", slow)
+	server.Get("/slow/long", slow)
+	server.Get("/stream/events", func(ctx *web.Context) {
+		_, ok := ctx.Req.Context().Deadline()
+		assert.False(t, ok)
+	})
+
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/fast", nil))
+	assert.Equal(t, http.StatusCreated, resp.Code)
+	assert.Equal(t, "hello", resp.Body.String())
+	assert.Equal(t, "inner", resp.Header().Get("X-Inner"))
+	assert.Empty(t, resp.Header().Get("X-Outer"))
+
+	resp = httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, resp.Code)
+	assert.Empty(t, resp.Body.String())
+	assert.Equal(t, "outer", resp.Header().Get("X-Outer"))
+	// 超时之后业务写入的数据都被丢弃了
+	assert.Equal(t, http.ErrHandlerTimeout, <-late)
+	assert.Equal(t, context.DeadlineExceeded, <-deadline)
+	assert.Empty(t, resp.Header().Get("X-Inner"))
+	assert.Empty(t, resp.Body.String())
+
+	// 单独设置了更长的超时时间
+	resp = httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/slow/long", nil))
+	assert.NoError(t, <-late)
+	assert.NoError(t, <-deadline)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "latelate", resp.Body.String())
+
+	resp = httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/stream/events", nil))
+	assert.Equal(t, http.StatusOK, resp.Code)
+}
+
+func TestMiddlewareBuilder_UserValues(t *testing.T) {
+	var user any
+	server := web.NewHttpServer(web.ServerWithMiddleware(
+		func(next web.HandleFunc) web.HandleFunc {
+			return func(ctx *web.Context) {
+				ctx.SetUserValue("tenant", "a")
+				next(ctx)
+				user, _ = ctx.UserValue("user")
+			}
+		},
+		NewMiddlewareBuilder(time.Second).Build()))
+	server.Get("/user", func(ctx *web.Context) {
+		tenant, _ := ctx.UserValue("tenant")
+		assert.Equal(t, "a", tenant)
+		ctx.SetUserValue("user", "tom")
+	})
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/user", nil))
+	assert.Equal(t, "tom", user)
+}
+
+func TestMiddlewareBuilder_Response(t *testing.T) {
+	var err error
+	server := web.NewHttpServer(web.ServerWithMiddleware(
+		func(next web.HandleFunc) web.HandleFunc {
+			return func(ctx *web.Context) {
+				next(ctx)
+				err = ctx.Err
+			}
+		},
+		NewMiddlewareBuilder(10*time.Millisecond).
+			StatusCode(http.StatusGatewayTimeout).
+			Body([]byte("timeout")).Build()))
+	server.Get("/slow", func(ctx *web.Context) {
+		<-ctx.Req.Context().Done()
+	})
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	assert.Equal(t, http.StatusGatewayTimeout, resp.Code)
+	assert.Equal(t, "timeout", resp.Body.String())
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestMiddlewareBuilder_Panic(t *testing.T) {
+	var val any
+	var stack string
+	server := web.NewHttpServer(web.ServerWithMiddleware(
+		(&recover.MiddlewareBuilder{
+			Data: []byte("panic"),
+			Log: func(ctx *web.Context, v any, s string) {
+				val, stack = v, s
+			},
+		}).Build(),
+		NewMiddlewareBuilder(time.Second).Build()))
+	server.Get("/panic", func(ctx *web.Context) {
+		panic("abc")
+	})
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/panic", nil))
+	assert.Equal(t, http.StatusInternalServerError, resp.Code)
+	assert.Equal(t, "panic", resp.Body.String())
+	assert.Equal(t, "abc", val)
+	// 是业务 goroutine 的调用栈，而不是重新 panic 的地方
+	assert.Contains(t, stack, "TestMiddlewareBuilder_Panic.func2")
+	assert.NotContains(t, stack, "Build.func1.1(")
+}
+
+func TestMiddlewareBuilder_PanicAfterTimeout(t *testing.T) {
+	logs := &syncBuffer{}
+	logged := make(chan struct{})
+	server := web.NewHttpServer(
+		web.ServerWithLogger(slog.New(slog.NewTextHandler(logs, nil))),
+		web.ServerWithMiddleware(NewMiddlewareBuilder(20*time.Millisecond).Build()))
+	server.Get("/panic", func(ctx *web.Context) {
+		<-ctx.Req.Context().Done()
+		defer close(logged)
+		panic("late panic")
+	})
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/panic", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, resp.Code)
+	<-logged
+	// 超时之后的 panic 没有人处理，但是要打日志
+	assert.Eventually(t, func() bool {
+		return strings.Contains(logs.String(), "late panic")
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestMiddlewareBuilder_ClientClosed(t *testing.T) {
+	var err error
+	var status int
+	server := web.NewHttpServer(web.ServerWithMiddleware(
+		func(next web.HandleFunc) web.HandleFunc {
+			return func(ctx *web.Context) {
+				next(ctx)
+				err, status = ctx.Err, ctx.RespStatusCode
+			}
+		},
+		NewMiddlewareBuilder(time.Second).Build()))
+	server.Get("/slow", func(ctx *web.Context) {
+		<-ctx.Req.Context().Done()
+	})
+	reqCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest(http.MethodGet, "/slow", nil).WithContext(reqCtx)
+	server.ServeHTTP(httptest.NewRecorder(), req)
+	// 客户端断开不是服务端的问题
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, StatusClientClosedRequest, status)
+}
+
+type ctxKey struct{}
+
+func TestMiddlewareBuilder_KeepReq(t *testing.T) {
+	server := web.NewHttpServer(web.ServerWithMiddleware(
+		func(next web.HandleFunc) web.HandleFunc {
+			return func(ctx *web.Context) {
+				next(ctx)
+				// 后面的 middleware 放进去的数据还在，但是超时的 context 取消了也不影响
+				assert.Equal(t, "tom", ctx.Req.Context().Value(ctxKey{}))
+				assert.NoError(t, ctx.Req.Context().Err())
+				_, ok := ctx.Req.Context().Deadline()
+				assert.False(t, ok)
+			}
+		},
+		NewMiddlewareBuilder(time.Second).Build(),
+		func(next web.HandleFunc) web.HandleFunc {
+			return func(ctx *web.Context) {
+				ctx.Req = ctx.Req.WithContext(context.WithValue(ctx.Req.Context(), ctxKey{}, "tom"))
+				next(ctx)
+			}
+		}))
+	server.Get("/user", func(ctx *web.Context) {
+		ctx.RespData = []byte("ok")
+	})
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/user", nil))
+	assert.Equal(t, "ok", resp.Body.String())
+}
+
+// syncBuffer 日志是在业务的 goroutine 里面写的
+type syncBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (b *syncBuffer) Write(data []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(data)
+}
+
+func (b *syncBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.String()
+}
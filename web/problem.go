@@ -0,0 +1,166 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ProblemContentType RFC 9457 规定的 Content-Type
+const ProblemContentType = "application/problem+json"
+
+// Problem RFC 7807 / RFC 9457 定义的错误响应
+type Problem struct {
+	// Type 标识错误类型的 URI，默认是 about:blank
+	Type   string
+	Title  string
+	Status int
+	// Detail 给人看的详细描述，注意不要放内部错误
+	Detail   string
+	Instance string
+	// Extensions 扩展字段，会和上面的字段平铺在同一层
+	Extensions map[string]any
+}
+
+// NewProblem 创建一个 Problem，Title 是响应码对应的文本
+func NewProblem(status int) *Problem {
+	return &Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+	}
+}
+
+func (p *Problem) Error() string {
+	if p.Detail == "" {
+		return fmt.Sprintf("web: %d %s", p.Status, p.Title)
+	}
+	return fmt.Sprintf("web: %d %s: %s", p.Status, p.Title, p.Detail)
+}
+
+// With 添加扩展字段
+func (p *Problem) With(key string, val any) *Problem {
+	if p.Extensions == nil {
+		p.Extensions = map[string]any{}
+	}
+	p.Extensions[key] = val
+	return p
+}
+
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	res := make(map[string]any, len(p.Extensions)+5)
+	for key, val := range p.Extensions {
+		res[key] = val
+	}
+	typ := p.Type
+	if typ == "" {
+		typ = "about:blank"
+	}
+	res["type"] = typ
+	res["title"] = p.Title
+	res["status"] = p.Status
+	if p.Detail != "" {
+		res["detail"] = p.Detail
+	}
+	if p.Instance != "" {
+		res["instance"] = p.Instance
+	}
+	return json.Marshal(res)
+}
+
+// BindError 绑定请求参数失败，会被转换成 400
+type BindError struct {
+	Err error
+}
+
+func (e *BindError) Error() string {
+	return fmt.Sprintf("web: 绑定参数失败 %v", e.Err)
+}
+
+func (e *BindError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationError 参数校验失败，会被转换成 422
+// Fields 是字段名到错误信息的映射
+type ValidationError struct {
+	Fields map[string]string
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Fields))
+	for field, msg := range e.Fields {
+		msgs = append(msgs, field+": "+msg)
+	}
+	return "web: 参数校验失败 " + strings.Join(msgs, "; ")
+}
+
+// ProblemFromError 把 error 转换成 Problem
+// status 是兜底的响应码，小于 400 的时候使用 500
+// 未知的错误不会把错误信息放到 Detail 里面，避免泄露内部信息
+func ProblemFromError(err error, status int) *Problem {
+	var p *Problem
+	if errors.As(err, &p) {
+		return p
+	}
+	// 要放在 BindError 前面，BindJSON 读取超过限制的请求体的时候会返回包装了它的 BindError
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return NewProblem(http.StatusRequestEntityTooLarge)
+	}
+	var bindErr *BindError
+	if errors.As(err, &bindErr) {
+		p = NewProblem(http.StatusBadRequest)
+		p.Detail = bindErr.Err.Error()
+		return p
+	}
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		return NewProblem(http.StatusUnprocessableEntity).With("errors", validationErr.Fields)
+	}
+	if status < http.StatusBadRequest {
+		status = http.StatusInternalServerError
+	}
+	return NewProblem(status)
+}
+
+// RespProblem 返回 application/problem+json 格式的响应
+// 没有设置 Instance 的时候使用请求的路径
+func (c *Context) RespProblem(p *Problem) error {
+	if p.Instance == "" {
+		p.Instance = c.Req.URL.Path
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	c.Resp.Header().Set("Content-Type", ProblemContentType)
+	c.RespStatusCode = p.Status
+	c.RespData = data
+	return nil
+}
+
+// ServerWithProblemDetails 开启之后，404、405、413 以及 ctx.Err 都会被自动转换成 application/problem+json 响应
+// 如果前面的 middleware 已经写入了响应，例如 errorhandler 渲染了页面，那么不会覆盖
+func ServerWithProblemDetails() HTTPServerOption {
+	return func(server *HttpServer) {
+		server.problemDetails = true
+	}
+}
+
+// respProblemIfNeeded 在刷新响应之前，把错误转换成 Problem
+func (h *HttpServer) respProblemIfNeeded(ctx *Context) {
+	if !h.problemDetails || len(ctx.RespData) > 0 {
+		return
+	}
+	if ctx.Err != nil {
+		_ = ctx.RespProblem(ProblemFromError(ctx.Err, ctx.RespStatusCode))
+		return
+	}
+	switch ctx.RespStatusCode {
+	case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusRequestEntityTooLarge:
+		_ = ctx.RespProblem(NewProblem(ctx.RespStatusCode))
+	}
+}
@@ -0,0 +1,121 @@
+package web
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestProblemFromError(t *testing.T) {
+	testCases := []struct {
+		name   string
+		err    error
+		status int
+		want   *Problem
+	}{
+		{
+			name:   "problem",
+			err:    NewProblem(http.StatusConflict),
+			status: http.StatusInternalServerError,
+			want:   NewProblem(http.StatusConflict),
+		},
+		{
+			name: "bind",
+			err:  &BindError{Err: errors.New("unexpected EOF")},
+			want: &Problem{Type: "about:blank", Title: "Bad Request", Status: 400, Detail: "unexpected EOF"},
+		},
+		{
+			name: "validation",
+			err:  &ValidationError{Fields: map[string]string{"name": "不能为空"}},
+			want: NewProblem(http.StatusUnprocessableEntity).With("errors", map[string]string{"name": "不能为空"}),
+		},
+		{
+			// 未知错误不能泄露错误信息
+			name: "unknown",
+			err:  errors.New("db: connection refused"),
+			want: NewProblem(http.StatusInternalServerError),
+		},
+		{
+			name:   "unknown with status",
+			err:    errors.New("db: connection refused"),
+			status: http.StatusServiceUnavailable,
+			want:   NewProblem(http.StatusServiceUnavailable),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, ProblemFromError(tc.err, tc.status))
+		})
+	}
+}
+
+func TestServerWithProblemDetails(t *testing.T) {
+	server := NewHttpServer(ServerWithProblemDetails(), ServerWithMethodNotAllowed())
+	server.Get("/user", func(ctx *Context) {})
+	server.Post("/user", func(ctx *Context) {
+		var u struct{ Name string }
+		// 失败的时候 BindJSON 已经设置了 ctx.Err
+		_ = ctx.BindJSON(&u)
+	})
+	server.Put("/user", func(ctx *Context) {
+		ctx.Err = &ValidationError{Fields: map[string]string{"name": "不能为空"}}
+	})
+	server.Delete("/user", func(ctx *Context) {
+		_ = ctx.RespProblem(NewProblem(http.StatusForbidden).With("balance", 30))
+	})
+
+	testCases := []struct {
+		name       string
+		req        *http.Request
+		wantStatus int
+		wantBody   string
+		wantAllow  string
+	}{
+		{
+			name:       "not found",
+			req:        httptest.NewRequest(http.MethodGet, "/abc", nil),
+			wantStatus: http.StatusNotFound,
+			wantBody:   `{"instance":"/abc","status":404,"title":"Not Found","type":"about:blank"}`,
+		},
+		{
+			name:       "method not allowed",
+			req:        httptest.NewRequest(http.MethodPatch, "/user", nil),
+			wantStatus: http.StatusMethodNotAllowed,
+			wantBody:   `{"instance":"/user","status":405,"title":"Method Not Allowed","type":"about:blank"}`,
+			wantAllow:  "DELETE, GET, POST, PUT",
+		},
+		{
+			name:       "bind",
+			req:        httptest.NewRequest(http.MethodPost, "/user", strings.NewReader(`{`)),
+			wantStatus: http.StatusBadRequest,
+			wantBody:   `{"detail":"unexpected EOF","instance":"/user","status":400,"title":"Bad Request","type":"about:blank"}`,
+		},
+		{
+			name:       "validation",
+			req:        httptest.NewRequest(http.MethodPut, "/user", nil),
+			wantStatus: http.StatusUnprocessableEntity,
+			wantBody:   `{"errors":{"name":"不能为空"},"instance":"/user","status":422,"title":"Unprocessable Entity","type":"about:blank"}`,
+		},
+		{
+			name:       "resp problem",
+			req:        httptest.NewRequest(http.MethodDelete, "/user", nil),
+			wantStatus: http.StatusForbidden,
+			wantBody:   `{"balance":30,"instance":"/user","status":403,"title":"Forbidden","type":"about:blank"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, tc.req)
+			assert.Equal(t, tc.wantStatus, recorder.Code)
+			assert.Equal(t, tc.wantBody, recorder.Body.String())
+			assert.Equal(t, ProblemContentType, recorder.Header().Get("Content-Type"))
+			assert.Equal(t, tc.wantAllow, recorder.Header().Get("Allow"))
+		})
+	}
+}
@@ -0,0 +1,244 @@
+package web
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// 代理相关的请求头
+const (
+	headerForwarded       = "Forwarded"
+	headerXForwardedFor   = "X-Forwarded-For"
+	headerXForwardedProto = "X-Forwarded-Proto"
+	headerXForwardedHost  = "X-Forwarded-Host"
+	headerXRealIP         = "X-Real-IP"
+)
+
+// ServerWithTrustedProxies 设置可信代理的网段
+// 只有当直接和我们建立连接的对端落在这些网段里面，才会使用 ServerWithProxyHeader 选中的头部
+// 否则这些头部可能是客户端伪造的，直接忽略
+// cidrs 既可以是 10.0.0.0/8 这种网段，也可以是单个 IP
+func ServerWithTrustedProxies(cidrs ...string) HTTPServerOption {
+	return func(server *HttpServer) {
+		nets := make([]*net.IPNet, 0, len(cidrs))
+		for _, cidr := range cidrs {
+			nets = append(nets, parseTrustedProxy(cidr))
+		}
+		server.trustedProxies = nets
+	}
+}
+
+func parseTrustedProxy(cidr string) *net.IPNet {
+	if !strings.Contains(cidr, "/") {
+		ip := net.ParseIP(cidr)
+		if ip == nil {
+			panic(fmt.Sprintf("web: 非法的可信代理地址 [%s]", cidr))
+		}
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+			bits = 8 * net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+	}
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(fmt.Errorf("web: 非法的可信代理网段 %w", err))
+	}
+	return ipNet
+}
+
+// ProxyHeader 可信代理传递客户端信息使用的头部
+// 只会读取选中的头部，其余的即便存在也会被忽略，因为代理不会覆盖它们，客户端可以随意伪造
+type ProxyHeader int
+
+const (
+	// ProxyHeaderXForwarded X-Forwarded-For、X-Forwarded-Proto 和 X-Forwarded-Host，默认值
+	ProxyHeaderXForwarded ProxyHeader = iota
+	// ProxyHeaderForwarded RFC 7239 定义的 Forwarded
+	ProxyHeaderForwarded
+	// ProxyHeaderXRealIP 只有 X-Real-IP，协议和 Host 使用请求本身的
+	ProxyHeaderXRealIP
+)
+
+// ServerWithProxyHeader 设置可信代理使用的头部，要和代理实际设置的头部保持一致
+func ServerWithProxyHeader(header ProxyHeader) HTTPServerOption {
+	return func(server *HttpServer) {
+		server.proxyHeader = header
+	}
+}
+
+// ClientIP 返回真实的客户端 IP
+// 如果对端不是可信代理，那么直接返回对端 IP
+// 否则从 ServerWithProxyHeader 选中的头部里面，从右往左跳过可信代理，第一个不可信的地址就是客户端
+func (c *Context) ClientIP() string {
+	remote := remoteIP(c.Req.RemoteAddr)
+	if !c.isTrustedProxy(remote) {
+		return remote
+	}
+
+	var chain []string
+	switch c.proxyHeader {
+	case ProxyHeaderForwarded:
+		chain = forwardedFor(parseForwarded(c.Req.Header.Values(headerForwarded)))
+	case ProxyHeaderXRealIP:
+		if ip := net.ParseIP(strings.TrimSpace(c.Req.Header.Get(headerXRealIP))); ip != nil {
+			return ip.String()
+		}
+		return remote
+	default:
+		chain = splitHeaderList(c.Req.Header.Values(headerXForwardedFor))
+	}
+	if _, ip := c.clientHop(chain); ip != "" {
+		return ip
+	}
+	return remote
+}
+
+// Scheme 返回客户端使用的协议，http 或者 https
+func (c *Context) Scheme() string {
+	if proto := c.proxyParam("proto", headerXForwardedProto); proto != "" {
+		return strings.ToLower(proto)
+	}
+	if c.Req.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// Host 返回客户端请求的 Host
+func (c *Context) Host() string {
+	if host := c.proxyParam("host", headerXForwardedHost); host != "" {
+		return host
+	}
+	return c.Req.Host
+}
+
+// clientHop 从右往左跳过可信代理，返回第一个不可信的地址的下标和地址，也就是面向客户端的那一跳代理记录的
+// 链条中间出现了无法识别的地址，后面的都不可信了，返回它右边的那个
+// 全部都是可信代理，那么最左边那个就是客户端
+// 链条为空或者最右边就无法识别的时候返回 -1
+func (c *Context) clientHop(chain []string) (int, string) {
+	idx, res := -1, ""
+	for i := len(chain) - 1; i >= 0; i-- {
+		ip := net.ParseIP(stripPort(chain[i]))
+		if ip == nil {
+			break
+		}
+		idx, res = i, ip.String()
+		if !c.isTrustedProxy(res) {
+			break
+		}
+	}
+	return idx, res
+}
+
+// proxyParam 返回面向客户端的那一跳可信代理记录的 proto 或者 host
+// 左边的值可能是客户端伪造的，所以和 ClientIP 一样从右往左找
+func (c *Context) proxyParam(key string, xHeader string) string {
+	if !c.isTrustedProxy(remoteIP(c.Req.RemoteAddr)) {
+		return ""
+	}
+	switch c.proxyHeader {
+	case ProxyHeaderForwarded:
+		elems := parseForwarded(c.Req.Header.Values(headerForwarded))
+		if len(elems) == 0 {
+			return ""
+		}
+		idx, _ := c.clientHop(forwardedFor(elems))
+		if idx < 0 {
+			// 没有 for 的时候使用最近的一跳
+			idx = len(elems) - 1
+		}
+		return elems[idx][key]
+	case ProxyHeaderXForwarded:
+		values := splitHeaderList(c.Req.Header.Values(xHeader))
+		if len(values) == 0 {
+			return ""
+		}
+		// 每一跳都追加的时候，和 X-Forwarded-For 一一对应
+		if chain := splitHeaderList(c.Req.Header.Values(headerXForwardedFor)); len(chain) == len(values) {
+			if idx, _ := c.clientHop(chain); idx >= 0 {
+				return values[idx]
+			}
+		}
+		// 否则使用最近的一跳代理设置的，也就是最右边的
+		return values[len(values)-1]
+	}
+	return ""
+}
+
+func (c *Context) isTrustedProxy(ip string) bool {
+	if len(c.trustedProxies) == 0 {
+		return false
+	}
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range c.trustedProxies {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteIP 去掉 RemoteAddr 里面的端口
+func remoteIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// stripPort 处理 1.2.3.4:80、[::1]:80、[::1] 这几种形式
+func stripPort(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+}
+
+func splitHeaderList(values []string) []string {
+	res := make([]string, 0, len(values))
+	for _, val := range values {
+		for _, item := range strings.Split(val, ",") {
+			item = strings.TrimSpace(item)
+			if item != "" {
+				res = append(res, item)
+			}
+		}
+	}
+	return res
+}
+
+// parseForwarded 解析 RFC 7239 的 Forwarded 头部
+// 例如 Forwarded: for=192.0.2.60;proto=http;by=203.0.113.43, for="[2001:db8:cafe::17]:4711"
+// 每一个元素对应一跳代理
+func parseForwarded(values []string) []map[string]string {
+	var res []map[string]string
+	for _, elem := range splitHeaderList(values) {
+		pairs := map[string]string{}
+		for _, pair := range strings.Split(elem, ";") {
+			key, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if !ok {
+				continue
+			}
+			pairs[strings.ToLower(strings.TrimSpace(key))] = strings.Trim(strings.TrimSpace(val), `"`)
+		}
+		res = append(res, pairs)
+	}
+	return res
+}
+
+// forwardedFor 每一跳的 for，没有的时候是空字符串，保证和元素一一对应
+func forwardedFor(elems []map[string]string) []string {
+	res := make([]string, 0, len(elems))
+	for _, elem := range elems {
+		res = append(res, elem["for"])
+	}
+	return res
+}
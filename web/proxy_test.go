@@ -0,0 +1,284 @@
+package web
+
+import (
+	"crypto/tls"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestContext_ClientIP(t *testing.T) {
+	testCases := []struct {
+		name        string
+		trusted     []string
+		proxyHeader ProxyHeader
+		remoteAddr  string
+		header      http.Header
+		wantIP      string
+	}{
+		{
+			name:       "no trusted proxy",
+			remoteAddr: "203.0.113.1:1234",
+			header:     http.Header{"X-Forwarded-For": []string{"1.1.1.1"}},
+			wantIP:     "203.0.113.1",
+		},
+		{
+			// 对端不可信，伪造的头部直接忽略
+			name:       "spoofed",
+			trusted:    []string{"10.0.0.0/8"},
+			remoteAddr: "203.0.113.1:1234",
+			header:     http.Header{"X-Forwarded-For": []string{"1.1.1.1"}},
+			wantIP:     "203.0.113.1",
+		},
+		{
+			name:       "x-forwarded-for",
+			trusted:    []string{"10.0.0.0/8"},
+			remoteAddr: "10.0.0.1:1234",
+			header:     http.Header{"X-Forwarded-For": []string{"1.1.1.1, 10.0.0.2"}},
+			wantIP:     "1.1.1.1",
+		},
+		{
+			// 客户端在最左边自己塞了一个地址，只能信任到第一个不可信的地址
+			name:       "x-forwarded-for with spoofed prefix",
+			trusted:    []string{"10.0.0.0/8"},
+			remoteAddr: "10.0.0.1:1234",
+			header:     http.Header{"X-Forwarded-For": []string{"8.8.8.8, 2.2.2.2", "10.0.0.2"}},
+			wantIP:     "2.2.2.2",
+		},
+		{
+			name:       "all trusted",
+			trusted:    []string{"10.0.0.0/8"},
+			remoteAddr: "10.0.0.1:1234",
+			header:     http.Header{"X-Forwarded-For": []string{"10.0.0.3, 10.0.0.2"}},
+			wantIP:     "10.0.0.3",
+		},
+		{
+			name:        "forwarded",
+			trusted:     []string{"10.0.0.1"},
+			proxyHeader: ProxyHeaderForwarded,
+			remoteAddr:  "10.0.0.1:1234",
+			header: http.Header{
+				"Forwarded":       []string{`for="[2001:db8:cafe::17]:4711";proto=https`},
+				"X-Forwarded-For": []string{"1.1.1.1"},
+			},
+			wantIP: "2001:db8:cafe::17",
+		},
+		{
+			name:        "forwarded spoofed prefix",
+			trusted:     []string{"10.0.0.0/8"},
+			proxyHeader: ProxyHeaderForwarded,
+			remoteAddr:  "10.0.0.1:1234",
+			header: http.Header{
+				"Forwarded": []string{"for=8.8.8.8, for=203.0.113.9, for=10.0.0.2"},
+			},
+			wantIP: "203.0.113.9",
+		},
+		{
+			// 代理只维护 X-Forwarded-For，客户端伪造的 Forwarded 要忽略
+			name:       "forged forwarded",
+			trusted:    []string{"10.0.0.1"},
+			remoteAddr: "10.0.0.1:1234",
+			header: http.Header{
+				"Forwarded":       []string{"for=8.8.8.8"},
+				"X-Forwarded-For": []string{"1.1.1.1"},
+			},
+			wantIP: "1.1.1.1",
+		},
+		{
+			// 选中的头部不存在的时候，也不会使用别的头部
+			name:        "no fallthrough",
+			trusted:     []string{"10.0.0.1"},
+			proxyHeader: ProxyHeaderForwarded,
+			remoteAddr:  "10.0.0.1:1234",
+			header: http.Header{
+				"X-Forwarded-For": []string{"8.8.8.8"},
+				"X-Real-Ip":       []string{"8.8.8.8"},
+			},
+			wantIP: "10.0.0.1",
+		},
+		{
+			name:        "x-real-ip",
+			trusted:     []string{"10.0.0.1"},
+			proxyHeader: ProxyHeaderXRealIP,
+			remoteAddr:  "10.0.0.1:1234",
+			header: http.Header{
+				"X-Real-Ip":       []string{"1.1.1.1"},
+				"X-Forwarded-For": []string{"8.8.8.8"},
+			},
+			wantIP: "1.1.1.1",
+		},
+		{
+			name:       "x-real-ip not selected",
+			trusted:    []string{"10.0.0.1"},
+			remoteAddr: "10.0.0.1:1234",
+			header:     http.Header{"X-Real-Ip": []string{"8.8.8.8"}},
+			wantIP:     "10.0.0.1",
+		},
+		{
+			name:       "trusted without header",
+			trusted:    []string{"10.0.0.1"},
+			remoteAddr: "10.0.0.1:1234",
+			header:     http.Header{},
+			wantIP:     "10.0.0.1",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := NewHttpServer(ServerWithTrustedProxies(tc.trusted...), ServerWithProxyHeader(tc.proxyHeader))
+			ctx := &Context{
+				Req:            &http.Request{RemoteAddr: tc.remoteAddr, Header: tc.header},
+				trustedProxies: s.trustedProxies,
+				proxyHeader:    s.proxyHeader,
+			}
+			assert.Equal(t, tc.wantIP, ctx.ClientIP())
+		})
+	}
+}
+
+func TestContext_SchemeAndHost(t *testing.T) {
+	testCases := []struct {
+		name        string
+		trusted     []string
+		proxyHeader ProxyHeader
+		remoteAddr  string
+		header      http.Header
+		tls         bool
+		wantScheme  string
+		wantHost    string
+	}{
+		{
+			name:       "untrusted",
+			remoteAddr: "203.0.113.1:1234",
+			header: http.Header{
+				"X-Forwarded-Proto": []string{"https"},
+				"X-Forwarded-Host":  []string{"evil.com"},
+			},
+			wantScheme: "http",
+			wantHost:   "example.com",
+		},
+		{
+			name:       "untrusted tls",
+			remoteAddr: "203.0.113.1:1234",
+			header:     http.Header{},
+			tls:        true,
+			wantScheme: "https",
+			wantHost:   "example.com",
+		},
+		{
+			name:       "x-forwarded",
+			trusted:    []string{"10.0.0.0/8"},
+			remoteAddr: "10.0.0.1:1234",
+			header: http.Header{
+				"X-Forwarded-Proto": []string{"HTTPS"},
+				"X-Forwarded-Host":  []string{"api.example.com"},
+			},
+			wantScheme: "https",
+			wantHost:   "api.example.com",
+		},
+		{
+			// 左边的值是客户端伪造的，使用最近的一跳代理设置的
+			name:       "x-forwarded spoofed prefix",
+			trusted:    []string{"10.0.0.0/8"},
+			remoteAddr: "10.0.0.1:1234",
+			header: http.Header{
+				"X-Forwarded-For":   []string{"203.0.113.9"},
+				"X-Forwarded-Proto": []string{"https, http"},
+				"X-Forwarded-Host":  []string{"evil.com, real.com"},
+			},
+			wantScheme: "http",
+			wantHost:   "real.com",
+		},
+		{
+			// 每一跳都追加的时候，使用面向客户端的那一跳代理记录的
+			name:       "x-forwarded aligned",
+			trusted:    []string{"10.0.0.0/8"},
+			remoteAddr: "10.0.0.1:1234",
+			header: http.Header{
+				"X-Forwarded-For":   []string{"6.6.6.6, 203.0.113.9, 10.0.0.2"},
+				"X-Forwarded-Proto": []string{"http, https, http"},
+				"X-Forwarded-Host":  []string{"evil.com, real.com, lb.local"},
+			},
+			wantScheme: "https",
+			wantHost:   "real.com",
+		},
+		{
+			name:        "forwarded",
+			trusted:     []string{"10.0.0.0/8"},
+			proxyHeader: ProxyHeaderForwarded,
+			remoteAddr:  "10.0.0.1:1234",
+			header: http.Header{
+				"Forwarded":         []string{`for=1.1.1.1;proto=https;host="api.example.com"`},
+				"X-Forwarded-Proto": []string{"http"},
+			},
+			wantScheme: "https",
+			wantHost:   "api.example.com",
+		},
+		{
+			name:        "forwarded spoofed prefix",
+			trusted:     []string{"10.0.0.1"},
+			proxyHeader: ProxyHeaderForwarded,
+			remoteAddr:  "10.0.0.1:1234",
+			header: http.Header{
+				"Forwarded": []string{"for=6.6.6.6;host=evil.com;proto=https, for=203.0.113.9;host=real.com;proto=http"},
+			},
+			wantScheme: "http",
+			wantHost:   "real.com",
+		},
+		{
+			name:        "forwarded behind multiple proxies",
+			trusted:     []string{"10.0.0.0/8"},
+			proxyHeader: ProxyHeaderForwarded,
+			remoteAddr:  "10.0.0.1:1234",
+			header: http.Header{
+				"Forwarded": []string{"for=203.0.113.9;host=real.com;proto=https, for=10.0.0.2;host=lb.local;proto=http"},
+			},
+			wantScheme: "https",
+			wantHost:   "real.com",
+		},
+		{
+			name:       "forwarded not selected",
+			trusted:    []string{"10.0.0.0/8"},
+			remoteAddr: "10.0.0.1:1234",
+			header: http.Header{
+				"Forwarded": []string{"for=1.1.1.1;proto=https;host=evil.com"},
+			},
+			wantScheme: "http",
+			wantHost:   "example.com",
+		},
+		{
+			name:        "x-real-ip ignores x-forwarded",
+			trusted:     []string{"10.0.0.0/8"},
+			proxyHeader: ProxyHeaderXRealIP,
+			remoteAddr:  "10.0.0.1:1234",
+			header: http.Header{
+				"X-Forwarded-Proto": []string{"https"},
+				"X-Forwarded-Host":  []string{"evil.com"},
+			},
+			wantScheme: "http",
+			wantHost:   "example.com",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := NewHttpServer(ServerWithTrustedProxies(tc.trusted...), ServerWithProxyHeader(tc.proxyHeader))
+			req := &http.Request{RemoteAddr: tc.remoteAddr, Header: tc.header, Host: "example.com"}
+			if tc.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+			ctx := &Context{Req: req, trustedProxies: s.trustedProxies, proxyHeader: s.proxyHeader}
+			assert.Equal(t, tc.wantScheme, ctx.Scheme())
+			assert.Equal(t, tc.wantHost, ctx.Host())
+		})
+	}
+}
+
+func TestServerWithTrustedProxies_Invalid(t *testing.T) {
+	assert.Panics(t, func() {
+		NewHttpServer(ServerWithTrustedProxies("abc"))
+	})
+	assert.Panics(t, func() {
+		NewHttpServer(ServerWithTrustedProxies("10.0.0.0/99"))
+	})
+}
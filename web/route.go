@@ -0,0 +1,145 @@
+package web
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// RouteInfo 路由的元数据，可以用来做鉴权，也可以导出来做安全审计
+type RouteInfo struct {
+	Method string `json:"method"`
+	Route  string `json:"route"`
+	// Roles 访问路由需要的角色，满足其中一个就可以
+	Roles []string `json:"roles,omitempty"`
+	// Scopes 访问路由需要的权限，需要全部满足
+	Scopes []string `json:"scopes,omitempty"`
+	// MaxBodySize 请求体的最大字节数，0 代表使用 server 的设置，小于 0 代表不限制
+	MaxBodySize int64 `json:"max_body_size,omitempty"`
+}
+
+// RouteOption 注册路由的时候声明路由的元数据
+type RouteOption func(info *RouteInfo)
+
+// WithRoles 访问路由需要的角色
+func WithRoles(roles ...string) RouteOption {
+	return func(info *RouteInfo) {
+		info.Roles = appendUnique(info.Roles, roles...)
+	}
+}
+
+// WithScopes 访问路由需要的权限
+func WithScopes(scopes ...string) RouteOption {
+	return func(info *RouteInfo) {
+		info.Scopes = appendUnique(info.Scopes, scopes...)
+	}
+}
+
+func appendUnique(dst []string, vals ...string) []string {
+	for _, val := range vals {
+		found := false
+		for _, v := range dst {
+			if v == val {
+				found = true
+				break
+			}
+		}
+		if !found {
+			dst = append(dst, val)
+		}
+	}
+	return dst
+}
+
+// Handle 注册路由，opts 可以声明路由的元数据，例如 WithRoles
+func (h *HttpServer) Handle(method string, path string, handleFunc HandleFunc, opts ...RouteOption) {
+	n := h.add(method, path, handleFunc)
+	for _, opt := range opts {
+		opt(n.info)
+	}
+}
+
+// Routes 返回所有注册了的路由，按照路由和方法排序
+func (h *HttpServer) Routes() []RouteInfo {
+	res := make([]RouteInfo, 0, h.routeCount())
+	for _, root := range h.trees {
+		root.walk(func(n *node) {
+			if n.handleFunc != nil && n.info != nil {
+				res = append(res, *n.info)
+			}
+		})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Route != res[j].Route {
+			return res[i].Route < res[j].Route
+		}
+		return res[i].Method < res[j].Method
+	})
+	return res
+}
+
+func (n *node) walk(fn func(n *node)) {
+	fn(n)
+	for _, child := range n.children {
+		child.walk(fn)
+	}
+	for _, child := range []*node{n.starChild, n.paramChild, n.regChild} {
+		if child != nil {
+			child.walk(fn)
+		}
+	}
+}
+
+// RouteInfo 命中的路由的元数据，没有命中路由的时候只有 Method
+func (c *Context) RouteInfo() RouteInfo {
+	if c.routeInfo == nil {
+		return RouteInfo{Method: c.Req.Method}
+	}
+	return *c.routeInfo
+}
+
+// Group 路由分组，组内的路由共享前缀和元数据
+type Group struct {
+	server *HttpServer
+	prefix string
+	opts   []RouteOption
+}
+
+// Group 创建路由分组，prefix 必须以 / 开头，不能以 / 结尾
+func (h *HttpServer) Group(prefix string, opts ...RouteOption) *Group {
+	return &Group{server: h, prefix: strings.TrimSuffix(prefix, "/"), opts: opts}
+}
+
+// Group 创建子分组，会继承当前分组的元数据
+func (g *Group) Group(prefix string, opts ...RouteOption) *Group {
+	return &Group{
+		server: g.server,
+		prefix: g.prefix + strings.TrimSuffix(prefix, "/"),
+		opts:   append(append([]RouteOption{}, g.opts...), opts...),
+	}
+}
+
+// Handle 注册路由，path 为 / 的时候就是分组本身
+func (g *Group) Handle(method string, path string, handleFunc HandleFunc, opts ...RouteOption) {
+	fullPath := g.prefix + path
+	if path == "/" && g.prefix != "" {
+		fullPath = g.prefix
+	}
+	g.server.Handle(method, fullPath, handleFunc, append(append([]RouteOption{}, g.opts...), opts...)...)
+}
+
+func (g *Group) Get(path string, handleFunc HandleFunc, opts ...RouteOption) {
+	g.Handle(http.MethodGet, path, handleFunc, opts...)
+}
+
+func (g *Group) Post(path string, handleFunc HandleFunc, opts ...RouteOption) {
+	g.Handle(http.MethodPost, path, handleFunc, opts...)
+}
+
+func (g *Group) Put(path string, handleFunc HandleFunc, opts ...RouteOption) {
+	g.Handle(http.MethodPut, path, handleFunc, opts...)
+}
+
+func (g *Group) Delete(path string, handleFunc HandleFunc, opts ...RouteOption) {
+	g.Handle(http.MethodDelete, path, handleFunc, opts...)
+}
@@ -0,0 +1,61 @@
+package web
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHttpServer_Routes(t *testing.T) {
+	h := NewHttpServer()
+	handler := func(ctx *Context) {}
+	h.Get("/", handler)
+	h.Get("/user/:id", handler, WithRoles("user", "admin"))
+	h.Post("/user/:id", handler, WithRoles("admin"), WithScopes("user:write"))
+
+	admin := h.Group("/admin/", WithRoles("admin"))
+	admin.Get("/", handler)
+	admin.Delete("/order/:id(\\d+)", handler, WithScopes("order:delete"))
+	// 子分组继承父分组的元数据，并且去重
+	report := admin.Group("/report", WithRoles("admin", "auditor"), WithScopes("report:read"))
+	report.Get("/*", handler)
+	report.Put("/daily", handler, WithScopes("report:write"))
+
+	assert.Equal(t, []RouteInfo{
+		{Method: http.MethodGet, Route: "/"},
+		{Method: http.MethodGet, Route: "/admin", Roles: []string{"admin"}},
+		{Method: http.MethodDelete, Route: "/admin/order/:id(\\d+)", Roles: []string{"admin"}, Scopes: []string{"order:delete"}},
+		{Method: http.MethodGet, Route: "/admin/report/*", Roles: []string{"admin", "auditor"}, Scopes: []string{"report:read"}},
+		{Method: http.MethodPut, Route: "/admin/report/daily", Roles: []string{"admin", "auditor"},
+			Scopes: []string{"report:read", "report:write"}},
+		{Method: http.MethodGet, Route: "/user/:id", Roles: []string{"user", "admin"}},
+		{Method: http.MethodPost, Route: "/user/:id", Roles: []string{"admin"}, Scopes: []string{"user:write"}},
+	}, h.Routes())
+}
+
+func TestContext_RouteInfo(t *testing.T) {
+	h := NewHttpServer()
+	var info RouteInfo
+	handler := func(ctx *Context) {
+		info = ctx.RouteInfo()
+	}
+	h.Group("/api", WithRoles("admin")).Get("/user/:id", handler, WithScopes("user:read"))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/user/123", nil))
+	assert.Equal(t, RouteInfo{
+		Method: http.MethodGet,
+		Route:  "/api/user/:id",
+		Roles:  []string{"admin"},
+		Scopes: []string{"user:read"},
+	}, info)
+
+	// 没有命中路由
+	h = NewHttpServer(ServerWithMiddleware(func(next HandleFunc) HandleFunc {
+		return func(ctx *Context) {
+			info = ctx.RouteInfo()
+			next(ctx)
+		}
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/user", nil))
+	assert.Equal(t, RouteInfo{Method: http.MethodGet}, info)
+}
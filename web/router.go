@@ -3,6 +3,7 @@ package web
 import (
 	"fmt"
 	"regexp"
+	"sort"
 	"strings"
 )
 
@@ -20,6 +21,11 @@ func newRouter() Router {
 
 // addRoute path必须以/开头，不能以/结尾，中间也不能有连续的//
 func (r *Router) addRoute(method, path string, handleFunc HandleFunc, middlewares ...Middleware) {
+	r.add(method, path, handleFunc, middlewares...)
+}
+
+// add 注册路由，返回路由对应的节点
+func (r *Router) add(method, path string, handleFunc HandleFunc, middlewares ...Middleware) *node {
 	if path == "" {
 		panic("web: 路由是空字符串")
 	}
@@ -46,7 +52,8 @@ func (r *Router) addRoute(method, path string, handleFunc HandleFunc, middleware
 		root.handleFunc = handleFunc
 		root.route = "/"
 		root.middleware = middlewares
-		return
+		root.info = &RouteInfo{Method: method, Route: path}
+		return root
 	}
 
 	// 结尾
@@ -71,6 +78,8 @@ func (r *Router) addRoute(method, path string, handleFunc HandleFunc, middleware
 	root.handleFunc = handleFunc
 	root.route = path
 	root.middleware = middlewares
+	root.info = &RouteInfo{Method: method, Route: path}
+	return root
 }
 
 // 目的，为了通配符的匹配
@@ -112,6 +121,9 @@ type node struct {
 
 	// 注册在该节点上的middleware
 	middleware []Middleware
+
+	// info 路由的元数据，例如需要的角色和权限
+	info *RouteInfo
 }
 
 // childOrCreate 返回segment对应的子节点，第一个值返回正确的子节点，第二个
@@ -215,6 +227,44 @@ func (r *Router) findRoute(method string, path string) (*matchInfo, bool) {
 	return mi, true
 }
 
+// routeCount 返回注册了处理函数的路由数量
+func (r *Router) routeCount() int {
+	cnt := 0
+	for _, root := range r.trees {
+		cnt += root.routeCount()
+	}
+	return cnt
+}
+
+func (n *node) routeCount() int {
+	cnt := 0
+	if n.handleFunc != nil {
+		cnt++
+	}
+	for _, child := range n.children {
+		cnt += child.routeCount()
+	}
+	for _, child := range []*node{n.starChild, n.paramChild, n.regChild} {
+		if child != nil {
+			cnt += child.routeCount()
+		}
+	}
+	return cnt
+}
+
+// allowedMethods 返回能够匹配上 path 的 HTTP 方法，用于返回 405 和 Allow 头部
+func (r *Router) allowedMethods(path string) []string {
+	var res []string
+	for method := range r.trees {
+		mi, ok := r.findRoute(method, path)
+		if ok && mi.n.handleFunc != nil {
+			res = append(res, method)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
 func (r *Router) findMiddlewares(root *node, segments []string) []Middleware {
 	queue := []*node{root}
 	res := make([]Middleware, 0, 16)
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"testing"
 )
@@ -598,3 +599,26 @@ func Test_router_findRoute(t *testing.T) {
 		})
 	}
 }
+
+func TestServerWithMethodNotAllowed(t *testing.T) {
+	handler := func(ctx *Context) {}
+	// 默认方法不对也是 404
+	server := NewHttpServer()
+	server.Get("/user", handler)
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/user", nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+	assert.Empty(t, recorder.Header().Get("Allow"))
+
+	server = NewHttpServer(ServerWithMethodNotAllowed())
+	server.Get("/user", handler)
+	server.Put("/user", handler)
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/user", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+	assert.Equal(t, "GET, PUT", recorder.Header().Get("Allow"))
+
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/order", nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+}
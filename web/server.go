@@ -1,9 +1,14 @@
 package web
 
 import (
-	"fmt"
+	"context"
+	"errors"
+	"log/slog"
 	"net"
 	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
 )
 
 type HandleFunc func(ctx *Context)
@@ -18,6 +23,9 @@ type Server interface {
 	http.Handler
 	Start(address string) error
 
+	// Shutdown 优雅退出，等待已有的请求处理完毕，再执行退出回调
+	Shutdown(ctx context.Context) error
+
 	// addRoute 路由注册功能
 	/**
 	 * method 是http方法
@@ -43,7 +51,32 @@ type HttpServer struct {
 
 	middlewares []Middleware
 
-	log func(msg string, arg ...any)
+	logger *slog.Logger
+
+	tplEngine TemplateEngine
+
+	// problemDetails 是否自动把错误转换成 application/problem+json
+	problemDetails bool
+	// methodNotAllowed 路径能匹配上但是方法不对的时候返回 405，而不是 404
+	methodNotAllowed bool
+
+	// trustedProxies 可信代理的网段
+	trustedProxies []*net.IPNet
+	// proxyHeader 可信代理使用的头部
+	proxyHeader ProxyHeader
+
+	// maxBodySize 请求体的最大字节数，小于等于 0 不限制，路由可以单独设置
+	maxBodySize int64
+	// httpCfg 创建 http.Server 时使用的超时之类的设置
+	httpCfg httpConfig
+
+	mutex sync.Mutex
+	srv   *http.Server
+	// admin 单独暴露指标之类的管理接口的 server
+	admin       *http.Server
+	activeConns atomic.Int64
+	// onShutdown 退出的时候执行的回调，例如刷新缓存的日志
+	onShutdown []func(ctx context.Context) error
 }
 
 func (s *HttpServer) Use(middlewares ...Middleware) {
@@ -63,8 +96,9 @@ func (s *HttpServer) UserV1(method string, path string, middlewares ...Middlewar
 func NewHttpServer(opts ...HTTPServerOption) *HttpServer {
 	res := &HttpServer{
 		Router: newRouter(),
-		log: func(msg string, arg ...any) {
-			fmt.Printf(msg, arg...)
+		logger: slog.Default(),
+		httpCfg: httpConfig{
+			readHeaderTimeout: defaultReadHeaderTimeout,
 		},
 	}
 	for _, opt := range opts {
@@ -73,6 +107,29 @@ func NewHttpServer(opts ...HTTPServerOption) *HttpServer {
 	return res
 }
 
+// ServerWithLogger 设置框架使用的日志，默认是 slog.Default()
+// Context.Logger 也是在这个基础上加上请求相关的属性
+func ServerWithLogger(logger *slog.Logger) HTTPServerOption {
+	return func(server *HttpServer) {
+		server.logger = logger
+	}
+}
+
+// ServerWithTemplateEngine 设置模板引擎，Context.Render 会使用它来渲染页面
+func ServerWithTemplateEngine(engine TemplateEngine) HTTPServerOption {
+	return func(server *HttpServer) {
+		server.tplEngine = engine
+	}
+}
+
+// ServerWithMethodNotAllowed 路径能匹配上，但是 HTTP 方法不对的时候返回 405 和 Allow 头部
+// 默认和之前一样返回 404
+func ServerWithMethodNotAllowed() HTTPServerOption {
+	return func(server *HttpServer) {
+		server.methodNotAllowed = true
+	}
+}
+
 func ServerWithMiddleware(middlewares ...Middleware) HTTPServerOption {
 	return func(server *HttpServer) {
 		server.middlewares = middlewares
@@ -96,29 +153,42 @@ func ServerWithMiddleware(middlewares ...Middleware) HTTPServerOption {
 //}
 
 // Get get路由方法
-func (h *HttpServer) Get(path string, handleFunc HandleFunc) {
-	h.addRoute(http.MethodGet, path, handleFunc)
+func (h *HttpServer) Get(path string, handleFunc HandleFunc, opts ...RouteOption) {
+	h.Handle(http.MethodGet, path, handleFunc, opts...)
 }
 
-func (h *HttpServer) Post(path string, handleFunc HandleFunc) {
-	h.addRoute(http.MethodPost, path, handleFunc)
+func (h *HttpServer) Post(path string, handleFunc HandleFunc, opts ...RouteOption) {
+	h.Handle(http.MethodPost, path, handleFunc, opts...)
 }
 
-func (h *HttpServer) Put(path string, handleFunc HandleFunc) {
-	h.addRoute(http.MethodPut, path, handleFunc)
+func (h *HttpServer) Put(path string, handleFunc HandleFunc, opts ...RouteOption) {
+	h.Handle(http.MethodPut, path, handleFunc, opts...)
 }
 
-func (h *HttpServer) Delete(path string, handleFunc HandleFunc) {
-	h.addRoute(http.MethodDelete, path, handleFunc)
+func (h *HttpServer) Delete(path string, handleFunc HandleFunc, opts ...RouteOption) {
+	h.Handle(http.MethodDelete, path, handleFunc, opts...)
 }
 
 // ServeHTTP 处理请求的入口
 func (h *HttpServer) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
 	// 框架代码在这里
 	ctx := &Context{
-		Req:  request,
-		Resp: writer,
+		Req:            request,
+		Resp:           writer,
+		trustedProxies: h.trustedProxies,
+		proxyHeader:    h.proxyHeader,
+		logger:         h.logger,
+		tblEngine:      h.tplEngine,
 	}
+	// 在执行 middleware 之前就查找路由，这样 middleware 也能拿到 MatchedRoute
+	info, ok := h.findRoute(request.Method, request.URL.Path)
+	if ok && info.n.handleFunc != nil {
+		ctx.PathParams = info.pathParams
+		ctx.MatchedRoute = info.n.route
+		ctx.handleFunc = info.n.handleFunc
+		ctx.routeInfo = info.n.info
+	}
+	h.limitBody(ctx)
 	// 最后一个是这个
 	root := h.serve
 
@@ -135,6 +205,8 @@ func (h *HttpServer) ServeHTTP(writer http.ResponseWriter, request *http.Request
 		return func(ctx *Context) {
 			// 就设置好了RespData 和 RespStatusCode
 			next(ctx)
+			respTooLargeIfNeeded(ctx)
+			h.respProblemIfNeeded(ctx)
 			h.flashResp(ctx)
 		}
 	}
@@ -149,24 +221,29 @@ func (h *HttpServer) flashResp(ctx *Context) {
 	}
 	n, err := ctx.Resp.Write(ctx.RespData)
 	if err != nil || n != len(ctx.RespData) {
-		h.log("写入响应失败 %v", err)
+		ctx.Logger().Error("web: 写入响应失败", slog.Any("err", err))
 	}
 }
 
 func (h *HttpServer) serve(ctx *Context) {
-	// 接下来是查找路由，并且执行命中的业务逻辑
-	info, ok := h.findRoute(ctx.Req.Method, ctx.Req.URL.Path)
-
-	if !ok || info.n.handleFunc == nil {
+	// 路由已经在 ServeHTTP 里面查找过了
+	if ctx.handleFunc == nil {
+		// 路径能匹配上，但是 HTTP 方法不对
+		if !h.methodNotAllowed {
+			ctx.RespStatusCode = 404
+			return
+		}
+		if allowed := h.allowedMethods(ctx.Req.URL.Path); len(allowed) > 0 {
+			ctx.Resp.Header().Set("Allow", strings.Join(allowed, ", "))
+			ctx.RespStatusCode = http.StatusMethodNotAllowed
+			return
+		}
 		// 路由没有命中
 		ctx.RespStatusCode = 404
 		return
 	}
-
-	ctx.PathParams = info.pathParams
-	ctx.MatchedRoute = info.n.route
 	// 命中的话，处理业务逻辑返回
-	info.n.handleFunc(ctx)
+	ctx.handleFunc(ctx)
 }
 
 func (h *HttpServer) Start(address string) error {
@@ -178,5 +255,54 @@ func (h *HttpServer) Start(address string) error {
 	// 比如在这里往admin注册自己的这个实例
 	// 在这里执行一些业务所需的前置条件
 
-	return http.Serve(l, h)
+	h.mutex.Lock()
+	h.srv = h.newHTTPServer(h)
+	h.srv.ConnState = h.trackConn
+	srv, admin := h.srv, h.admin
+	h.mutex.Unlock()
+
+	if admin != nil {
+		adminL, err := net.Listen("tcp", admin.Addr)
+		if err != nil {
+			_ = l.Close()
+			return err
+		}
+		go func() {
+			if err := admin.Serve(adminL); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				h.logger.Error("web: admin server 退出", slog.Any("err", err))
+			}
+		}()
+	}
+	return srv.Serve(l)
+}
+
+// RegisterOnShutdown 注册退出回调，会在所有请求处理完之后按照注册顺序执行
+func (h *HttpServer) RegisterOnShutdown(fns ...func(ctx context.Context) error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.onShutdown = append(h.onShutdown, fns...)
+}
+
+func (h *HttpServer) Shutdown(ctx context.Context) error {
+	h.mutex.Lock()
+	srv, admin := h.srv, h.admin
+	fns := h.onShutdown
+	h.mutex.Unlock()
+
+	var errs []error
+	for _, s := range []*http.Server{srv, admin} {
+		if s == nil {
+			continue
+		}
+		if err := s.Shutdown(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	// 即便上面超时了，回调也要执行，不然日志之类的就丢了
+	for _, fn := range fns {
+		if err := fn(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
 }
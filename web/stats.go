@@ -0,0 +1,16 @@
+package web
+
+import "sync/atomic"
+
+// panicsRecovered 被 recover middleware 恢复的 panic 次数
+var panicsRecovered atomic.Uint64
+
+// RecordPanic 记录一次被恢复的 panic，一般由 recover middleware 调用
+func RecordPanic() {
+	panicsRecovered.Add(1)
+}
+
+// PanicsRecovered 返回被恢复的 panic 次数，可以暴露给监控系统
+func PanicsRecovered() uint64 {
+	return panicsRecovered.Load()
+}
@@ -8,3 +8,12 @@ type TemplateEngine interface {
 	// data 渲染页面的数据
 	Render(ctx context.Context, tplName string, data any) ([]byte, error)
 }
+
+type cspNonceKey struct{}
+
+// CSPNonceFromContext 返回 Context.Render 传给模板引擎的 CSP nonce，没有的时候返回空字符串
+// 模板引擎可以把它注册成模板函数，例如 <script nonce="{{ cspNonce }}">
+func CSPNonceFromContext(ctx context.Context) string {
+	nonce, _ := ctx.Value(cspNonceKey{}).(string)
+	return nonce
+}
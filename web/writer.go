@@ -0,0 +1,63 @@
+package web
+
+import "net/http"
+
+// ResponseWriter 记录业务直接写入 Resp 的情况，给 middleware 统计和判断使用
+// 大多数情况下数据都在 RespData 里面，是在所有 middleware 执行完之后才写入的，需要单独计算
+type ResponseWriter struct {
+	http.ResponseWriter
+	status int
+	size   int
+	wrote  bool
+}
+
+func NewResponseWriter(w http.ResponseWriter) *ResponseWriter {
+	return &ResponseWriter{ResponseWriter: w}
+}
+
+// Status 直接写入的响应码，没有写入过的时候是 0
+func (w *ResponseWriter) Status() int {
+	return w.status
+}
+
+// Size 直接写入的响应体的字节数
+func (w *ResponseWriter) Size() int {
+	return w.size
+}
+
+// Wrote 是否直接写入过响应，包括 WriteHeader 和 Flush
+// 写入过的时候响应头已经发出去了，middleware 不能再修改或者缓存响应
+func (w *ResponseWriter) Wrote() bool {
+	return w.wrote
+}
+
+func (w *ResponseWriter) WriteHeader(statusCode int) {
+	w.wrote = true
+	if w.status == 0 {
+		w.status = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *ResponseWriter) Write(data []byte) (int, error) {
+	w.wrote = true
+	// 和 net/http 一样，没有调用 WriteHeader 就是 200
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(data)
+	w.size += n
+	return n, err
+}
+
+func (w *ResponseWriter) Flush() {
+	w.wrote = true
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap 方便 http.ResponseController 拿到原始的 ResponseWriter
+func (w *ResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
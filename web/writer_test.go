@@ -0,0 +1,38 @@
+package web
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResponseWriter(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	w := NewResponseWriter(recorder)
+	assert.False(t, w.Wrote())
+	assert.Equal(t, 0, w.Status())
+
+	w.WriteHeader(http.StatusCreated)
+	w.WriteHeader(http.StatusOK)
+	_, err := w.Write([]byte("hello"))
+	assert.NoError(t, err)
+	_, err = w.Write([]byte("world"))
+	assert.NoError(t, err)
+	assert.True(t, w.Wrote())
+	assert.Equal(t, http.StatusCreated, w.Status())
+	assert.Equal(t, 10, w.Size())
+	assert.Equal(t, "helloworld", recorder.Body.String())
+
+	// 没有 WriteHeader 的时候是 200
+	w = NewResponseWriter(httptest.NewRecorder())
+	_, _ = w.Write([]byte("a"))
+	assert.Equal(t, http.StatusOK, w.Status())
+
+	recorder = httptest.NewRecorder()
+	w = NewResponseWriter(recorder)
+	assert.NoError(t, http.NewResponseController(w).Flush())
+	assert.True(t, w.Wrote())
+	assert.True(t, recorder.Flushed)
+	assert.Equal(t, 0, w.Size())
+}